@@ -0,0 +1,102 @@
+// Command bootstrap creates the first superadmin account. Public
+// registration never grants a role other than the default, so this is how a
+// fresh deployment gets someone who can issue invitations.
+//
+//	SUPERADMIN_PASSWORD=... go run ./cmd/bootstrap -email admin@example.com -full-name "Site Owner"
+//
+// The password is read from SUPERADMIN_PASSWORD or, when unset, from the
+// first line of stdin. The command refuses to run once a superadmin exists.
+// It reads the server's configuration, so -config and the setting flags of
+// the server work here too.
+package main
+
+import (
+	"avenger/internal/config"
+	"avenger/internal/domain"
+	"avenger/internal/repository"
+	"avenger/internal/service"
+	"avenger/pkg/db"
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+func main() {
+	email := flag.String("email", "", "email of the superadmin (required)")
+	fullName := flag.String("full-name", "", "full name, 6-15 characters (required)")
+	occupation := flag.String("occupation", "Administrator", "occupation")
+	age := flag.Int("age", 17, "age, at least 17")
+	loader := config.NewLoader(flag.CommandLine)
+	flag.Parse()
+
+	if *email == "" || *fullName == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := loader.Load()
+	if err != nil {
+		log.Fatal("Failed to load configuration: ", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatal("Invalid configuration:\n", err)
+	}
+
+	password, err := readPassword()
+	if err != nil {
+		log.Fatal("Failed to read password: ", err)
+	}
+
+	conn := db.InitPostgresGORM(cfg.Database.Postgres(cfg.Database.UserRecipe))
+	sqlDB, _ := conn.DB()
+	defer func() {
+		if err := sqlDB.Close(); err != nil {
+			slog.Error("Failed to close database connection", slog.Any("error", err))
+		}
+	}()
+
+	userRepo := repository.NewUserRepository(conn)
+	roleRepo := repository.NewRoleRepository(conn)
+
+	ctx := context.Background()
+	if err := service.NewRoleService(roleRepo).SeedDefaults(ctx); err != nil {
+		log.Fatal("Failed to seed roles: ", err)
+	}
+
+	userSvc := service.NewUserService(userRepo, repository.NewSessionRepository(conn), repository.NewAPIKeyRepository(conn), roleRepo, repository.NewInvitationRepository(conn), cfg.Auth.BcryptCost)
+	user := &domain.User{
+		Email:      *email,
+		Password:   password,
+		FullName:   *fullName,
+		Age:        *age,
+		Occupation: *occupation,
+	}
+	if err := userSvc.CreateSuperadmin(ctx, user); err != nil {
+		log.Fatal("Failed to create superadmin: ", err)
+	}
+
+	fmt.Printf("Created superadmin %s (ID %d)\n", user.Email, user.ID)
+}
+
+func readPassword() (string, error) {
+	if password := os.Getenv("SUPERADMIN_PASSWORD"); password != "" {
+		return password, nil
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", errors.New("password is empty")
+	}
+	return password, nil
+}
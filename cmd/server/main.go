@@ -1,12 +1,24 @@
 package main
 
 import (
+	"avenger/internal/apperr"
+	"avenger/internal/config"
+	"avenger/internal/domain"
 	"avenger/internal/handler"
+	"avenger/internal/health"
+	"avenger/internal/metrics"
 	"avenger/internal/middleware"
+	"avenger/internal/problem"
 	"avenger/internal/repository"
 	"avenger/internal/service"
+	"avenger/migrations"
 	"avenger/pkg/db"
+	"avenger/pkg/mailer"
+	"avenger/pkg/reqctx"
+	"avenger/pkg/tracing"
+	"avenger/pkg/utils"
 	"context"
+	"flag"
 	"log"
 	"log/slog"
 	"net/http"
@@ -15,23 +27,45 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/joho/godotenv"
 	"github.com/julienschmidt/httprouter"
+	"gorm.io/gorm"
 )
 
 func main() {
-	if err := godotenv.Load(); err != nil {
-		slog.Warn("No .env file found")
+	loader := config.NewLoader(flag.CommandLine)
+	printConfig := flag.Bool("print-config", false, "print the effective configuration with secrets redacted and exit")
+	flag.Parse()
+
+	cfg, err := loader.Load()
+	if err != nil {
+		log.Fatal("Failed to load configuration: ", err)
+	}
+	if *printConfig {
+		if err := cfg.WriteYAML(os.Stdout); err != nil {
+			log.Fatal("Failed to print configuration: ", err)
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatal("Invalid configuration:\n", err)
+	}
+	if *printConfig {
+		return
 	}
 
-	connInv := db.InitPostgres()
+	// Tracing first, so the database drivers pick up the tracer provider
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing.Exporter, cfg.Tracing.ServiceName)
+	if err != nil {
+		log.Fatal("Failed to set up tracing: ", err)
+	}
+
+	connInv := db.InitPostgres(cfg.Database.Postgres(cfg.Database.Inventory))
 	defer func() {
 		if err := connInv.Close(); err != nil {
 			slog.Error("Failed to close inventory database connection", slog.Any("error", err))
 		}
 	}()
 
-	connUserRecipe := db.InitPostgresGORM()
+	connUserRecipe := db.InitPostgresGORM(cfg.Database.Postgres(cfg.Database.UserRecipe))
 	sqlDB, _ := connUserRecipe.DB()
 	defer func() {
 		if err := sqlDB.Close(); err != nil {
@@ -39,111 +73,383 @@ func main() {
 		}
 	}()
 
+	metrics.RegisterDB("inventory", connInv)
+	metrics.RegisterDB("user_recipe", sqlDB)
+
+	// Access token signing keys; refuse to start without them
+	keys, err := loadJWTKeys(cfg.Auth)
+	if err != nil {
+		log.Fatal("Failed to load JWT signing keys: ", err)
+	}
+	utils.SetKeySet(keys)
+
+	// Conditional writes: PUT/DELETE require If-Match unless disabled
+	handler.RequireIfMatch = cfg.Server.RequireIfMatch
+
 	// Initialize repositories
 	repoInv := repository.NewInventoryRepository(connInv)
 	userRepo := repository.NewUserRepository(connUserRecipe)
 	recipeRepo := repository.NewRecipeRepository(connUserRecipe)
+	sessionRepo := repository.NewSessionRepository(connUserRecipe)
+	roleRepo := repository.NewRoleRepository(connUserRecipe)
+	userTokenRepo := repository.NewUserTokenRepository(connUserRecipe)
+	invitationRepo := repository.NewInvitationRepository(connUserRecipe)
+	loginAttemptRepo := repository.NewLoginAttemptRepository(connUserRecipe)
+	twoFactorRepo := repository.NewTwoFactorRepository(connUserRecipe)
+	apiKeyRepo := repository.NewAPIKeyRepository(connUserRecipe)
+
+	metrics.RegisterInventory(repoInv)
 
 	// Initialize services
 	svcInv := service.NewInventoryService(repoInv)
-	userSvc := service.NewUserService(userRepo)
+	userSvc := service.NewUserService(userRepo, sessionRepo, apiKeyRepo, roleRepo, invitationRepo, cfg.Auth.BcryptCost)
 	recipeSvc := service.NewRecipeService(recipeRepo)
+	roleSvc := service.NewRoleService(roleRepo)
+	if err := roleSvc.SeedDefaults(context.Background()); err != nil {
+		log.Fatal("Failed to seed roles: ", err)
+	}
+	sessionSvc := service.NewSessionService(sessionRepo, userRepo, roleRepo, cfg.Auth.Session())
+
+	mail := newMailer(cfg.Mail)
+	resetSvc := service.NewPasswordResetService(userRepo, userTokenRepo, sessionRepo, mail,
+		cfg.Auth.PasswordResetURL, cfg.Auth.PasswordResetTTL, cfg.Auth.BcryptCost)
+
+	verifySvc := service.NewEmailVerificationService(userRepo, userTokenRepo, mail,
+		cfg.Auth.VerifyEmailURL, cfg.Auth.EmailVerificationTTL)
+
+	invitationSvc := service.NewInvitationService(invitationRepo, roleRepo, mail, cfg.Auth.InviteURL)
+
+	loginGuard := service.NewLoginGuard(newLoginCounterStore(connUserRecipe, cfg.Login.CounterStore), loginAttemptRepo, userRepo, cfg.Login.Guard())
+
+	twoFactorSvc := service.NewTwoFactorService(twoFactorRepo, userRepo, roleRepo, sessionRepo, cfg.Auth.TOTPIssuer)
+
+	apiKeySvc := service.NewAPIKeyService(apiKeyRepo)
+
+	auth := middleware.NewAuthenticator(sessionSvc, apiKeySvc)
+
+	ips, err := middleware.NewIPResolver(cfg.Server.TrustedProxies)
+	if err != nil {
+		log.Fatal("Invalid trusted proxies: ", err)
+	}
+	limiter := middleware.NewRateLimiter(newRateLimitStore(connUserRecipe, cfg.RateLimit.Store), ips)
+	limit := limiter.Limit
+
+	// Rate limit policies. Authenticated routes are limited per user or API
+	// key, public ones per client IP.
+	loginLimit := cfg.RateLimit.Login.Policy("login")
+	registerLimit := cfg.RateLimit.Register.Policy("register")
+	emailLimit := cfg.RateLimit.Email.Policy("email")
+	refreshLimit := cfg.RateLimit.Refresh.Policy("refresh")
+	publicReadLimit := cfg.RateLimit.PublicRead.Policy("public-read")
+	apiLimit := cfg.RateLimit.API.Policy("api")
+	authLimit := cfg.RateLimit.Auth.Policy("auth")
+
+	// authorize and requireUser limit requests per client IP before checking
+	// their credentials, so guessing tokens or API keys is throttled too.
+	authorize := func(perm string, h http.HandlerFunc) http.HandlerFunc {
+		return limiter.LimitIP(authLimit)(auth.Authorize(perm)(limit(apiLimit)(h)))
+	}
+	requireUser := func(h http.HandlerFunc) http.HandlerFunc {
+		return limiter.LimitIP(authLimit)(auth.RequireUser(limit(apiLimit)(h)))
+	}
+
+	// Readiness: both pools answer and the schema is migrated
+	checker := health.NewChecker(cfg.Server.ReadinessTimeout,
+		health.Ping("inventory_db", connInv),
+		health.Ping("user_recipe_db", sqlDB),
+		health.Migrations(connInv, migrations.Latest()),
+	)
 
 	// Initialize handlers
 	inventoryHandler := handler.NewInventoryHandler(svcInv)
-	authHandler := handler.NewAuthHandler(userSvc)
+	authHandler := handler.NewAuthHandler(userSvc, sessionSvc, resetSvc, verifySvc, loginGuard, twoFactorSvc, cfg.Auth.BcryptCost)
 	recipeHandler := handler.NewRecipeHandler(recipeSvc)
+	userHandler := handler.NewUserHandler(userSvc, loginGuard)
+	jwksHandler := handler.NewJWKSHandler(keys)
+	healthHandler := handler.NewHealthHandler(checker)
+	invitationHandler := handler.NewInvitationHandler(invitationSvc)
+	twoFactorHandler := handler.NewTwoFactorHandler(twoFactorSvc)
+	roleHandler := handler.NewRoleHandler(roleSvc)
+	apiKeyHandler := handler.NewAPIKeyHandler(apiKeySvc)
 
 	router := httprouter.New()
 
-	router.PanicHandler = func(w http.ResponseWriter, r *http.Request, err any) {
-		slog.Error("PANIC occurred", slog.Any("error", err), slog.String("method", r.Method), slog.String("path", r.URL.Path), slog.String("remote_addr", r.RemoteAddr))
+	// Requests are canceled, database queries included, after the request
+	// timeout or the route's own one
+	requestTimeout := cfg.Server.RequestTimeout
+	routeTimeouts := cfg.Server.RouteTimeouts
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"message": "Internal server error", "errors": null}`))
+	// handle registers h for method and path, labelling its metrics with the
+	// route template rather than the raw path and bounding its run time
+	handle := func(method, path string, h http.Handler) {
+		timeout, ok := routeTimeouts[method+" "+path]
+		if !ok {
+			timeout = requestTimeout
+		}
+		router.Handler(method, path, middleware.Route(path, middleware.Timeout(timeout)(h)))
 	}
 
 	// Custom 404 handler
 	router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		slog.Warn("Endpoint not found",
+		reqctx.Logger(r.Context()).Warn("Endpoint not found",
 			slog.String("method", r.Method),
 			slog.String("path", r.URL.Path),
-			slog.String("remote_addr", r.RemoteAddr),
 		)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNotFound)
-		w.Write([]byte(`{"message": "Endpoint not found", "errors": null}`))
+		problem.Write(w, r, http.StatusNotFound, apperr.CodeRouteNotFound, "Endpoint not found", nil)
 	})
 
 	// Custom method not allowed handler
 	router.MethodNotAllowed = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		slog.Warn("Method not allowed",
+		reqctx.Logger(r.Context()).Warn("Method not allowed",
 			slog.String("method", r.Method),
 			slog.String("path", r.URL.Path),
-			slog.String("remote_addr", r.RemoteAddr),
 		)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		w.Write([]byte(`{"message": "Method not allowed", "errors": null}`))
+		problem.Write(w, r, http.StatusMethodNotAllowed, apperr.CodeMethodNotAllowed, "Method not allowed", nil)
 	})
 
-	// ========== INVENTORY ROUTES (Public) ==========
-	router.GET("/inventories", inventoryHandler.GetAll)
-	router.GET("/inventories/:id", inventoryHandler.GetByID)
-	router.POST("/inventories", inventoryHandler.Create)
-	router.PUT("/inventories/:id", inventoryHandler.Update)
-	router.DELETE("/inventories/:id", inventoryHandler.Delete)
+	// ========== INVENTORY ROUTES ==========
+	// Protected: Each operation requires its inventory permission
+	handle("GET", "/inventories",
+		authorize(domain.PermInventoryRead, withParams(inventoryHandler.GetAll)),
+	)
+	handle("GET", "/inventories/:id",
+		authorize(domain.PermInventoryRead, withParams(inventoryHandler.GetByID)),
+	)
+	handle("POST", "/inventories",
+		authorize(domain.PermInventoryWrite, withParams(inventoryHandler.Create)),
+	)
+	handle("PUT", "/inventories/:id",
+		authorize(domain.PermInventoryWrite, withParams(inventoryHandler.Update)),
+	)
+	handle("PATCH", "/inventories/:id",
+		authorize(domain.PermInventoryWrite, withParams(inventoryHandler.Patch)),
+	)
+	handle("DELETE", "/inventories/:id",
+		authorize(domain.PermInventoryDelete, withParams(inventoryHandler.Delete)),
+	)
+	handle("GET", "/inventories/:id/movements",
+		authorize(domain.PermInventoryRead, withParams(inventoryHandler.GetMovements)),
+	)
+	handle("GET", "/inventories/:id/reconciliation",
+		authorize(domain.PermInventoryRead, withParams(inventoryHandler.Reconcile)),
+	)
+	// Stock movements are attributed to the authenticated user
+	handle("POST", "/inventories/:id/movements",
+		authorize(domain.PermInventoryAdjust, withParams(inventoryHandler.AdjustStock)),
+	)
 
 	// ========== AUTH ROUTES (Public) ==========
-	router.POST("/register", authHandler.Register)
-	router.POST("/login", authHandler.Login)
+	// Limited per client IP, strictly where passwords and emails are involved
+	handle("POST", "/register", limit(registerLimit)(withParams(authHandler.Register)))
+	handle("POST", "/login", limit(loginLimit)(withParams(authHandler.Login)))
+	handle("POST", "/login/2fa", limit(loginLimit)(withParams(authHandler.LoginTwoFactor)))
+	handle("POST", "/token/refresh", limit(refreshLimit)(withParams(authHandler.Refresh)))
+	handle("GET", "/verify-email", limit(emailLimit)(withParams(authHandler.VerifyEmail)))
+	handle("POST", "/verify-email/resend", limit(emailLimit)(withParams(authHandler.ResendVerification)))
+	handle("POST", "/password/forgot", limit(emailLimit)(withParams(authHandler.ForgotPassword)))
+	handle("POST", "/password/reset", limit(emailLimit)(withParams(authHandler.ResetPassword)))
+	handle("GET", "/.well-known/jwks.json", limit(publicReadLimit)(withParams(jwksHandler.Get)))
+
+	// Protected: Revokes the caller's session
+	handle("POST", "/logout",
+		requireUser(withParams(authHandler.Logout)),
+	)
 
 	// ========== RECIPE ROUTES ==========
 	// Public: Anyone can view recipes
-	router.Handler("GET", "/recipes", wrapHandler(recipeHandler.GetAll))
-
-	// Protected: Only superadmin can create recipes
-	router.Handler("POST", "/recipes", wrapHandler(
-		middleware.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
-			params := httprouter.ParamsFromContext(r.Context())
-			recipeHandler.Create(w, r, params)
-		}, "superadmin"),
-	))
-
-	// Protected: Only superadmin can delete recipes
-	router.Handler("DELETE", "/recipes/:id", wrapHandler(
-		middleware.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
-			params := httprouter.ParamsFromContext(r.Context())
-			recipeHandler.Delete(w, r, params)
-		}, "superadmin"),
-	))
-
-	// Create HTTP server
+	handle("GET", "/recipes", limit(publicReadLimit)(withParams(recipeHandler.GetAll)))
+	handle("GET", "/recipes/:id", limit(publicReadLimit)(withParams(recipeHandler.GetByID)))
+
+	// Protected: Creating and editing recipes requires recipe:write
+	handle("POST", "/recipes",
+		authorize(domain.PermRecipeWrite, withParams(recipeHandler.Create)),
+	)
+	handle("PATCH", "/recipes/:id",
+		authorize(domain.PermRecipeWrite, withParams(recipeHandler.Patch)),
+	)
+
+	// Protected: Deleting recipes requires recipe:delete
+	handle("DELETE", "/recipes/:id",
+		authorize(domain.PermRecipeDelete, withParams(recipeHandler.Delete)),
+	)
+
+	// ========== PROFILE ROUTES ==========
+	// Protected: Any logged in user (not API keys), acting on themselves
+	handle("GET", "/me",
+		requireUser(withParams(userHandler.GetMe)),
+	)
+	handle("PATCH", "/me",
+		requireUser(withParams(userHandler.PatchMe)),
+	)
+	handle("POST", "/me/password",
+		requireUser(withParams(userHandler.ChangePassword)),
+	)
+
+	// Protected: Two-factor settings; reachable without permissions so users
+	// of roles that require 2FA can enroll
+	handle("POST", "/me/2fa/enroll",
+		requireUser(withParams(twoFactorHandler.Enroll)),
+	)
+	handle("POST", "/me/2fa/confirm",
+		requireUser(withParams(twoFactorHandler.Confirm)),
+	)
+	handle("POST", "/me/2fa/recovery-codes",
+		requireUser(withParams(twoFactorHandler.RegenerateRecoveryCodes)),
+	)
+	handle("DELETE", "/me/2fa",
+		requireUser(withParams(twoFactorHandler.Disable)),
+	)
+
+	// ========== USER MANAGEMENT ROUTES ==========
+	// Protected: Requires user:manage (superadmin)
+	handle("GET", "/users",
+		authorize(domain.PermUserManage, withParams(userHandler.GetAll)),
+	)
+	handle("GET", "/users/:id",
+		authorize(domain.PermUserManage, withParams(userHandler.GetByID)),
+	)
+	handle("PATCH", "/users/:id",
+		authorize(domain.PermUserManage, withParams(userHandler.Patch)),
+	)
+	handle("DELETE", "/users/:id",
+		authorize(domain.PermUserManage, withParams(userHandler.Delete)),
+	)
+	handle("POST", "/users/:id/unlock",
+		authorize(domain.PermUserManage, withParams(userHandler.Unlock)),
+	)
+
+	// ========== ROLE ROUTES ==========
+	// Protected: Requires user:manage (superadmin)
+	handle("GET", "/roles",
+		authorize(domain.PermUserManage, withParams(roleHandler.GetAll)),
+	)
+	handle("PATCH", "/roles/:name",
+		authorize(domain.PermUserManage, withParams(roleHandler.Patch)),
+	)
+
+	// ========== API KEY ROUTES ==========
+	// Protected: Requires user:manage (superadmin); keys authenticate with
+	// X-API-Key or "Authorization: ApiKey <key>"
+	handle("POST", "/api-keys",
+		authorize(domain.PermUserManage, withParams(apiKeyHandler.Create)),
+	)
+	handle("GET", "/api-keys",
+		authorize(domain.PermUserManage, withParams(apiKeyHandler.GetAll)),
+	)
+	handle("DELETE", "/api-keys/:id",
+		authorize(domain.PermUserManage, withParams(apiKeyHandler.Revoke)),
+	)
+
+	// ========== INVITATION ROUTES ==========
+	// Protected: Requires user:manage (superadmin); invite codes set the role of new accounts
+	handle("POST", "/invitations",
+		authorize(domain.PermUserManage, withParams(invitationHandler.Create)),
+	)
+	handle("GET", "/invitations",
+		authorize(domain.PermUserManage, withParams(invitationHandler.GetAll)),
+	)
+	handle("DELETE", "/invitations/:id",
+		authorize(domain.PermUserManage, withParams(invitationHandler.Revoke)),
+	)
+
+	// ========== PROBES ==========
+	// Public: Liveness and readiness for the orchestrator, never rate limited;
+	// readiness reports are cached for a second instead
+	handle("GET", "/healthz", withParams(healthHandler.Live))
+	handle("GET", "/readyz", withParams(healthHandler.Ready))
+
+	// ========== METRICS ==========
+	// Prometheus scrape endpoint, on its own address unless none is set
+	var metricsServer *http.Server
+	if cfg.Server.MetricsAddr != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("GET /metrics", metrics.Handler())
+		metricsServer = &http.Server{
+			Addr:         cfg.Server.MetricsAddr,
+			Handler:      metricsMux,
+			ReadTimeout:  cfg.Server.ReadTimeout,
+			WriteTimeout: cfg.Server.WriteTimeout,
+			IdleTimeout:  cfg.Server.IdleTimeout,
+		}
+	} else {
+		handle("GET", "/metrics", limit(publicReadLimit)(metrics.Handler().ServeHTTP))
+	}
+
+	// Create HTTP server. Every request gets an ID, its real client address
+	// and a trace span before it is logged; panics are recovered inside the
+	// access log and metrics so they show up as 500s. CORS preflights are
+	// answered before routing.
 	server := &http.Server{
-		Addr:         ":8080",
-		Handler:      router,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		Addr: cfg.Server.Addr,
+		Handler: middleware.Chain(router,
+			middleware.RequestID,
+			ips.RealIP,
+			middleware.Tracing,
+			middleware.AccessLog,
+			middleware.Metrics,
+			middleware.Recover,
+			middleware.CORS(cfg.CORS.Middleware()),
+		),
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		IdleTimeout:  cfg.Server.IdleTimeout,
 	}
 
 	// Start server in a goroutine
 	go func() {
-		slog.Info("🚀 Server starting on http://localhost:8080")
-		log.Println("Server running on localhost:8080")
+		slog.Info("🚀 Server starting", slog.String("addr", cfg.Server.Addr))
+		log.Println("Server running on", cfg.Server.Addr)
 		log.Println("=====================================")
 		log.Println("📚 Available Endpoints:")
-		log.Println("  POST   /register          - Register new user")
-		log.Println("  POST   /login             - Login and get token")
-		log.Println("  GET    /inventories       - Get all inventories")
-		log.Println("  GET    /inventories/:id   - Get inventory by ID")
-		log.Println("  POST   /inventories       - Create inventory")
-		log.Println("  PUT    /inventories/:id   - Update inventory")
-		log.Println("  DELETE /inventories/:id   - Delete inventory")
-		log.Println("  GET    /recipes           - Get all recipes (public)")
-		log.Println("  POST   /recipes           - Create recipe (superadmin)")
-		log.Println("  DELETE /recipes/:id       - Delete recipe (superadmin)")
+		log.Println("  POST   /register          - Register new user (role from invite_code, else default)")
+		log.Println("  POST   /login             - Login and get access/refresh tokens (throttled)")
+		log.Println("  POST   /login/2fa         - Complete login with a TOTP or recovery code")
+		log.Println("  POST   /token/refresh     - Rotate refresh token, get new token pair")
+		log.Println("  GET    /verify-email?token= - Confirm email address")
+		log.Println("  POST   /verify-email/resend - Resend verification email (throttled)")
+		log.Println("  POST   /password/forgot   - Email a password reset link")
+		log.Println("  POST   /password/reset    - Set a new password with a reset token")
+		log.Println("  POST   /logout            - Revoke current session (authenticated)")
+		log.Println("  GET    /.well-known/jwks.json - Public keys for verifying access tokens")
+		log.Println("  GET    /inventories       - List inventories, paginated (inventory:read)")
+		log.Println("  GET    /inventories/:id   - Get inventory by ID (inventory:read)")
+		log.Println("  POST   /inventories       - Create inventory (inventory:write)")
+		log.Println("  PUT    /inventories/:id   - Update inventory (inventory:write)")
+		log.Println("  PATCH  /inventories/:id   - Partially update inventory (inventory:write)")
+		log.Println("  DELETE /inventories/:id   - Delete inventory (inventory:delete)")
+		log.Println("  GET    /inventories/:id/movements      - Stock movement history (inventory:read)")
+		log.Println("  POST   /inventories/:id/movements      - Record stock movement (inventory:adjust)")
+		log.Println("  GET    /inventories/:id/reconciliation - Compare stock with ledger (inventory:read)")
+		log.Println("  GET    /recipes           - List recipes, cursor paginated (public)")
+		log.Println("  GET    /recipes/:id       - Get recipe by ID (public)")
+		log.Println("  POST   /recipes           - Create recipe (recipe:write)")
+		log.Println("  PATCH  /recipes/:id       - Partially update recipe (recipe:write)")
+		log.Println("  DELETE /recipes/:id       - Delete recipe (recipe:delete)")
+		log.Println("  GET    /me                - Own profile (authenticated)")
+		log.Println("  PATCH  /me                - Update own profile (authenticated)")
+		log.Println("  POST   /me/password       - Change password, logs out other sessions (authenticated)")
+		log.Println("  POST   /me/2fa/enroll     - Start TOTP enrollment, returns otpauth URI and QR (authenticated)")
+		log.Println("  POST   /me/2fa/confirm    - Enable 2FA with a first code, returns recovery codes (authenticated)")
+		log.Println("  POST   /me/2fa/recovery-codes - Regenerate recovery codes (authenticated)")
+		log.Println("  DELETE /me/2fa            - Disable 2FA (authenticated)")
+		log.Println("  GET    /users             - List users, search and paginate (user:manage)")
+		log.Println("  GET    /users/:id         - Get user by ID (user:manage)")
+		log.Println("  PATCH  /users/:id         - Update profile, role or disabled state (user:manage)")
+		log.Println("  DELETE /users/:id         - Soft-delete user (user:manage)")
+		log.Println("  POST   /users/:id/unlock  - Clear failed login lockout (user:manage)")
+		log.Println("  GET    /roles             - List roles (user:manage)")
+		log.Println("  PATCH  /roles/:name       - Require 2FA for a role (user:manage)")
+		log.Println("  POST   /api-keys          - Mint a scoped API key (user:manage)")
+		log.Println("  GET    /api-keys          - List API keys (user:manage)")
+		log.Println("  DELETE /api-keys/:id      - Revoke an API key (user:manage)")
+		log.Println("  POST   /invitations       - Issue an invite code for a role (user:manage)")
+		log.Println("  GET    /invitations       - List invitations (user:manage)")
+		log.Println("  DELETE /invitations/:id   - Revoke a pending invitation (user:manage)")
+		log.Println("  GET    /healthz           - Liveness probe (public)")
+		log.Println("  GET    /readyz            - Readiness probe with dependency checks (public)")
+		if metricsServer == nil {
+			log.Println("  GET    /metrics           - Prometheus metrics (public, rate limited)")
+		}
 		log.Println("=====================================")
 
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -151,6 +457,15 @@ func main() {
 		}
 	}()
 
+	if metricsServer != nil {
+		go func() {
+			slog.Info("Metrics server starting", slog.String("addr", metricsServer.Addr))
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatal("Metrics server failed to start:", err)
+			}
+		}()
+	}
+
 	// Graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -158,27 +473,99 @@ func main() {
 
 	slog.Info("Server is shutting down...")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	// Fail readiness first and keep serving while load balancers notice
+	checker.Drain()
+	slog.Info("Draining traffic", slog.Duration("delay", cfg.Server.DrainDelay))
+	time.Sleep(cfg.Server.DrainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
 	defer cancel()
 
 	if err := server.Shutdown(ctx); err != nil {
 		log.Fatal("Server forced to shutdown:", err)
 	}
+	// Metrics stay up until the API is down so the drain can be watched
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(ctx); err != nil {
+			slog.Error("Metrics server forced to shutdown", slog.Any("error", err))
+		}
+	}
+
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("Failed to flush traces", slog.Any("error", err))
+	}
 
 	slog.Info("Server exited properly")
 }
 
-func wrapHandler(f interface{}) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		switch h := f.(type) {
-		case func(http.ResponseWriter, *http.Request, httprouter.Params):
-			params := httprouter.ParamsFromContext(r.Context())
-			h(w, r, params)
-		case http.HandlerFunc:
-			h(w, r)
-		default:
-			slog.Error("Invalid handler type", slog.Any("type", h))
-			http.Error(w, `{"message": "Internal server error"}`, http.StatusInternalServerError)
-		}
-	})
+// withParams adapts an httprouter handle for use behind middleware that
+// works on http.HandlerFunc.
+func withParams(h httprouter.Handle) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h(w, r, httprouter.ParamsFromContext(r.Context()))
+	}
+}
+
+// loadJWTKeys loads RS256/EdDSA keys from the keys directory, signing with
+// the active key ID. Without a key directory it falls back to HS256 with the
+// JWT secret.
+func loadJWTKeys(auth config.Auth) (*utils.KeySet, error) {
+	if auth.JWTKeysDir != "" {
+		return utils.LoadKeySet(auth.JWTKeysDir, auth.JWTActiveKID)
+	}
+	slog.Warn("Signing access tokens with HS256, no JWKS will be published")
+	return utils.NewHMACKeySet([]byte(auth.JWTSecret))
+}
+
+// newLoginCounterStore picks where failed logins are counted: "postgres"
+// (shared by all instances) or "memory".
+func newLoginCounterStore(conn *gorm.DB, store string) repository.LoginCounterStore {
+	switch store {
+	case "postgres":
+		return repository.NewLoginCounterRepository(conn)
+	case "memory":
+		slog.Warn("Failed logins are counted per instance; use the postgres login counter store when running several")
+		return repository.NewMemoryLoginCounterStore()
+	default:
+		log.Fatal("Unknown login counter store: ", store)
+		return nil
+	}
+}
+
+// newRateLimitStore picks where rate limit buckets live: "memory" or
+// "postgres", which is shared by all instances at the cost of a write per
+// request.
+func newRateLimitStore(conn *gorm.DB, store string) repository.RateLimitStore {
+	switch store {
+	case "memory":
+		return repository.NewMemoryRateLimitStore()
+	case "postgres":
+		return repository.NewRateLimitRepository(conn)
+	default:
+		log.Fatal("Unknown rate limit store: ", store)
+		return nil
+	}
+}
+
+// newMailer picks the mail transport: "smtp", "file" (writes .eml files to
+// the mail directory) or "log".
+func newMailer(cfg config.Mail) mailer.Mailer {
+	switch cfg.Driver {
+	case "smtp":
+		return mailer.NewSMTPMailer(mailer.SMTPConfig{
+			Host:     cfg.SMTP.Host,
+			Port:     cfg.SMTP.Port,
+			Username: cfg.SMTP.Username,
+			Password: cfg.SMTP.Password,
+			From:     cfg.From,
+		})
+	case "file":
+		return mailer.NewFileMailer(cfg.Dir, cfg.From)
+	case "log":
+		slog.Warn("Emails are logged, not sent; use the smtp mail driver in production")
+		return mailer.NewLogMailer()
+	default:
+		log.Fatal("Unknown mail driver: ", cfg.Driver)
+		return nil
+	}
 }
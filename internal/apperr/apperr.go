@@ -0,0 +1,150 @@
+// Package apperr defines the error types shared by the repository, service
+// and handler layers. Repositories translate driver errors into these types,
+// services wrap them with context using %w, and handlers map them to HTTP
+// responses without inspecting error strings.
+package apperr
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindNotFound
+	KindConflict
+	KindValidation
+	KindUnauthorized
+	KindForbidden
+	KindTooManyRequests
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindNotFound:
+		return "not_found"
+	case KindConflict:
+		return "conflict"
+	case KindValidation:
+		return "validation"
+	case KindUnauthorized:
+		return "unauthorized"
+	case KindForbidden:
+		return "forbidden"
+	case KindTooManyRequests:
+		return "too_many_requests"
+	default:
+		return "internal"
+	}
+}
+
+// Error is an application error of a given Kind. Message is safe to show to
+// clients; Code is its stable machine-readable counterpart and defaults to
+// one derived from Kind. Err is the underlying cause and is only logged.
+// RetryAfter, when set, tells the client how long to wait before retrying.
+type Error struct {
+	Kind       Kind
+	Code       string
+	Message    string
+	Fields     map[string]string
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ErrorCode returns e.Code, or the default code for e.Kind when unset.
+func (e *Error) ErrorCode() string {
+	if e.Code != "" {
+		return e.Code
+	}
+	return kindCodes[e.Kind]
+}
+
+// WithCode sets a more specific code than the Kind default and returns e.
+func (e *Error) WithCode(code string) *Error {
+	e.Code = code
+	return e
+}
+
+// WithField adds a per-field detail to e and returns it.
+func (e *Error) WithField(name, problem string) *Error {
+	if e.Fields == nil {
+		e.Fields = map[string]string{}
+	}
+	e.Fields[name] = problem
+	return e
+}
+
+func NotFound(message string) *Error {
+	return &Error{Kind: KindNotFound, Message: message}
+}
+
+func Conflict(message string) *Error {
+	return &Error{Kind: KindConflict, Message: message}
+}
+
+// Validation reports invalid input. fields maps input names to what is wrong
+// with them and may be nil.
+func Validation(message string, fields map[string]string) *Error {
+	return &Error{Kind: KindValidation, Message: message, Fields: fields}
+}
+
+func Unauthorized(message string) *Error {
+	return &Error{Kind: KindUnauthorized, Message: message}
+}
+
+func Forbidden(message string) *Error {
+	return &Error{Kind: KindForbidden, Message: message}
+}
+
+// TooManyRequests reports that the client must wait retryAfter before
+// trying again.
+func TooManyRequests(message string, retryAfter time.Duration) *Error {
+	return &Error{Kind: KindTooManyRequests, Message: message, RetryAfter: retryAfter}
+}
+
+func Internal(message string, err error) *Error {
+	return &Error{Kind: KindInternal, Message: message, Err: err}
+}
+
+// KindOf returns the Kind of the first *Error in err's chain, KindInternal
+// when there is none.
+func KindOf(err error) Kind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	var conflict *VersionConflictError
+	if errors.As(err, &conflict) {
+		return KindConflict
+	}
+	return KindInternal
+}
+
+func IsNotFound(err error) bool {
+	return KindOf(err) == KindNotFound
+}
+
+// VersionConflictError is returned when a conditional write was made against
+// a version of the resource that is no longer current.
+type VersionConflictError struct {
+	Resource string
+	Current  int
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("%s version conflict: current version is %d", e.Resource, e.Current)
+}
@@ -0,0 +1,71 @@
+package apperr
+
+import "strings"
+
+// Stable machine-readable error codes returned to clients in the "code"
+// member of error responses. Clients localize on these, so existing values
+// must never change meaning; add new codes instead.
+const (
+	CodeInternal             = "internal_error"
+	CodeNotFound             = "not_found"
+	CodeConflict             = "conflict"
+	CodeValidation           = "validation_failed"
+	CodeUnauthorized         = "unauthorized"
+	CodeForbidden            = "forbidden"
+	CodeTooManyRequests      = "too_many_requests"
+	CodeRouteNotFound        = "route_not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeInvalidID            = "invalid_id"
+	CodeInvalidBody          = "invalid_body"
+	CodeInvalidQuery         = "invalid_query"
+	CodeInvalidCursor        = "invalid_cursor"
+	CodeInvalidPatch         = "invalid_patch"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodePreconditionRequired = "precondition_required"
+	CodePreconditionFailed   = "precondition_failed"
+	CodeVersionConflict      = "version_conflict"
+	CodeConcurrentUpdate     = "concurrent_update"
+	CodeMissingToken         = "missing_token"
+	CodeInvalidAuthScheme    = "invalid_auth_scheme"
+	CodeInvalidToken         = "invalid_token"
+	CodeInvalidAPIKey        = "invalid_api_key"
+	CodeAPIKeyNotAllowed     = "api_key_not_allowed"
+	CodeInvalidCredentials   = "invalid_credentials"
+	CodeInvalidRefreshToken  = "invalid_refresh_token"
+	CodeRefreshTokenReused   = "refresh_token_reused"
+	CodeSessionRevoked       = "session_revoked"
+	CodeAccountDisabled      = "account_disabled"
+	CodeLoginLocked          = "login_locked"
+	CodeRateLimited          = "rate_limited"
+	CodeRequestTimeout       = "request_timeout"
+	CodeClientClosedRequest  = "client_closed_request"
+	CodeInvalidChallenge     = "invalid_challenge_token"
+	CodeInvalidTwoFactorCode = "invalid_two_factor_code"
+	CodeTwoFactorEnabled     = "two_factor_already_enabled"
+	CodeTwoFactorNotEnrolled = "two_factor_not_enrolled"
+	CodeTwoFactorRequired    = "two_factor_required"
+	CodeSelfLockout          = "self_lockout"
+	CodeInvalidResetToken    = "invalid_reset_token"
+	CodeInvalidVerifyToken   = "invalid_verification_token"
+	CodeEmailNotVerified     = "email_not_verified"
+	CodeInvalidInvitation    = "invalid_invitation"
+	CodeSuperadminExists     = "superadmin_exists"
+	CodeEmailTaken           = "email_taken"
+	CodeInsufficientStock    = "insufficient_stock"
+)
+
+// kindCodes is the code reported for an *Error that has no explicit Code.
+var kindCodes = map[Kind]string{
+	KindInternal:        CodeInternal,
+	KindNotFound:        CodeNotFound,
+	KindConflict:        CodeConflict,
+	KindValidation:      CodeValidation,
+	KindUnauthorized:    CodeUnauthorized,
+	KindForbidden:       CodeForbidden,
+	KindTooManyRequests: CodeTooManyRequests,
+}
+
+// resourceCode builds a resource specific code such as "inventory_not_found".
+func resourceCode(resource, suffix string) string {
+	return strings.ReplaceAll(strings.ToLower(resource), " ", "_") + "_" + suffix
+}
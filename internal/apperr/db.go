@@ -0,0 +1,97 @@
+package apperr
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+// PostgreSQL SQLSTATE codes translated by FromDB.
+const (
+	sqlStateNotNullViolation    = "23502"
+	sqlStateForeignKeyViolation = "23503"
+	sqlStateUniqueViolation     = "23505"
+	sqlStateCheckViolation      = "23514"
+	sqlStateSerialization       = "40001"
+	sqlStateDeadlock            = "40P01"
+)
+
+// FromDB translates an error returned by database/sql (lib/pq) or GORM (pgx)
+// into an *Error. resource names the entity in client facing messages, e.g.
+// "Inventory". Errors that are already *Error pass through unchanged.
+func FromDB(err error, resource string) error {
+	if err == nil {
+		return nil
+	}
+
+	var appErr *Error
+	var conflict *VersionConflictError
+	if errors.As(err, &appErr) || errors.As(err, &conflict) {
+		return err
+	}
+
+	if errors.Is(err, sql.ErrNoRows) || errors.Is(err, gorm.ErrRecordNotFound) {
+		return &Error{Kind: KindNotFound, Code: resourceCode(resource, "not_found"), Message: resource + " not found", Err: err}
+	}
+
+	code, constraint := sqlState(err)
+	switch code {
+	case sqlStateUniqueViolation:
+		return &Error{Kind: KindConflict, Code: resourceCode(resource, "already_exists"), Message: resource + " already exists", Err: err,
+			Fields: constraintField(constraint, "already exists")}
+	case sqlStateForeignKeyViolation:
+		return &Error{Kind: KindConflict, Code: resourceCode(resource, "referenced"), Message: resource + " is referenced by or references a missing record", Err: err}
+	case sqlStateCheckViolation, sqlStateNotNullViolation:
+		return &Error{Kind: KindValidation, Message: "Validation failed", Err: err,
+			Fields: constraintField(constraint, "is invalid")}
+	case sqlStateSerialization, sqlStateDeadlock:
+		return &Error{Kind: KindConflict, Code: CodeConcurrentUpdate, Message: resource + " was modified concurrently, retry the request", Err: err}
+	}
+
+	return &Error{Kind: KindInternal, Message: "Internal server error", Err: err}
+}
+
+func sqlState(err error) (code, constraint string) {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return string(pqErr.Code), pqErr.Constraint
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code, pgErr.ConstraintName
+	}
+	return "", ""
+}
+
+// constraintField names the offending column using the conventional
+// <table>_<column>_key / _check and GORM uni_<table>_<column> constraint
+// names when possible.
+func constraintField(constraint, problem string) map[string]string {
+	if constraint == "" {
+		return nil
+	}
+	column := constraint
+	for _, prefix := range []string{"uni_", "idx_"} {
+		if trimmed, ok := strings.CutPrefix(column, prefix); ok {
+			column = trimmed
+			break
+		}
+	}
+	for _, suffix := range []string{"_key", "_check", "_fkey"} {
+		if trimmed, ok := strings.CutSuffix(column, suffix); ok {
+			column = trimmed
+			break
+		}
+	}
+	for _, table := range []string{"inventories_", "recipes_", "users_", "stock_movements_"} {
+		if trimmed, ok := strings.CutPrefix(column, table); ok {
+			column = trimmed
+			break
+		}
+	}
+	return map[string]string{column: column + " " + problem}
+}
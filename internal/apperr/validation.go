@@ -0,0 +1,58 @@
+package apperr
+
+import (
+	"strings"
+
+	validatorv9 "github.com/go-playground/validator"
+	"github.com/go-playground/validator/v10"
+)
+
+// FromValidation converts the error returned by validator's Struct or
+// StructPartial into a Validation error with one message per field.
+func FromValidation(err error) *Error {
+	// Inventories are validated with validator v9 and recipes with v10; both
+	// expose the same field error methods.
+	var fieldErrs []interface {
+		Field() string
+		Tag() string
+		Param() string
+	}
+	switch errs := err.(type) {
+	case validatorv9.ValidationErrors:
+		for _, e := range errs {
+			fieldErrs = append(fieldErrs, e)
+		}
+	case validator.ValidationErrors:
+		for _, e := range errs {
+			fieldErrs = append(fieldErrs, e)
+		}
+	}
+
+	fields := make(map[string]string)
+	for _, e := range fieldErrs {
+		field := strings.ToLower(e.Field())
+
+		switch e.Tag() {
+		case "required":
+			fields[field] = field + " is required"
+		case "email":
+			fields[field] = field + " must be a valid email address"
+		case "min":
+			fields[field] = field + " must be at least " + e.Param() + " characters"
+		case "max":
+			fields[field] = field + " must be at most " + e.Param() + " characters"
+		case "gte":
+			fields[field] = field + " must be greater than or equal to " + e.Param()
+		case "lte":
+			fields[field] = field + " must be less than or equal to " + e.Param()
+		case "gt":
+			fields[field] = field + " must be greater than " + e.Param()
+		case "oneof":
+			fields[field] = field + " must be one of: " + e.Param()
+		default:
+			fields[field] = field + " is invalid"
+		}
+	}
+
+	return &Error{Kind: KindValidation, Message: "Validation failed", Fields: fields, Err: err}
+}
@@ -0,0 +1,329 @@
+// Package config holds the settings of the server and its commands. They
+// are loaded, lowest precedence first, from the defaults, an optional YAML
+// or TOML file, .env, the environment and command line flags, and checked
+// once at startup by Validate.
+//
+// Every setting has a file key, a flag named after that key and an
+// environment variable; nested settings extend the variable of their parent:
+//
+//	database:                        # -database.inventory.max-open-conns=50
+//	  inventory:                     # PG_INVENTORY_MAX_OPEN_CONNS=50
+//	    max_open_conns: 50
+//
+// Settings tagged secret are redacted whenever the configuration is printed.
+package config
+
+import (
+	"avenger/internal/middleware"
+	"avenger/internal/service"
+	"avenger/pkg/db"
+	"avenger/pkg/tracing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Config is the complete configuration of the server.
+type Config struct {
+	Server    Server    `yaml:"server" toml:"server"`
+	Database  Database  `yaml:"database" toml:"database"`
+	Auth      Auth      `yaml:"auth" toml:"auth"`
+	Login     Login     `yaml:"login" toml:"login"`
+	Mail      Mail      `yaml:"mail" toml:"mail"`
+	RateLimit RateLimit `yaml:"rate_limit" toml:"rate_limit"`
+	CORS      CORS      `yaml:"cors" toml:"cors"`
+	Tracing   Tracing   `yaml:"tracing" toml:"tracing"`
+}
+
+// Server configures the HTTP server, its timeouts and graceful shutdown.
+type Server struct {
+	Addr string `yaml:"addr" toml:"addr" env:"LISTEN_ADDR"`
+	// MetricsAddr serves /metrics apart from the API so it can stay off the
+	// public network. When empty /metrics is served on Addr, rate limited
+	// like public reads.
+	MetricsAddr  string        `yaml:"metrics_addr" toml:"metrics_addr" env:"METRICS_ADDR"`
+	ReadTimeout  time.Duration `yaml:"read_timeout" toml:"read_timeout" env:"SERVER_READ_TIMEOUT"`
+	WriteTimeout time.Duration `yaml:"write_timeout" toml:"write_timeout" env:"SERVER_WRITE_TIMEOUT"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout" toml:"idle_timeout" env:"SERVER_IDLE_TIMEOUT"`
+
+	// RequestTimeout cancels handlers, database queries included; it must
+	// leave room to write the error response before WriteTimeout.
+	RequestTimeout time.Duration `yaml:"request_timeout" toml:"request_timeout" env:"REQUEST_TIMEOUT"`
+	// RouteTimeouts overrides RequestTimeout per "<METHOD> <route>", e.g.
+	// "GET /inventories/:id/reconciliation". In the environment and flags
+	// it is written as a comma separated list of "<METHOD> <route>=<duration>".
+	RouteTimeouts map[string]time.Duration `yaml:"route_timeouts" toml:"route_timeouts" env:"ROUTE_TIMEOUTS"`
+
+	ReadinessTimeout time.Duration `yaml:"readiness_timeout" toml:"readiness_timeout" env:"READINESS_TIMEOUT"`
+	// DrainDelay keeps serving after readiness fails on shutdown, covering
+	// a few readiness probe periods.
+	DrainDelay      time.Duration `yaml:"drain_delay" toml:"drain_delay" env:"SHUTDOWN_DRAIN_DELAY"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"`
+
+	// TrustedProxies lists the addresses or CIDRs whose X-Forwarded-For is
+	// believed.
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies" env:"TRUSTED_PROXIES"`
+	// RequireIfMatch makes PUT and DELETE fail without an If-Match header.
+	RequireIfMatch bool `yaml:"require_if_match" toml:"require_if_match" env:"REQUIRE_IF_MATCH"`
+}
+
+// Database configures the PostgreSQL server shared by both connection
+// pools and the limits of each pool.
+type Database struct {
+	Host     string `yaml:"host" toml:"host" env:"PG_HOST"`
+	Port     int    `yaml:"port" toml:"port" env:"PG_PORT"`
+	User     string `yaml:"user" toml:"user" env:"PG_USER"`
+	Password string `yaml:"password" toml:"password" env:"PG_PASSWORD" secret:"true"`
+	Name     string `yaml:"name" toml:"name" env:"PG_DBNAME"`
+	SSLMode  string `yaml:"sslmode" toml:"sslmode" env:"PG_SSLMODE"`
+	// LogLevel is the GORM statement log level: "silent", "error", "warn"
+	// or "info". Statements are logged without their values.
+	LogLevel string `yaml:"log_level" toml:"log_level" env:"PG_LOG_LEVEL"`
+
+	Inventory  Pool `yaml:"inventory" toml:"inventory" env:"PG_INVENTORY"`
+	UserRecipe Pool `yaml:"user_recipe" toml:"user_recipe" env:"PG_USER_RECIPE"`
+}
+
+// Pool limits one connection pool.
+type Pool struct {
+	MaxOpenConns    int           `yaml:"max_open_conns" toml:"max_open_conns" env:"MAX_OPEN_CONNS"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" toml:"max_idle_conns" env:"MAX_IDLE_CONNS"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime" env:"CONN_MAX_LIFETIME"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time" toml:"conn_max_idle_time" env:"CONN_MAX_IDLE_TIME"`
+}
+
+// Postgres returns the settings for a pool of d limited by p.
+func (d Database) Postgres(p Pool) db.Config {
+	return db.Config{
+		Host:            d.Host,
+		Port:            d.Port,
+		User:            d.User,
+		Password:        d.Password,
+		Name:            d.Name,
+		SSLMode:         d.SSLMode,
+		LogLevel:        d.LogLevel,
+		MaxOpenConns:    p.MaxOpenConns,
+		MaxIdleConns:    p.MaxIdleConns,
+		ConnMaxLifetime: p.ConnMaxLifetime,
+		ConnMaxIdleTime: p.ConnMaxIdleTime,
+	}
+}
+
+// Auth configures tokens, passwords and the links mailed to users.
+type Auth struct {
+	// Access tokens are signed with the RS256/EdDSA keys in JWTKeysDir or,
+	// without one, with HS256 and JWTSecret.
+	JWTSecret    string `yaml:"jwt_secret" toml:"jwt_secret" env:"JWT_SECRET" secret:"true"`
+	JWTKeysDir   string `yaml:"jwt_keys_dir" toml:"jwt_keys_dir" env:"JWT_KEYS_DIR"`
+	JWTActiveKID string `yaml:"jwt_active_kid" toml:"jwt_active_kid" env:"JWT_ACTIVE_KID"`
+
+	AccessTokenTTL  time.Duration `yaml:"access_token_ttl" toml:"access_token_ttl" env:"ACCESS_TOKEN_TTL"`
+	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl" toml:"refresh_token_ttl" env:"REFRESH_TOKEN_TTL"`
+	// UnverifiedLogin is "deny", "limited" or "allow".
+	UnverifiedLogin string `yaml:"unverified_login" toml:"unverified_login" env:"UNVERIFIED_LOGIN"`
+
+	BcryptCost int `yaml:"bcrypt_cost" toml:"bcrypt_cost" env:"BCRYPT_COST"`
+
+	PasswordResetURL     string        `yaml:"password_reset_url" toml:"password_reset_url" env:"PASSWORD_RESET_URL"`
+	PasswordResetTTL     time.Duration `yaml:"password_reset_ttl" toml:"password_reset_ttl" env:"PASSWORD_RESET_TTL"`
+	VerifyEmailURL       string        `yaml:"verify_email_url" toml:"verify_email_url" env:"VERIFY_EMAIL_URL"`
+	EmailVerificationTTL time.Duration `yaml:"email_verification_ttl" toml:"email_verification_ttl" env:"EMAIL_VERIFICATION_TTL"`
+	InviteURL            string        `yaml:"invite_url" toml:"invite_url" env:"INVITE_URL"`
+	TOTPIssuer           string        `yaml:"totp_issuer" toml:"totp_issuer" env:"TOTP_ISSUER"`
+}
+
+// Session returns the session service settings.
+func (a Auth) Session() service.SessionConfig {
+	return service.SessionConfig{
+		AccessTTL:       a.AccessTokenTTL,
+		RefreshTTL:      a.RefreshTokenTTL,
+		UnverifiedLogin: a.UnverifiedLogin,
+	}
+}
+
+// Login configures brute-force protection of the login endpoints.
+type Login struct {
+	// CounterStore is "postgres", shared by all instances, or "memory".
+	CounterStore  string        `yaml:"counter_store" toml:"counter_store" env:"LOGIN_COUNTER_STORE"`
+	FailureWindow time.Duration `yaml:"failure_window" toml:"failure_window" env:"LOGIN_FAILURE_WINDOW"`
+	Account       Lockout       `yaml:"account" toml:"account" env:"LOGIN_ACCOUNT"`
+	IP            Lockout       `yaml:"ip" toml:"ip" env:"LOGIN_IP"`
+}
+
+// Guard returns the login guard settings.
+func (l Login) Guard() service.LoginGuardConfig {
+	return service.LoginGuardConfig{
+		Account: l.Account.policy(),
+		IP:      l.IP.policy(),
+		Window:  l.FailureWindow,
+	}
+}
+
+// Lockout mirrors service.LockoutPolicy.
+type Lockout struct {
+	MaxFailures  int           `yaml:"max_failures" toml:"max_failures" env:"MAX_FAILURES"`
+	FreeFailures int           `yaml:"free_failures" toml:"free_failures" env:"FREE_FAILURES"`
+	Backoff      time.Duration `yaml:"backoff" toml:"backoff" env:"BACKOFF"`
+	Lockout      time.Duration `yaml:"lockout" toml:"lockout" env:"LOCKOUT"`
+}
+
+func (l Lockout) policy() service.LockoutPolicy {
+	return service.LockoutPolicy{
+		MaxFailures:  l.MaxFailures,
+		FreeFailures: l.FreeFailures,
+		BaseDelay:    l.Backoff,
+		Lockout:      l.Lockout,
+	}
+}
+
+// Mail configures how emails are sent.
+type Mail struct {
+	// Driver is "smtp", "file" (writes .eml files to Dir) or "log".
+	Driver string `yaml:"driver" toml:"driver" env:"MAIL_DRIVER"`
+	From   string `yaml:"from" toml:"from" env:"MAIL_FROM"`
+	Dir    string `yaml:"dir" toml:"dir" env:"MAIL_DIR"`
+	SMTP   SMTP   `yaml:"smtp" toml:"smtp" env:"SMTP"`
+}
+
+// SMTP configures the SMTP mail driver.
+type SMTP struct {
+	Host     string `yaml:"host" toml:"host" env:"HOST"`
+	Port     int    `yaml:"port" toml:"port" env:"PORT"`
+	Username string `yaml:"username" toml:"username" env:"USERNAME"`
+	Password string `yaml:"password" toml:"password" env:"PASSWORD" secret:"true"`
+}
+
+// RateLimit configures the request rate limits. Authenticated routes are
+// limited per user or API key, public ones per client IP. Auth limits every
+// request to an authenticated route per client IP before its credentials
+// are checked.
+type RateLimit struct {
+	// Store is "memory" or "postgres", which is shared by all instances at
+	// the cost of a write per request.
+	Store      string `yaml:"store" toml:"store" env:"RATE_LIMIT_STORE"`
+	Login      Rate   `yaml:"login" toml:"login" env:"RATE_LIMIT_LOGIN"`
+	Register   Rate   `yaml:"register" toml:"register" env:"RATE_LIMIT_REGISTER"`
+	Email      Rate   `yaml:"email" toml:"email" env:"RATE_LIMIT_EMAIL"`
+	Refresh    Rate   `yaml:"refresh" toml:"refresh" env:"RATE_LIMIT_REFRESH"`
+	PublicRead Rate   `yaml:"public_read" toml:"public_read" env:"RATE_LIMIT_PUBLIC_READ"`
+	API        Rate   `yaml:"api" toml:"api" env:"RATE_LIMIT_API"`
+	Auth       Rate   `yaml:"auth" toml:"auth" env:"RATE_LIMIT_AUTH"`
+}
+
+// Rate allows Limit requests per Period.
+type Rate struct {
+	Limit  int           `yaml:"limit" toml:"limit" env:"LIMIT"`
+	Period time.Duration `yaml:"period" toml:"period" env:"PERIOD"`
+}
+
+// Policy returns r as the rate limit policy called name.
+func (r Rate) Policy(name string) middleware.RateLimitPolicy {
+	return middleware.RateLimitPolicy{Name: name, Limit: r.Limit, Period: r.Period}
+}
+
+// CORS configures cross-origin requests from browsers. It is disabled
+// without allowed origins.
+type CORS struct {
+	AllowedOrigins   []string      `yaml:"allowed_origins" toml:"allowed_origins" env:"CORS_ALLOWED_ORIGINS"`
+	AllowedMethods   []string      `yaml:"allowed_methods" toml:"allowed_methods" env:"CORS_ALLOWED_METHODS"`
+	AllowedHeaders   []string      `yaml:"allowed_headers" toml:"allowed_headers" env:"CORS_ALLOWED_HEADERS"`
+	ExposedHeaders   []string      `yaml:"exposed_headers" toml:"exposed_headers" env:"CORS_EXPOSED_HEADERS"`
+	AllowCredentials bool          `yaml:"allow_credentials" toml:"allow_credentials" env:"CORS_ALLOW_CREDENTIALS"`
+	MaxAge           time.Duration `yaml:"max_age" toml:"max_age" env:"CORS_MAX_AGE"`
+}
+
+// Middleware returns the CORS middleware settings.
+func (c CORS) Middleware() middleware.CORSConfig {
+	return middleware.CORSConfig{
+		AllowedOrigins:   c.AllowedOrigins,
+		AllowedMethods:   c.AllowedMethods,
+		AllowedHeaders:   c.AllowedHeaders,
+		ExposedHeaders:   c.ExposedHeaders,
+		AllowCredentials: c.AllowCredentials,
+		MaxAge:           c.MaxAge,
+	}
+}
+
+// Tracing configures where spans are exported to.
+type Tracing struct {
+	// Exporter is "none", "stdout" or "otlp".
+	Exporter    string `yaml:"exporter" toml:"exporter" env:"OTEL_TRACES_EXPORTER"`
+	ServiceName string `yaml:"service_name" toml:"service_name" env:"OTEL_SERVICE_NAME"`
+}
+
+// Default returns the configuration used for everything that is not set.
+func Default() *Config {
+	pool := Pool{
+		MaxOpenConns:    25,
+		MaxIdleConns:    5,
+		ConnMaxLifetime: 5 * time.Minute,
+		ConnMaxIdleTime: 10 * time.Minute,
+	}
+	account := service.DefaultAccountLockoutPolicy
+	ip := service.DefaultIPLockoutPolicy
+
+	return &Config{
+		Server: Server{
+			Addr:             ":8080",
+			MetricsAddr:      ":9090",
+			ReadTimeout:      15 * time.Second,
+			WriteTimeout:     15 * time.Second,
+			IdleTimeout:      60 * time.Second,
+			RequestTimeout:   10 * time.Second,
+			ReadinessTimeout: 2 * time.Second,
+			DrainDelay:       5 * time.Second,
+			ShutdownTimeout:  30 * time.Second,
+			RequireIfMatch:   true,
+		},
+		Database: Database{
+			Port:       5432,
+			SSLMode:    "disable",
+			LogLevel:   "warn",
+			Inventory:  pool,
+			UserRecipe: pool,
+		},
+		Auth: Auth{
+			AccessTokenTTL:       service.DefaultAccessTokenTTL,
+			RefreshTokenTTL:      service.DefaultRefreshTokenTTL,
+			UnverifiedLogin:      service.UnverifiedLoginDeny,
+			BcryptCost:           bcrypt.DefaultCost,
+			PasswordResetURL:     "http://localhost:3000/reset-password",
+			PasswordResetTTL:     service.DefaultPasswordResetTTL,
+			VerifyEmailURL:       "http://localhost:8080/verify-email",
+			EmailVerificationTTL: service.DefaultEmailVerificationTTL,
+		},
+		Login: Login{
+			CounterStore:  "postgres",
+			FailureWindow: service.DefaultLoginFailureWindow,
+			Account:       Lockout{account.MaxFailures, account.FreeFailures, account.BaseDelay, account.Lockout},
+			IP:            Lockout{ip.MaxFailures, ip.FreeFailures, ip.BaseDelay, ip.Lockout},
+		},
+		Mail: Mail{
+			Driver: "log",
+			From:   "no-reply@localhost",
+			Dir:    "tmp/mail",
+			SMTP:   SMTP{Port: 587},
+		},
+		RateLimit: RateLimit{
+			Store:      "memory",
+			Login:      Rate{10, time.Minute},
+			Register:   Rate{5, time.Minute},
+			Email:      Rate{5, time.Minute},
+			Refresh:    Rate{30, time.Minute},
+			PublicRead: Rate{120, time.Minute},
+			API:        Rate{300, time.Minute},
+			Auth:       Rate{600, time.Minute},
+		},
+		CORS: CORS{
+			AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
+			AllowedHeaders: []string{"Authorization", "Content-Type", "If-Match", "If-None-Match", "X-API-Key", "X-Request-ID"},
+			ExposedHeaders: []string{"ETag", "Retry-After", "X-Request-ID",
+				"RateLimit-Limit", "RateLimit-Policy", "RateLimit-Remaining", "RateLimit-Reset"},
+			MaxAge: 10 * time.Minute,
+		},
+		Tracing: Tracing{
+			Exporter:    tracing.ExporterNone,
+			ServiceName: "avenger",
+		},
+	}
+}
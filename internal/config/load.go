@@ -0,0 +1,287 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// FileEnv names the config file when the -config flag is not given.
+const FileEnv = "CONFIG_FILE"
+
+// Loader loads the configuration, taking the config file and overrides from
+// the flags it registers.
+type Loader struct {
+	file  string
+	flags *flag.FlagSet
+}
+
+// NewLoader registers -config and a flag per setting on flags. Load must
+// be called after flags are parsed.
+func NewLoader(flags *flag.FlagSet) *Loader {
+	l := &Loader{flags: flags}
+	flags.StringVar(&l.file, "config", "", "YAML or TOML config `file` (env "+FileEnv+")")
+	walk(Default(), func(f field) {
+		flags.Var(&flagValue{typ: f.value.Type(), def: format(f.value)}, f.flag(), usage(f))
+	})
+	return l
+}
+
+// Load returns the defaults overridden, in order, by the config file, .env,
+// the environment and the flags set on the command line. Empty environment
+// variables count as unset. The result is not validated.
+func (l *Loader) Load() (*Config, error) {
+	if err := godotenv.Load(); errors.Is(err, fs.ErrNotExist) {
+		slog.Warn("No .env file found")
+	} else if err != nil {
+		return nil, fmt.Errorf("load .env: %w", err)
+	}
+
+	cfg := Default()
+
+	file := l.file
+	if file == "" {
+		file = os.Getenv(FileEnv)
+	}
+	if file != "" {
+		if err := decodeFile(file, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []error
+	walk(cfg, func(f field) {
+		if v := os.Getenv(f.env); v != "" {
+			if err := parse(f.value, v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", f.env, err))
+			}
+		}
+	})
+	walk(cfg, func(f field) {
+		if v, ok := l.flags.Lookup(f.flag()).Value.(*flagValue); ok && v.set {
+			if err := parse(f.value, v.value); err != nil {
+				errs = append(errs, fmt.Errorf("-%s: %w", f.flag(), err))
+			}
+		}
+	})
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// decodeFile reads the YAML or TOML file path into cfg, by extension.
+// Unknown keys are errors, so typos do not silently fall back to defaults.
+func decodeFile(path string, cfg *Config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open config file: %w", err)
+	}
+	defer f.Close()
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(f)
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("decode %s: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.NewDecoder(f).Decode(cfg)
+		if err != nil {
+			return fmt.Errorf("decode %s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("decode %s: unknown key %s", path, undecoded[0])
+		}
+	default:
+		return fmt.Errorf("config file %s: unsupported extension %q, use .yaml, .yml or .toml", path, ext)
+	}
+	return nil
+}
+
+// field is a single setting found by walk.
+type field struct {
+	keys   []string // file keys from the root
+	env    string
+	secret bool
+	value  reflect.Value
+}
+
+// flag returns the flag name of f, its file keys joined by dots with
+// dashes for underscores.
+func (f field) flag() string {
+	return strings.ReplaceAll(strings.Join(f.keys, "."), "_", "-")
+}
+
+// walk calls fn for every setting of cfg in declaration order. Environment
+// variables of nested settings are prefixed with those of their parents.
+func walk(cfg *Config, fn func(field)) {
+	walkStruct(reflect.ValueOf(cfg).Elem(), nil, "", fn)
+}
+
+func walkStruct(v reflect.Value, keys []string, env string, fn func(field)) {
+	t := v.Type()
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		f := field{
+			keys:   append(slices.Clip(keys), sf.Tag.Get("yaml")),
+			env:    sf.Tag.Get("env"),
+			secret: sf.Tag.Get("secret") == "true",
+			value:  v.Field(i),
+		}
+		if env != "" && f.env != "" {
+			f.env = env + "_" + f.env
+		}
+		if sf.Type.Kind() == reflect.Struct {
+			walkStruct(f.value, f.keys, f.env, fn)
+			continue
+		}
+		fn(f)
+	}
+}
+
+var durationType = reflect.TypeFor[time.Duration]()
+
+// parse sets v from its environment variable or flag form s.
+func parse(v reflect.Value, s string) error {
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	case v.Kind() == reflect.Map:
+		timeouts, err := parseRouteTimeouts(s)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(timeouts))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
+
+// parseRouteTimeouts parses a comma separated list of
+// "<METHOD> <route>=<duration>" entries, e.g.
+// "GET /inventories/:id/reconciliation=14s".
+func parseRouteTimeouts(s string) (map[string]time.Duration, error) {
+	timeouts := map[string]time.Duration{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		route, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("entry %q is not <METHOD> <route>=<duration>", entry)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("entry %q: %w", entry, err)
+		}
+		timeouts[strings.Join(strings.Fields(route), " ")] = d
+	}
+	return timeouts, nil
+}
+
+// format renders v in the form parse reads.
+func format(v reflect.Value) string {
+	switch {
+	case v.Type() == durationType:
+		return time.Duration(v.Int()).String()
+	case v.Kind() == reflect.Slice:
+		return strings.Join(v.Interface().([]string), ",")
+	case v.Kind() == reflect.Map:
+		timeouts := v.Interface().(map[string]time.Duration)
+		entries := make([]string, 0, len(timeouts))
+		for route, d := range timeouts {
+			entries = append(entries, route+"="+d.String())
+		}
+		sort.Strings(entries)
+		return strings.Join(entries, ",")
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
+
+// usage names the environment variable of f, which the flag package also
+// shows as the placeholder for its value.
+func usage(f field) string {
+	if f.value.Kind() == reflect.Bool {
+		return "env " + f.env
+	}
+	return "env `" + f.env + "`"
+}
+
+// flagValue records the value of a setting's flag until Load applies it
+// over the file and environment.
+type flagValue struct {
+	typ   reflect.Type
+	def   string
+	value string
+	set   bool
+}
+
+// IsBoolFlag lets boolean settings be set with a bare -flag.
+func (f *flagValue) IsBoolFlag() bool {
+	return f.typ.Kind() == reflect.Bool
+}
+
+func (f *flagValue) String() string {
+	if f == nil {
+		return ""
+	}
+	if f.set {
+		return f.value
+	}
+	return f.def
+}
+
+// Set checks s parses as the setting so bad flags fail with the usage.
+func (f *flagValue) Set(s string) error {
+	if err := parse(reflect.New(f.typ).Elem(), s); err != nil {
+		return err
+	}
+	f.value, f.set = s, true
+	return nil
+}
@@ -0,0 +1,89 @@
+package config
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redacted replaces the value of secret settings that are set.
+const redacted = "[REDACTED]"
+
+// WriteYAML writes c to w as a config file, with secrets redacted.
+func (c *Config) WriteYAML(w io.Writer) error {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	walk(c, func(f field) {
+		parent := root
+		for _, key := range f.keys[:len(f.keys)-1] {
+			parent = child(parent, key)
+		}
+		parent.Content = append(parent.Content, scalar("!!str", f.keys[len(f.keys)-1]), node(f))
+	})
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(root); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// String renders c as YAML with secrets redacted, so printing or logging a
+// Config never leaks them.
+func (c *Config) String() string {
+	var buf bytes.Buffer
+	if err := c.WriteYAML(&buf); err != nil {
+		return err.Error()
+	}
+	return buf.String()
+}
+
+// child returns the mapping under key in parent, adding it when missing.
+func child(parent *yaml.Node, key string) *yaml.Node {
+	for i := 0; i < len(parent.Content); i += 2 {
+		if parent.Content[i].Value == key {
+			return parent.Content[i+1]
+		}
+	}
+	m := &yaml.Node{Kind: yaml.MappingNode}
+	parent.Content = append(parent.Content, scalar("!!str", key), m)
+	return m
+}
+
+func scalar(tag, value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value}
+}
+
+// node renders the value of f in the form the config file takes.
+func node(f field) *yaml.Node {
+	v := f.value
+	switch {
+	case f.secret && v.String() != "":
+		return scalar("!!str", redacted)
+	case v.Type() == durationType, v.Kind() == reflect.String:
+		return scalar("!!str", format(v))
+	case v.Kind() == reflect.Int:
+		return scalar("!!int", strconv.FormatInt(v.Int(), 10))
+	case v.Kind() == reflect.Bool:
+		return scalar("!!bool", strconv.FormatBool(v.Bool()))
+	case v.Kind() == reflect.Slice:
+		seq := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+		for i := range v.Len() {
+			seq.Content = append(seq.Content, scalar("!!str", v.Index(i).String()))
+		}
+		return seq
+	default:
+		m := &yaml.Node{Kind: yaml.MappingNode}
+		keys := v.MapKeys()
+		slices.SortFunc(keys, func(a, b reflect.Value) int { return strings.Compare(a.String(), b.String()) })
+		for _, k := range keys {
+			m.Content = append(m.Content, scalar("!!str", k.String()), scalar("!!str", format(v.MapIndex(k))))
+		}
+		return m
+	}
+}
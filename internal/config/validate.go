@@ -0,0 +1,216 @@
+package config
+
+import (
+	"avenger/internal/service"
+	"avenger/pkg/tracing"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// problems collects what is wrong with a configuration, keyed by the file
+// key of the setting.
+type problems []error
+
+func (p *problems) add(key, format string, args ...any) {
+	*p = append(*p, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+}
+
+func (p *problems) required(key, value string) {
+	if strings.TrimSpace(value) == "" {
+		p.add(key, "is required")
+	}
+}
+
+func (p *problems) positive(key string, d time.Duration) {
+	if d <= 0 {
+		p.add(key, "must be a positive duration, got %s", d)
+	}
+}
+
+func (p *problems) oneOf(key, value string, allowed ...string) {
+	if !slices.Contains(allowed, value) {
+		p.add(key, "must be one of %s, got %q", strings.Join(allowed, ", "), value)
+	}
+}
+
+func (p *problems) port(key string, port int) {
+	if port < 1 || port > 65535 {
+		p.add(key, "must be a port between 1 and 65535, got %d", port)
+	}
+}
+
+func (p *problems) url(key, value string) {
+	if value == "" {
+		return
+	}
+	if u, err := url.Parse(value); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		p.add(key, "must be an absolute http(s) URL, got %q", value)
+	}
+}
+
+// Validate reports every invalid setting of c at once.
+func (c *Config) Validate() error {
+	var p problems
+	c.Server.validate(&p)
+	c.Database.validate(&p)
+	c.Auth.validate(&p)
+	c.Login.validate(&p)
+	c.Mail.validate(&p)
+	c.RateLimit.validate(&p)
+	c.CORS.validate(&p)
+	p.oneOf("tracing.exporter", c.Tracing.Exporter, tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP)
+	p.required("tracing.service_name", c.Tracing.ServiceName)
+	return errors.Join(p...)
+}
+
+func (s Server) validate(p *problems) {
+	p.required("server.addr", s.Addr)
+	if s.MetricsAddr != "" && s.MetricsAddr == s.Addr {
+		p.add("server.metrics_addr", "must differ from server.addr, or be empty to serve /metrics there")
+	}
+	p.positive("server.read_timeout", s.ReadTimeout)
+	p.positive("server.write_timeout", s.WriteTimeout)
+	p.positive("server.idle_timeout", s.IdleTimeout)
+	p.positive("server.readiness_timeout", s.ReadinessTimeout)
+	p.positive("server.shutdown_timeout", s.ShutdownTimeout)
+	if s.DrainDelay < 0 {
+		p.add("server.drain_delay", "must not be negative, got %s", s.DrainDelay)
+	}
+
+	// A handler still running at WriteTimeout cannot report its timeout.
+	p.positive("server.request_timeout", s.RequestTimeout)
+	if s.RequestTimeout >= s.WriteTimeout {
+		p.add("server.request_timeout", "must be shorter than server.write_timeout (%s), got %s", s.WriteTimeout, s.RequestTimeout)
+	}
+	for route, d := range s.RouteTimeouts {
+		key := fmt.Sprintf("server.route_timeouts[%q]", route)
+		method, path, ok := strings.Cut(route, " ")
+		if !ok || method != strings.ToUpper(method) || !strings.HasPrefix(path, "/") {
+			p.add(key, `must be keyed by "<METHOD> <route>"`)
+		}
+		p.positive(key, d)
+		if d >= s.WriteTimeout {
+			p.add(key, "must be shorter than server.write_timeout (%s), got %s", s.WriteTimeout, d)
+		}
+	}
+}
+
+func (d Database) validate(p *problems) {
+	p.required("database.host", d.Host)
+	p.port("database.port", d.Port)
+	p.required("database.user", d.User)
+	p.required("database.name", d.Name)
+	p.oneOf("database.sslmode", d.SSLMode, "disable", "allow", "prefer", "require", "verify-ca", "verify-full")
+	p.oneOf("database.log_level", d.LogLevel, "silent", "error", "warn", "info")
+	d.Inventory.validate(p, "database.inventory")
+	d.UserRecipe.validate(p, "database.user_recipe")
+}
+
+func (pool Pool) validate(p *problems, key string) {
+	if pool.MaxOpenConns < 1 {
+		p.add(key+".max_open_conns", "must be at least 1, got %d", pool.MaxOpenConns)
+	}
+	if pool.MaxIdleConns < 0 || pool.MaxIdleConns > pool.MaxOpenConns {
+		p.add(key+".max_idle_conns", "must be between 0 and max_open_conns (%d), got %d", pool.MaxOpenConns, pool.MaxIdleConns)
+	}
+	if pool.ConnMaxLifetime < 0 {
+		p.add(key+".conn_max_lifetime", "must not be negative, got %s", pool.ConnMaxLifetime)
+	}
+	if pool.ConnMaxIdleTime < 0 {
+		p.add(key+".conn_max_idle_time", "must not be negative, got %s", pool.ConnMaxIdleTime)
+	}
+}
+
+func (a Auth) validate(p *problems) {
+	if a.JWTKeysDir == "" && a.JWTSecret == "" {
+		p.add("auth.jwt_secret", "is required without auth.jwt_keys_dir (RS256/EdDSA keys)")
+	}
+	p.positive("auth.access_token_ttl", a.AccessTokenTTL)
+	p.positive("auth.refresh_token_ttl", a.RefreshTokenTTL)
+	p.oneOf("auth.unverified_login", a.UnverifiedLogin, service.UnverifiedLoginDeny, service.UnverifiedLoginLimited, service.UnverifiedLoginAllow)
+	if a.BcryptCost < bcrypt.MinCost || a.BcryptCost > bcrypt.MaxCost {
+		p.add("auth.bcrypt_cost", "must be between %d and %d, got %d", bcrypt.MinCost, bcrypt.MaxCost, a.BcryptCost)
+	}
+	p.url("auth.password_reset_url", a.PasswordResetURL)
+	p.positive("auth.password_reset_ttl", a.PasswordResetTTL)
+	p.url("auth.verify_email_url", a.VerifyEmailURL)
+	p.positive("auth.email_verification_ttl", a.EmailVerificationTTL)
+	p.url("auth.invite_url", a.InviteURL)
+}
+
+func (l Login) validate(p *problems) {
+	p.oneOf("login.counter_store", l.CounterStore, "postgres", "memory")
+	p.positive("login.failure_window", l.FailureWindow)
+	l.Account.validate(p, "login.account")
+	l.IP.validate(p, "login.ip")
+}
+
+func (l Lockout) validate(p *problems, key string) {
+	if l.MaxFailures < 1 {
+		p.add(key+".max_failures", "must be at least 1, got %d", l.MaxFailures)
+	}
+	if l.FreeFailures < 0 || l.FreeFailures >= l.MaxFailures {
+		p.add(key+".free_failures", "must be between 0 and max_failures (%d), got %d", l.MaxFailures, l.FreeFailures)
+	}
+	p.positive(key+".backoff", l.Backoff)
+	p.positive(key+".lockout", l.Lockout)
+}
+
+func (m Mail) validate(p *problems) {
+	p.oneOf("mail.driver", m.Driver, "smtp", "file", "log")
+	p.required("mail.from", m.From)
+	switch m.Driver {
+	case "smtp":
+		p.required("mail.smtp.host", m.SMTP.Host)
+		p.port("mail.smtp.port", m.SMTP.Port)
+	case "file":
+		p.required("mail.dir", m.Dir)
+	}
+}
+
+func (r RateLimit) validate(p *problems) {
+	p.oneOf("rate_limit.store", r.Store, "memory", "postgres")
+	r.Login.validate(p, "rate_limit.login")
+	r.Register.validate(p, "rate_limit.register")
+	r.Email.validate(p, "rate_limit.email")
+	r.Refresh.validate(p, "rate_limit.refresh")
+	r.PublicRead.validate(p, "rate_limit.public_read")
+	r.API.validate(p, "rate_limit.api")
+	r.Auth.validate(p, "rate_limit.auth")
+}
+
+func (r Rate) validate(p *problems, key string) {
+	if r.Limit < 1 {
+		p.add(key+".limit", "must be at least 1, got %d", r.Limit)
+	}
+	p.positive(key+".period", r.Period)
+}
+
+func (c CORS) validate(p *problems) {
+	if len(c.AllowedOrigins) == 0 {
+		return
+	}
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			if c.AllowCredentials {
+				p.add("cors.allowed_origins", `must list origins rather than "*" with cors.allow_credentials`)
+			}
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+			p.add("cors.allowed_origins", `must contain "*" or origins like "https://app.example.com", got %q`, origin)
+		}
+	}
+	if len(c.AllowedMethods) == 0 {
+		p.add("cors.allowed_methods", "is required with cors.allowed_origins")
+	}
+	if c.MaxAge < 0 {
+		p.add("cors.max_age", "must not be negative, got %s", c.MaxAge)
+	}
+}
@@ -2,9 +2,45 @@ package domain
 
 type Inventory struct {
 	ID          int    `json:"id"`
-	Name        string `json:"name" validate:"required, min=3, max=100"`
-	Code        string `json:"code" validate:"required, min=3, max=50"`
-	Stock       int    `json:"stock" validate:"required,gte=0"`
+	Name        string `json:"name" validate:"required,min=3,max=100"`
+	Code        string `json:"code" validate:"required,min=3,max=50"`
+	Stock       int    `json:"stock" validate:"gte=0"`
 	Description string `json:"description" validate:"max=500"`
 	Status      string `json:"status" validate:"required,oneof=active broken"`
+	Version     int    `json:"version"`
+}
+
+// InventoryStatuses lists the values Inventory.Status may take.
+var InventoryStatuses = []string{"active", "broken"}
+
+// InventoryStats summarizes all inventories for monitoring.
+type InventoryStats struct {
+	ByStatus   map[string]int
+	OutOfStock int
+}
+
+// InventoryQuery describes the filters, ordering and page requested when
+// listing inventories. Zero values mean "no filter".
+type InventoryQuery struct {
+	Page       int
+	PerPage    int
+	Status     string
+	CodePrefix string
+	Name       string
+	StockGTE   *int
+	StockLTE   *int
+	Sort       []SortField
+}
+
+// InventorySortFields lists the fields inventories can be ordered by.
+var InventorySortFields = []string{"id", "name", "code", "stock", "status"}
+
+// InventoryPatchFields maps the JSON members a PATCH may change to the struct
+// fields they populate.
+var InventoryPatchFields = map[string]string{
+	"name":        "Name",
+	"code":        "Code",
+	"stock":       "Stock",
+	"description": "Description",
+	"status":      "Status",
 }
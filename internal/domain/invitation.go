@@ -0,0 +1,54 @@
+package domain
+
+import "time"
+
+// Invitation lets a superadmin decide the role of an account before it is
+// registered. The invite code handed out is a signed token whose jti is the
+// invitation ID; the record makes it single use and revocable.
+type Invitation struct {
+	ID        string     `gorm:"type:varchar(64);primaryKey" json:"id"`
+	Role      string     `gorm:"type:varchar(50);not null" json:"role"`
+	Email     string     `gorm:"type:varchar(100)" json:"email,omitempty"`
+	CreatedBy uint       `gorm:"not null" json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	UsedBy    *uint      `json:"used_by,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Pending reports whether the invitation can still be redeemed.
+func (i *Invitation) Pending(now time.Time) bool {
+	return i.UsedAt == nil && i.RevokedAt == nil && now.Before(i.ExpiresAt)
+}
+
+// InvitationRequest is the body of POST /invitations. Email, when set, binds
+// the invitation to that address. ExpiresInHours defaults to a week.
+type InvitationRequest struct {
+	Role           string `json:"role" validate:"required,max=50"`
+	Email          string `json:"email" validate:"omitempty,email"`
+	ExpiresInHours int    `json:"expires_in_hours" validate:"omitempty,min=1,max=720"`
+}
+
+// RegisterRequest is the body of POST /register. It deliberately has no role:
+// public registrations get DefaultRole, invited ones the invitation's role.
+type RegisterRequest struct {
+	Email      string `json:"email"`
+	Password   string `json:"password"`
+	FullName   string `json:"full_name"`
+	Age        int    `json:"age"`
+	Occupation string `json:"occupation"`
+	InviteCode string `json:"invite_code"`
+}
+
+// User returns the account described by the request.
+func (r RegisterRequest) User() User {
+	return User{
+		Email:      r.Email,
+		Password:   r.Password,
+		FullName:   r.FullName,
+		Age:        r.Age,
+		Occupation: r.Occupation,
+		Role:       DefaultRole,
+	}
+}
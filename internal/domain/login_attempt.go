@@ -0,0 +1,41 @@
+package domain
+
+import "time"
+
+// Reasons recorded for failed login attempts.
+const (
+	LoginFailedUnknownEmail = "unknown_email"
+	LoginFailedBadPassword  = "bad_password"
+	LoginFailedBadTwoFactor = "bad_two_factor_code"
+	LoginFailedDisabled     = "account_disabled"
+	LoginFailedLocked       = "locked"
+)
+
+// LoginAttempt is the audit record of a failed login.
+type LoginAttempt struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Email     string    `gorm:"type:varchar(100);not null;index" json:"email"`
+	UserID    *uint     `gorm:"index" json:"user_id,omitempty"`
+	IP        string    `gorm:"type:varchar(45)" json:"ip"`
+	UserAgent string    `gorm:"type:varchar(255)" json:"user_agent"`
+	Reason    string    `gorm:"type:varchar(30);not null" json:"reason"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+// LoginCounter counts recent login failures for one account or client IP.
+// Key is "account:<email>" or "ip:<address>".
+type LoginCounter struct {
+	Key           string     `gorm:"type:varchar(150);primaryKey" json:"key"`
+	Failures      int        `gorm:"not null" json:"failures"`
+	LastFailureAt time.Time  `gorm:"not null" json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty"`
+}
+
+// Locked reports whether logins for the counter's key are refused at now,
+// and for how much longer.
+func (c *LoginCounter) Locked(now time.Time) (time.Duration, bool) {
+	if c == nil || c.LockedUntil == nil || !now.Before(*c.LockedUntil) {
+		return 0, false
+	}
+	return c.LockedUntil.Sub(now), true
+}
@@ -0,0 +1,15 @@
+package domain
+
+// SortField is a single ordering term, e.g. "-stock" is {Field: "stock", Desc: true}.
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// String renders the sort term the same way it is accepted in query strings.
+func (s SortField) String() string {
+	if s.Desc {
+		return "-" + s.Field
+	}
+	return s.Field
+}
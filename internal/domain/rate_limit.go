@@ -0,0 +1,9 @@
+package domain
+
+// RateLimitBucket is the GCRA state of one rate limited client and policy,
+// used by the Postgres rate limit store. TAT is the theoretical arrival time
+// of the next request in Unix nanoseconds.
+type RateLimitBucket struct {
+	Key string `gorm:"type:varchar(200);primaryKey"`
+	TAT int64  `gorm:"column:tat;not null;index"`
+}
@@ -1,6 +1,10 @@
 package domain
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type Recipe struct {
 	gorm.Model
@@ -8,4 +12,49 @@ type Recipe struct {
 	Description string  `gorm:"not null" json:"description" validate:"required,min=10,max=1000"`
 	CookTime    int     `gorm:"not null" json:"cook_time" validate:"required,gt=0"`
 	Rating      float64 `gorm:"not null" json:"rating" validate:"required,gte=0,lte=5"`
+	Version     int     `gorm:"not null;default:1" json:"version"`
+}
+
+// RecipePatchFields maps the JSON members a PATCH may change to the struct
+// fields they populate.
+var RecipePatchFields = map[string]string{
+	"name":        "Name",
+	"description": "Description",
+	"cook_time":   "CookTime",
+	"rating":      "Rating",
+}
+
+// RecipeQuery describes a keyset-paginated recipe listing. Cursor is the
+// position of the last recipe of the previous page, nil for the first page.
+type RecipeQuery struct {
+	Limit       int
+	Cursor      *RecipeCursor
+	MinRating   *float64
+	MaxCookTime *int
+	Search      string
+	Sort        SortField
+}
+
+// RecipeCursor records the sort key of the last recipe seen so the next page
+// can continue right after it.
+type RecipeCursor struct {
+	ID        uint      `json:"id"`
+	Rating    float64   `json:"rating"`
+	CookTime  int       `json:"cook_time"`
+	CreatedAt time.Time `json:"created_at"`
+	Sort      string    `json:"sort"`
+}
+
+// RecipeSortFields lists the fields recipes can be ordered by.
+var RecipeSortFields = []string{"id", "rating", "cook_time", "created_at"}
+
+// NewRecipeCursor returns the cursor pointing just after rec for the given sort.
+func NewRecipeCursor(rec Recipe, sort SortField) *RecipeCursor {
+	return &RecipeCursor{
+		ID:        rec.ID,
+		Rating:    rec.Rating,
+		CookTime:  rec.CookTime,
+		CreatedAt: rec.CreatedAt,
+		Sort:      sort.String(),
+	}
 }
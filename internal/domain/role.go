@@ -0,0 +1,57 @@
+package domain
+
+import "time"
+
+// Permissions checked by the HTTP layer. Roles are named sets of these.
+const (
+	PermInventoryRead   = "inventory:read"
+	PermInventoryWrite  = "inventory:write"
+	PermInventoryDelete = "inventory:delete"
+	PermInventoryAdjust = "inventory:adjust"
+	PermRecipeWrite     = "recipe:write"
+	PermRecipeDelete    = "recipe:delete"
+	PermUserManage      = "user:manage"
+)
+
+const (
+	RoleAdmin      = "admin"
+	RoleSuperadmin = "superadmin"
+
+	// DefaultRole is given to accounts registered without an invitation.
+	DefaultRole = RoleAdmin
+)
+
+// DefaultRolePermissions seeds the roles table on first start. Once a role
+// exists its permissions are managed in the database and never reseeded.
+var DefaultRolePermissions = map[string][]string{
+	RoleAdmin: {
+		PermInventoryRead,
+		PermInventoryWrite,
+		PermInventoryDelete,
+		PermInventoryAdjust,
+	},
+	RoleSuperadmin: {
+		PermInventoryRead,
+		PermInventoryWrite,
+		PermInventoryDelete,
+		PermInventoryAdjust,
+		PermRecipeWrite,
+		PermRecipeDelete,
+		PermUserManage,
+	},
+}
+
+// Role groups permissions. Members of a role with RequireTwoFactor get no
+// permissions until they log in with a second factor.
+type Role struct {
+	Name             string           `gorm:"type:varchar(50);primaryKey" json:"name"`
+	Description      string           `gorm:"type:varchar(255)" json:"description"`
+	RequireTwoFactor bool             `gorm:"not null;default:false" json:"require_two_factor"`
+	Permissions      []RolePermission `gorm:"foreignKey:RoleName;references:Name;constraint:OnDelete:CASCADE" json:"-"`
+	CreatedAt        time.Time        `json:"created_at"`
+}
+
+type RolePermission struct {
+	RoleName   string `gorm:"type:varchar(50);primaryKey" json:"role"`
+	Permission string `gorm:"type:varchar(100);primaryKey" json:"permission"`
+}
@@ -0,0 +1,60 @@
+package domain
+
+import "time"
+
+// Session is one login of a user. Access tokens carry its ID in the "sid"
+// claim and stop working as soon as the session is revoked or expires.
+// TwoFactor records that the login was completed with a second factor.
+type Session struct {
+	ID            string     `gorm:"type:varchar(64);primaryKey" json:"id"`
+	UserID        uint       `gorm:"not null;index" json:"user_id"`
+	TwoFactor     bool       `gorm:"not null;default:false" json:"two_factor"`
+	UserAgent     string     `gorm:"type:varchar(255)" json:"user_agent"`
+	IP            string     `gorm:"type:varchar(45)" json:"ip"`
+	CreatedAt     time.Time  `json:"created_at"`
+	LastUsedAt    time.Time  `json:"last_used_at"`
+	ExpiresAt     time.Time  `gorm:"not null" json:"expires_at"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+	RevokedReason string     `gorm:"type:varchar(50)" json:"revoked_reason,omitempty"`
+}
+
+// Active reports whether the session can still authenticate requests.
+func (s *Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// RefreshToken is one refresh token issued for a session. Only the SHA-256
+// hash of the token is stored. A token is single use: refreshing marks it
+// used and issues its successor, so presenting a used token again means it
+// leaked.
+type RefreshToken struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	SessionID string     `gorm:"type:varchar(64);not null;index" json:"session_id"`
+	TokenHash string     `gorm:"type:char(64);not null;uniqueIndex" json:"-"`
+	UserAgent string     `gorm:"type:varchar(255)" json:"user_agent"`
+	IP        string     `gorm:"type:varchar(45)" json:"ip"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+}
+
+// Session revocation reasons.
+const (
+	RevokedLogout          = "logout"
+	RevokedTokenReuse      = "refresh_token_reuse"
+	RevokedAgentChanged    = "user_agent_changed"
+	RevokedUserDisabled    = "user_disabled"
+	RevokedUserDeleted     = "user_deleted"
+	RevokedRoleChanged     = "role_changed"
+	RevokedPasswordChanged = "password_changed"
+	RevokedPasswordReset   = "password_reset"
+)
+
+// TokenPair is returned by login and refresh.
+type TokenPair struct {
+	AccessToken      string `json:"access_token"`
+	RefreshToken     string `json:"refresh_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int    `json:"expires_in"`
+	RefreshExpiresIn int    `json:"refresh_expires_in"`
+}
@@ -0,0 +1,33 @@
+package domain
+
+import "time"
+
+// Reasons a stock movement can be recorded with.
+const (
+	MovementReceive = "receive"
+	MovementIssue   = "issue"
+	MovementAdjust  = "adjust"
+	MovementScrap   = "scrap"
+)
+
+// StockMovement is a single entry of the inventory stock ledger. The stock of
+// an inventory always equals the sum of the deltas of its movements.
+type StockMovement struct {
+	ID          int       `json:"id"`
+	InventoryID int       `json:"inventory_id"`
+	Delta       int       `json:"delta" validate:"required"`
+	Reason      string    `json:"reason" validate:"required,oneof=receive issue adjust scrap"`
+	Reference   string    `json:"reference" validate:"max=100"`
+	ActorID     *int      `json:"actor_id"`
+	StockAfter  int       `json:"stock_after"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// StockReconciliation compares the stored stock of an inventory with the
+// balance derived from its ledger. Drift is non-zero when they disagree.
+type StockReconciliation struct {
+	InventoryID int `json:"inventory_id"`
+	Stock       int `json:"stock"`
+	LedgerStock int `json:"ledger_stock"`
+	Drift       int `json:"drift"`
+}
@@ -0,0 +1,59 @@
+package domain
+
+import "time"
+
+// TOTPCredential is a user's RFC 6238 authenticator. It only protects logins
+// once ConfirmedAt is set. LastUsedStep is the time step of the last accepted
+// code, so each code works once.
+type TOTPCredential struct {
+	UserID       uint       `gorm:"primaryKey" json:"user_id"`
+	Secret       string     `gorm:"type:varchar(64);not null" json:"-"`
+	LastUsedStep int64      `gorm:"not null;default:0" json:"-"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ConfirmedAt  *time.Time `json:"confirmed_at,omitempty"`
+}
+
+// TableName keeps one credential per user in user_totp.
+func (TOTPCredential) TableName() string {
+	return "user_totp"
+}
+
+// Enabled reports whether logins of the user require a second factor.
+func (c *TOTPCredential) Enabled() bool {
+	return c != nil && c.ConfirmedAt != nil
+}
+
+// RecoveryCode is a single-use fallback for a lost authenticator. Only the
+// SHA-256 hash is stored.
+type RecoveryCode struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	CodeHash  string     `gorm:"type:char(64);not null;uniqueIndex" json:"-"`
+	CreatedAt time.Time  `json:"created_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+}
+
+// TOTPEnrollment is returned when enrollment starts. QRCode is a data: URI
+// of a PNG encoding OTPAuthURI.
+type TOTPEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+	QRCode     string `json:"qr_code"`
+}
+
+// TwoFactorCode is the body of the endpoints that take a TOTP or recovery
+// code.
+type TwoFactorCode struct {
+	Code string `json:"code"`
+}
+
+// TwoFactorLogin is the body of POST /login/2fa.
+type TwoFactorLogin struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+}
+
+// RoleTwoFactor is the body of PATCH /roles/:name.
+type RoleTwoFactor struct {
+	RequireTwoFactor *bool `json:"require_two_factor"`
+}
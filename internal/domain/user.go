@@ -1,6 +1,10 @@
 package domain
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type User struct {
 	gorm.Model
@@ -9,5 +13,50 @@ type User struct {
 	FullName   string `gorm:"not null" json:"full_name" validate:"required,min=6,max=15"`
 	Age        int    `gorm:"not null" json:"age" validate:"required,gte=17"`
 	Occupation string `gorm:"not null" json:"occupation" validate:"required"`
-	Role       string `gorm:"not null;default:admin" json:"role" validate:"required,oneof=admin superadmin"`
+	Role       string `gorm:"not null;default:admin" json:"role" validate:"required,max=50"`
+	Disabled   bool   `gorm:"not null;default:false" json:"disabled"`
+
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+}
+
+// EmailVerified reports whether the user confirmed their email address.
+func (u *User) EmailVerified() bool {
+	return u.EmailVerifiedAt != nil
 }
+
+// UserPatchFields maps the JSON members an administrator may change with
+// PATCH /users/:id to the struct fields they populate.
+var UserPatchFields = map[string]string{
+	"full_name":  "FullName",
+	"age":        "Age",
+	"occupation": "Occupation",
+	"role":       "Role",
+	"disabled":   "Disabled",
+}
+
+// ProfilePatchFields are the members users may change about themselves with
+// PATCH /me.
+var ProfilePatchFields = map[string]string{
+	"full_name":  "FullName",
+	"age":        "Age",
+	"occupation": "Occupation",
+}
+
+// PasswordChange is the body of POST /me/password.
+type PasswordChange struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// UserQuery describes a page-numbered user listing. Search matches email or
+// full name; Disabled filters on account state when non-nil.
+type UserQuery struct {
+	Page     int
+	PerPage  int
+	Search   string
+	Role     string
+	Disabled *bool
+	Sort     []SortField
+}
+
+var UserSortFields = []string{"id", "email", "full_name", "role", "created_at"}
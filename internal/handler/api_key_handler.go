@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"avenger/internal/apperr"
+	"avenger/internal/domain"
+	"avenger/internal/middleware"
+	"avenger/internal/service"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/julienschmidt/httprouter"
+)
+
+const maxAPIKeyPerPage = 100
+
+type APIKeyHandler struct {
+	service  service.APIKeyService
+	validate *validator.Validate
+}
+
+func NewAPIKeyHandler(s service.APIKeyService) *APIKeyHandler {
+	return &APIKeyHandler{service: s, validate: validator.New()}
+}
+
+// apiKeyResponse carries the plain key, which is only ever shown once.
+type apiKeyResponse struct {
+	*domain.APIKey
+	Key string `json:"key"`
+}
+
+func (h *APIKeyHandler) Create(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	var req domain.APIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidBody, "Invalid request body", map[string]string{
+			"body": "Request body must be valid JSON",
+		})
+		return
+	}
+
+	if err := h.validate.Struct(req); err != nil {
+		logger(r).Warn("Create API key validation failed", slog.Any("error", err))
+		writeAppError(w, r, apperr.FromValidation(err))
+		return
+	}
+
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	key, raw, err := h.service.Create(r.Context(), claims.UserID, claims.Permissions, req)
+	if err != nil {
+		logger(r).Error("Create API key error", slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	logger(r).Info("API key created", slog.Uint64("id", uint64(key.ID)), slog.String("prefix", key.Prefix), slog.Any("scopes", key.Scopes), slog.Int("by", claims.UserID))
+
+	writeJSON(w, http.StatusCreated, Response{
+		Message: "API key created, store it now as it will not be shown again",
+		Data:    apiKeyResponse{APIKey: key, Key: raw},
+	})
+}
+
+func (h *APIKeyHandler) GetAll(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	errs := map[string]string{}
+	page, perPage := parsePagination(r.URL.Query(), service.DefaultAPIKeyPerPage, maxAPIKeyPerPage, errs)
+	if len(errs) > 0 {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidQuery, "Invalid query parameters", errs)
+		return
+	}
+
+	keys, total, err := h.service.GetAll(r.Context(), page, perPage)
+	if err != nil {
+		logger(r).Error("GetAll API keys error", slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, Response{
+		Message: "success",
+		Data:    keys,
+		Meta:    newPageMeta(r, page, perPage, total),
+	})
+}
+
+func (h *APIKeyHandler) Revoke(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id, err := strconv.Atoi(p.ByName("id"))
+	if err != nil || id <= 0 {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidID, "Invalid ID parameter", map[string]string{
+			"id": "ID must be a positive integer",
+		})
+		return
+	}
+
+	if err := h.service.Revoke(r.Context(), id); err != nil {
+		logger(r).Error("Revoke API key error", slog.Int("id", id), slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	logger(r).Info("API key revoked", slog.Int("id", id), slog.Int("by", claims.UserID))
+
+	writeJSON(w, http.StatusOK, Response{
+		Message: "API key revoked successfully",
+		Data: map[string]any{
+			"id": id,
+		},
+	})
+}
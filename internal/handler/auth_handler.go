@@ -1,13 +1,14 @@
 package handler
 
 import (
+	"avenger/internal/apperr"
 	"avenger/internal/domain"
+	"avenger/internal/middleware"
 	"avenger/internal/service"
-	"avenger/pkg/utils"
+	"context"
 	"encoding/json"
 	"log/slog"
 	"net/http"
-	"strings"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/julienschmidt/httprouter"
@@ -15,60 +16,74 @@ import (
 )
 
 type AuthHandler struct {
-	service  service.UserService
-	validate *validator.Validate
+	service    service.UserService
+	sessions   service.SessionService
+	resets     service.PasswordResetService
+	verify     service.EmailVerificationService
+	guard      service.LoginGuard
+	twoFactor  service.TwoFactorService
+	validate   *validator.Validate
+	bcryptCost int
 }
 
-func NewAuthHandler(s service.UserService) *AuthHandler {
-	return &AuthHandler{service: s, validate: validator.New()}
+func NewAuthHandler(s service.UserService, sessions service.SessionService, resets service.PasswordResetService, verify service.EmailVerificationService, guard service.LoginGuard, twoFactor service.TwoFactorService, bcryptCost int) *AuthHandler {
+	return &AuthHandler{service: s, sessions: sessions, resets: resets, verify: verify, guard: guard, twoFactor: twoFactor, validate: validator.New(), bcryptCost: bcryptCost}
 }
 
 func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	var user domain.User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid request body", map[string]string{
+	var req domain.RegisterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidBody, "Invalid request body", map[string]string{
 			"body": "Request body must be valid JSON",
 		})
 		return
 	}
+	user := req.User()
 
-	if err := h.service.ValidateUser(user); err != nil {
-		slog.Warn("User validation failed", slog.Any("error", err))
-		writeError(w, http.StatusBadRequest, "Invalid request body", map[string]string{
-			"validation": err.Error(),
-		})
+	if err := h.service.ValidateUser(r.Context(), user); err != nil {
+		logger(r).Warn("User validation failed", slog.Any("error", err))
+		writeAppError(w, r, err)
 		return
 	}
 
 	if len(user.Password) < 8 {
-		writeError(w, http.StatusBadRequest, "Validation failed", map[string]string{
+		writeError(w, r, http.StatusBadRequest, apperr.CodeValidation, "Validation failed", map[string]string{
 			"password": "Password must be at least 8 characters",
 		})
 		return
 	}
 
-	hashed, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	hashed, err := bcrypt.GenerateFromPassword([]byte(user.Password), h.bcryptCost)
 	if err != nil {
-		slog.Error("Failed to hash password", slog.Any("error", err))
-		writeError(w, http.StatusInternalServerError, "Failed to process password", nil)
+		logger(r).Error("Failed to hash password", slog.Any("error", err))
+		writeAppError(w, r, err)
 		return
 	}
 	user.Password = string(hashed)
 
-	if err := h.service.Register(&user); err != nil {
-		slog.Error("Failed to register user", slog.Any("error", err))
-		if strings.Contains(err.Error(), "duplicate") || strings.Contains(err.Error(), "already exists") {
-			writeError(w, http.StatusConflict, "Email already registered", nil)
-			return
-		}
-		writeError(w, http.StatusInternalServerError, "Failed to register user", nil)
+	if err := h.service.Register(r.Context(), &user, req.InviteCode); err != nil {
+		logger(r).Error("Failed to register user", slog.Any("error", err))
+		writeAppError(w, r, err)
 		return
 	}
 
 	user.Password = ""
 
+	if user.EmailVerified() {
+		writeJSON(w, http.StatusCreated, Response{
+			Message: "User registered successfully",
+			Data:    user,
+		})
+		return
+	}
+
+	if err := h.verify.Send(r.Context(), &user); err != nil {
+		// The account exists; the user can ask for another link.
+		logger(r).Error("Failed to send verification email", slog.Uint64("user_id", uint64(user.ID)), slog.Any("error", err))
+	}
+
 	writeJSON(w, http.StatusCreated, Response{
-		Message: "User registered successfully",
+		Message: "User registered successfully, check your email to verify your address",
 		Data:    user,
 	})
 }
@@ -76,51 +91,162 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request, p httprou
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	var input domain.User
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid request body", map[string]string{
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidBody, "Invalid request body", map[string]string{
 			"body": "Request body must be valid JSON",
 		})
 		return
 	}
 
 	if input.Email == "" || input.Password == "" {
-		writeError(w, http.StatusBadRequest, "Validation failed", map[string]string{
+		writeError(w, r, http.StatusBadRequest, apperr.CodeValidation, "Validation failed", map[string]string{
 			"credentials": "Email and password are required",
 		})
 		return
 	}
 
-	user, err := h.service.GetByEmail(input.Email)
+	attempt := domain.LoginAttempt{Email: input.Email, IP: clientIP(r), UserAgent: r.UserAgent()}
+	// Refuse before looking at the password so guesses during a lockout
+	// reveal nothing.
+	if err := h.guard.Check(r.Context(), attempt); err != nil {
+		logger(r).Warn("Login attempt while locked out", slog.String("email", input.Email), slog.String("ip", attempt.IP))
+		writeAppError(w, r, err)
+		return
+	}
+
+	user, err := h.service.GetByEmail(r.Context(), input.Email)
 	if err != nil {
-		slog.Error("Failed to get user by email", slog.String("email", input.Email), slog.Any("error", err))
-		writeError(w, http.StatusUnauthorized, "Invalid credentials", nil)
+		logger(r).Error("Failed to get user by email", slog.String("email", input.Email), slog.Any("error", err))
+		writeAppError(w, r, err)
 		return
 	}
 
 	if user == nil {
-		slog.Warn("Login attempt with non-existent email", slog.String("email", input.Email))
-		writeError(w, http.StatusUnauthorized, "Invalid credentials", nil)
+		logger(r).Warn("Login attempt with non-existent email", slog.String("email", input.Email))
+		attempt.Reason = domain.LoginFailedUnknownEmail
+		h.loginFailed(r, attempt)
+		writeError(w, r, http.StatusUnauthorized, apperr.CodeInvalidCredentials, "Invalid credentials", nil)
 		return
 	}
+	attempt.UserID = &user.ID
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)); err != nil {
-		slog.Warn("Login attempt with incorrect password", slog.String("email", input.Email))
-		writeError(w, http.StatusUnauthorized, "Invalid credentials", nil)
+		logger(r).Warn("Login attempt with incorrect password", slog.String("email", input.Email))
+		attempt.Reason = domain.LoginFailedBadPassword
+		h.loginFailed(r, attempt)
+		writeError(w, r, http.StatusUnauthorized, apperr.CodeInvalidCredentials, "Invalid credentials", nil)
+		return
+	}
+
+	if user.Disabled {
+		logger(r).Warn("Login attempt on disabled account", slog.String("email", input.Email))
+		attempt.Reason = domain.LoginFailedDisabled
+		h.loginFailed(r, attempt)
+		writeError(w, r, http.StatusForbidden, apperr.CodeAccountDisabled, "Account is disabled", nil)
 		return
 	}
 
-	token, err := utils.GenerateJWT(int(user.ID), user.Role)
+	enabled, err := h.twoFactor.Enabled(r.Context(), user.ID)
 	if err != nil {
-		slog.Error("Failed to generate JWT token", slog.Any("error", err))
-		writeError(w, http.StatusInternalServerError, "Failed to generate authentication token", nil)
+		logger(r).Error("Failed to check two-factor enrollment", slog.Uint64("user_id", uint64(user.ID)), slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+	if enabled {
+		// Failures are only cleared once the second factor is verified, so
+		// TOTP guesses keep counting towards the lockout.
+		challenge, err := h.twoFactor.Challenge(r.Context(), user.ID)
+		if err != nil {
+			logger(r).Error("Failed to issue two-factor challenge", slog.Any("error", err))
+			writeAppError(w, r, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, Response{
+			Message: "Two-factor authentication required",
+			Data: map[string]any{
+				"two_factor_required": true,
+				"challenge_token":     challenge,
+				"expires_in":          int(service.TwoFactorChallengeTTL.Seconds()),
+			},
+		})
 		return
 	}
 
-	slog.Info("User logged in successfully", slog.String("email", user.Email), slog.String("role", user.Role))
+	h.loginSucceeded(w, r, user, false)
+}
+
+// LoginTwoFactor completes a login that passed the password step by
+// exchanging its challenge token and a TOTP or recovery code for tokens.
+func (h *AuthHandler) LoginTwoFactor(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	var input domain.TwoFactorLogin
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidBody, "Invalid request body", map[string]string{
+			"body": "Request body must be valid JSON",
+		})
+		return
+	}
+
+	if input.ChallengeToken == "" || input.Code == "" {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeValidation, "Validation failed", map[string]string{
+			"code": "challenge_token and code are required",
+		})
+		return
+	}
+
+	user, err := h.twoFactor.ChallengeUser(r.Context(), input.ChallengeToken)
+	if err != nil {
+		logger(r).Warn("Two-factor login with invalid challenge", slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	attempt := domain.LoginAttempt{Email: user.Email, UserID: &user.ID, IP: clientIP(r), UserAgent: r.UserAgent()}
+	if err := h.guard.Check(r.Context(), attempt); err != nil {
+		logger(r).Warn("Two-factor login attempt while locked out", slog.String("email", user.Email), slog.String("ip", attempt.IP))
+		writeAppError(w, r, err)
+		return
+	}
+
+	if err := h.twoFactor.Verify(r.Context(), user.ID, input.Code); err != nil {
+		if apperr.KindOf(err) == apperr.KindUnauthorized {
+			logger(r).Warn("Two-factor login with invalid code", slog.String("email", user.Email))
+			attempt.Reason = domain.LoginFailedBadTwoFactor
+			h.loginFailed(r, attempt)
+		} else {
+			logger(r).Error("Failed to verify two-factor code", slog.Any("error", err))
+		}
+		writeAppError(w, r, err)
+		return
+	}
+
+	h.loginSucceeded(w, r, user, true)
+}
+
+// loginSucceeded clears the account's failed logins and answers with a new
+// session's tokens.
+func (h *AuthHandler) loginSucceeded(w http.ResponseWriter, r *http.Request, user *domain.User, twoFactor bool) {
+	if err := h.guard.Succeeded(r.Context(), user.Email); err != nil {
+		logger(r).Error("Failed to reset login failures", slog.String("email", user.Email), slog.Any("error", err))
+	}
+
+	tokens, err := h.sessions.Create(r.Context(), user, r.UserAgent(), clientIP(r), twoFactor)
+	if err != nil {
+		logger(r).Error("Failed to create session", slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	logger(r).Info("User logged in successfully", slog.String("email", user.Email), slog.String("role", user.Role), slog.Bool("two_factor", twoFactor))
 
 	writeJSON(w, http.StatusOK, Response{
 		Message: "Login successsful",
 		Data: map[string]any{
-			"token": token,
+			"token":              tokens.AccessToken,
+			"access_token":       tokens.AccessToken,
+			"refresh_token":      tokens.RefreshToken,
+			"token_type":         tokens.TokenType,
+			"expires_in":         tokens.ExpiresIn,
+			"refresh_expires_in": tokens.RefreshExpiresIn,
 			"user": map[string]any{
 				"id":        user.ID,
 				"email":     user.Email,
@@ -130,3 +256,182 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request, p httprouter
 		},
 	})
 }
+
+// loginFailed counts and audits a failed login. The client already gets an
+// error response, so a failure here is only logged.
+func (h *AuthHandler) loginFailed(r *http.Request, attempt domain.LoginAttempt) {
+	// Counted even if the client hangs up, or dropping the connection
+	// after each guess would dodge the lockout.
+	if err := h.guard.Failed(context.WithoutCancel(r.Context()), attempt); err != nil {
+		logger(r).Error("Failed to record failed login", slog.String("email", attempt.Email), slog.Any("error", err))
+	}
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Refresh exchanges a refresh token for a new access/refresh token pair. The
+// presented refresh token is spent in the process.
+func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	var input refreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidBody, "Invalid request body", map[string]string{
+			"body": "Request body must be valid JSON",
+		})
+		return
+	}
+
+	if input.RefreshToken == "" {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeValidation, "Validation failed", map[string]string{
+			"refresh_token": "refresh_token is required",
+		})
+		return
+	}
+
+	tokens, err := h.sessions.Refresh(r.Context(), input.RefreshToken, r.UserAgent(), clientIP(r))
+	if err != nil {
+		logger(r).Warn("Failed to refresh token", slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, Response{
+		Message: "Token refreshed successfully",
+		Data:    tokens,
+	})
+}
+
+// Logout revokes the session of the access token used for the request, which
+// also invalidates its refresh token.
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	claims, ok := middleware.ClaimsFromContext(r.Context())
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, apperr.CodeUnauthorized, "Unauthorized", nil)
+		return
+	}
+
+	if err := h.sessions.Revoke(r.Context(), claims.SessionID); err != nil {
+		logger(r).Error("Failed to revoke session", slog.String("session_id", claims.SessionID), slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	logger(r).Info("User logged out", slog.Int("user_id", claims.UserID))
+
+	writeJSON(w, http.StatusOK, Response{
+		Message: "Logged out successfully",
+	})
+}
+
+type emailRequest struct {
+	Email string `json:"email"`
+}
+
+// ForgotPassword mails a reset link. The response is the same whether or not
+// the email is registered.
+func (h *AuthHandler) ForgotPassword(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	var input emailRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidBody, "Invalid request body", map[string]string{
+			"body": "Request body must be valid JSON",
+		})
+		return
+	}
+
+	if input.Email == "" {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeValidation, "Validation failed", map[string]string{
+			"email": "email is required",
+		})
+		return
+	}
+
+	if err := h.resets.Forgot(r.Context(), input.Email); err != nil {
+		logger(r).Error("Failed to start password reset", slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, Response{
+		Message: "If the email is registered, a password reset link has been sent",
+	})
+}
+
+// ResetPassword sets a new password using a token from ForgotPassword.
+func (h *AuthHandler) ResetPassword(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	var input domain.PasswordReset
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidBody, "Invalid request body", map[string]string{
+			"body": "Request body must be valid JSON",
+		})
+		return
+	}
+
+	if input.Token == "" || input.NewPassword == "" {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeValidation, "Validation failed", map[string]string{
+			"token": "token and new_password are required",
+		})
+		return
+	}
+
+	if err := h.resets.Reset(r.Context(), input); err != nil {
+		logger(r).Warn("Password reset failed", slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, Response{
+		Message: "Password has been reset, please log in again",
+	})
+}
+
+// VerifyEmail confirms the address of the account a verification token was
+// mailed to.
+func (h *AuthHandler) VerifyEmail(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidQuery, "Invalid query parameters", map[string]string{
+			"token": "token is required",
+		})
+		return
+	}
+
+	if err := h.verify.Verify(r.Context(), token); err != nil {
+		logger(r).Warn("Email verification failed", slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, Response{
+		Message: "Email verified successfully",
+	})
+}
+
+// ResendVerification mails a new verification link. The response is the same
+// whether or not the email is registered, verified or throttled.
+func (h *AuthHandler) ResendVerification(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	var input emailRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidBody, "Invalid request body", map[string]string{
+			"body": "Request body must be valid JSON",
+		})
+		return
+	}
+
+	if input.Email == "" {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeValidation, "Validation failed", map[string]string{
+			"email": "email is required",
+		})
+		return
+	}
+
+	if err := h.verify.Resend(r.Context(), input.Email); err != nil {
+		logger(r).Error("Failed to resend verification", slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, Response{
+		Message: "If the email is registered and not yet verified, a new verification link has been sent",
+	})
+}
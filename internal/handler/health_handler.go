@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"avenger/internal/health"
+	"log/slog"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type HealthHandler struct {
+	checker *health.Checker
+}
+
+func NewHealthHandler(checker *health.Checker) *HealthHandler {
+	return &HealthHandler{checker: checker}
+}
+
+// Live answers the liveness probe: the process is up and serving HTTP. It
+// checks no dependencies, so an outage never gets the process restarted.
+func (h *HealthHandler) Live(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, health.Report{Status: health.StatusOK})
+}
+
+// Ready answers the readiness probe with the result of every dependency
+// check, and 503 when any failed or the server is shutting down. Like the
+// JWKS the body is bare JSON so probes and dashboards can read it directly.
+func (h *HealthHandler) Ready(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	report := h.checker.Ready(r.Context())
+
+	status := http.StatusOK
+	if !report.Ready() {
+		status = http.StatusServiceUnavailable
+		logger(r).Warn("Not ready", slog.String("status", report.Status), slog.Any("checks", report.Checks))
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, status, report)
+}
@@ -1,20 +1,63 @@
 package handler
 
 import (
+	"avenger/internal/apperr"
+	"avenger/internal/domain"
+	"avenger/internal/problem"
+	"avenger/pkg/reqctx"
+	"avenger/pkg/utils"
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"log/slog"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
-	"github.com/go-playground/validator"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Response struct {
 	Message string `json:"message"`
 	Data    any    `json:"data,omitempty"`
+	Meta    any    `json:"meta,omitempty"`
 	Errors  any    `json:"errors,omitempty"`
 }
 
+// PageMeta is returned in Response.Meta for page-numbered listings.
+type PageMeta struct {
+	Page       int       `json:"page"`
+	PerPage    int       `json:"per_page"`
+	Total      int       `json:"total"`
+	TotalPages int       `json:"total_pages"`
+	Links      PageLinks `json:"links"`
+}
+
+type PageLinks struct {
+	Self string `json:"self"`
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
+
+// CursorMeta is returned in Response.Meta for cursor-paginated listings.
+// NextCursor is empty on the last page.
+type CursorMeta struct {
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	Next       string `json:"next,omitempty"`
+}
+
+// RequireIfMatch makes the If-Match header mandatory on PUT and DELETE of
+// versioned resources. When false, a request without it is applied
+// unconditionally.
+var RequireIfMatch = true
+
 func writeJSON(w http.ResponseWriter, status int, data any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -23,42 +66,279 @@ func writeJSON(w http.ResponseWriter, status int, data any) {
 	}
 }
 
-func writeError(w http.ResponseWriter, status int, message string, errors any) {
-	writeJSON(w, status, Response{
-		Message: message,
-		Errors:  errors,
+// writeError reports a failure as application/problem+json when the client
+// accepts it and in the legacy Response envelope otherwise. code is one of
+// the stable apperr.Code* values.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string, errors map[string]string) {
+	problem.Write(w, r, status, code, message, errors)
+}
+
+// logger returns the logger of r, which carries its request ID.
+func logger(r *http.Request) *slog.Logger {
+	return reqctx.Logger(r.Context())
+}
+
+// clientIP returns the client address resolved by the real IP middleware,
+// or the address of the peer that sent r when it did not run.
+func clientIP(r *http.Request) string {
+	if ip := reqctx.ClientIP(r.Context()); ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func setETag(w http.ResponseWriter, version int) {
+	w.Header().Set("ETag", `"`+strconv.Itoa(version)+`"`)
+}
+
+// ifMatchVersion returns the resource version required by the If-Match
+// header, or 0 when any version is acceptable. It writes the error response
+// and returns false when the request must not proceed.
+func ifMatchVersion(w http.ResponseWriter, r *http.Request) (int, bool) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		if RequireIfMatch {
+			writeError(w, r, http.StatusPreconditionRequired, apperr.CodePreconditionRequired, "If-Match header is required", map[string]string{
+				"if-match": "Send the ETag returned by GET as If-Match",
+			})
+			return 0, false
+		}
+		return 0, true
+	}
+
+	if header == "*" {
+		return 0, true
+	}
+
+	// Only a single strong ETag can ever match one of ours.
+	version, err := strconv.Atoi(strings.Trim(header, `"`))
+	if err != nil || version <= 0 || !strings.HasPrefix(header, `"`) || !strings.HasSuffix(header, `"`) {
+		writeError(w, r, http.StatusPreconditionFailed, apperr.CodePreconditionFailed, "Precondition failed", map[string]string{
+			"if-match": "If-Match does not match the current version",
+		})
+		return 0, false
+	}
+
+	return version, true
+}
+
+// writeAppError writes err using the status that matches its apperr kind.
+// Only the client-facing message of typed errors is exposed; anything else
+// is reported as an internal server error.
+func writeAppError(w http.ResponseWriter, r *http.Request, err error) {
+	var conflict *apperr.VersionConflictError
+	if errors.As(err, &conflict) {
+		writeVersionConflict(w, r, conflict)
+		return
+	}
+
+	var appErr *apperr.Error
+	if !errors.As(err, &appErr) || appErr.Kind == apperr.KindInternal {
+		// A query aborted by the route timeout or a departed client is not
+		// a server fault.
+		if ctxErr := r.Context().Err(); ctxErr != nil {
+			writeCanceled(w, r, ctxErr)
+			return
+		}
+		// The cause never reaches the client; keep it on the trace.
+		trace.SpanFromContext(r.Context()).RecordError(err)
+		writeError(w, r, http.StatusInternalServerError, apperr.CodeInternal, "Internal server error", nil)
+		return
+	}
+
+	if appErr.RetryAfter > 0 {
+		setRetryAfter(w, appErr.RetryAfter)
+	}
+	writeError(w, r, statusForKind(appErr.Kind), appErr.ErrorCode(), appErr.Message, appErr.Fields)
+}
+
+// statusClientClosedRequest is the nginx status for requests the client
+// abandoned. Nobody receives it, but it keeps them apart from server errors
+// in access logs and metrics.
+const statusClientClosedRequest = 499
+
+// writeCanceled reports a request whose context ended before its work was
+// done: the route timeout passed or the client disconnected.
+func writeCanceled(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		writeError(w, r, http.StatusServiceUnavailable, apperr.CodeRequestTimeout, "The request took too long, try again later", nil)
+		return
+	}
+	writeError(w, r, statusClientClosedRequest, apperr.CodeClientClosedRequest, "Client closed request", nil)
+}
+
+// setRetryAfter sets Retry-After in whole seconds, rounding up so clients
+// never retry early.
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	w.Header().Set("Retry-After", strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10))
+}
+
+func statusForKind(kind apperr.Kind) int {
+	switch kind {
+	case apperr.KindNotFound:
+		return http.StatusNotFound
+	case apperr.KindConflict:
+		return http.StatusConflict
+	case apperr.KindValidation:
+		return http.StatusBadRequest
+	case apperr.KindUnauthorized:
+		return http.StatusUnauthorized
+	case apperr.KindForbidden:
+		return http.StatusForbidden
+	case apperr.KindTooManyRequests:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeVersionConflict reports a stale If-Match and returns the current ETag.
+func writeVersionConflict(w http.ResponseWriter, r *http.Request, conflict *apperr.VersionConflictError) {
+	setETag(w, conflict.Current)
+	writeError(w, r, http.StatusPreconditionFailed, apperr.CodeVersionConflict, "Precondition failed", map[string]string{
+		"if-match": "The " + conflict.Resource + " was modified by someone else, fetch it again and retry",
 	})
 }
 
-func formatValidationErrors(err error) map[string]string {
-	errors := make(map[string]string)
-
-	if validationErrs, ok := err.(validator.ValidationErrors); ok {
-		for _, e := range validationErrs {
-			field := strings.ToLower(e.Field())
-
-			switch e.Tag() {
-			case "required":
-				errors[field] = field + "is required"
-			case "email":
-				errors[field] = field + " must be a valid email address"
-			case "min":
-				errors[field] = field + " must be at least " + e.Param() + " characters"
-			case "max":
-				errors[field] = field + " must be at most " + e.Param() + " characters"
-			case "gte":
-				errors[field] = field + " must be greater than or equal to " + e.Param()
-			case "lte":
-				errors[field] = field + " must be less than or equal to " + e.Param()
-			case "gt":
-				errors[field] = field + " must be greater than " + e.Param()
-			case "oneof":
-				errors[field] = field + " must be one of: " + e.Param()
-			default:
-				errors[field] = field + "is invalid"
-			}
+// applyPatch applies the request body to current as either a JSON Merge
+// Patch (RFC 7396) or a JSON Patch (RFC 6902), depending on Content-Type. It
+// returns the patched document and the top-level members the patch touched.
+// It writes the error response and returns false when the patch is unusable.
+func applyPatch(w http.ResponseWriter, r *http.Request, current any) ([]byte, []string, bool) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var apply func(doc, patch []byte) ([]byte, []string, error)
+	switch mediaType {
+	case utils.MergePatchContentType, "application/json", "":
+		apply = utils.MergePatch
+	case utils.JSONPatchContentType:
+		apply = utils.ApplyJSONPatch
+	default:
+		w.Header().Set("Accept-Patch", utils.MergePatchContentType+", "+utils.JSONPatchContentType)
+		writeError(w, r, http.StatusUnsupportedMediaType, apperr.CodeUnsupportedMediaType, "Unsupported patch format", map[string]string{
+			"content-type": "Use " + utils.MergePatchContentType + " or " + utils.JSONPatchContentType,
+		})
+		return nil, nil, false
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidBody, "Invalid request body", map[string]string{
+			"body": "Failed to read request body",
+		})
+		return nil, nil, false
+	}
+
+	doc, err := json.Marshal(current)
+	if err != nil {
+		logger(r).Error("Failed to encode resource for patching", slog.Any("error", err))
+		writeError(w, r, http.StatusInternalServerError, apperr.CodeInternal, "Failed to apply patch", nil)
+		return nil, nil, false
+	}
+
+	patched, fields, err := apply(doc, body)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidPatch, "Invalid patch", map[string]string{
+			"body": err.Error(),
+		})
+		return nil, nil, false
+	}
+
+	return patched, fields, true
+}
+
+// parsePagination reads the page and per_page query parameters, falling back
+// to defaultPerPage and capping per_page at maxPerPage.
+func parsePagination(q url.Values, defaultPerPage, maxPerPage int, errs map[string]string) (int, int) {
+	page, perPage := 1, defaultPerPage
+
+	if v := q.Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			errs["page"] = "page must be a positive integer"
+		} else {
+			page = n
 		}
 	}
 
-	return errors
+	if v := q.Get("per_page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			errs["per_page"] = "per_page must be a positive integer"
+		} else {
+			perPage = min(n, maxPerPage)
+		}
+	}
+
+	return page, perPage
+}
+
+// parseIntParam parses an optional integer query parameter.
+func parseIntParam(q url.Values, name string, errs map[string]string) *int {
+	v := q.Get(name)
+	if v == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		errs[name] = name + " must be an integer"
+		return nil
+	}
+	return &n
+}
+
+// parseSort parses a comma separated sort parameter such as "name,-stock".
+// A leading "-" requests descending order.
+func parseSort(raw string, allowed []string, errs map[string]string) []domain.SortField {
+	if raw == "" {
+		return nil
+	}
+
+	var fields []domain.SortField
+	for _, term := range strings.Split(raw, ",") {
+		term = strings.TrimSpace(term)
+		desc := strings.HasPrefix(term, "-")
+		name := strings.TrimPrefix(term, "-")
+
+		if !slices.Contains(allowed, name) {
+			errs["sort"] = "sort must be a comma separated list of: " + strings.Join(allowed, ", ")
+			return nil
+		}
+		fields = append(fields, domain.SortField{Field: name, Desc: desc})
+	}
+
+	return fields
+}
+
+// newPageMeta builds the pagination metadata, deriving the next/prev links
+// from the current request URL.
+func newPageMeta(r *http.Request, page, perPage, total int) PageMeta {
+	totalPages := (total + perPage - 1) / perPage
+
+	link := func(p int) string {
+		q := r.URL.Query()
+		q.Set("page", strconv.Itoa(p))
+		q.Set("per_page", strconv.Itoa(perPage))
+		return r.URL.Path + "?" + q.Encode()
+	}
+
+	meta := PageMeta{
+		Page:       page,
+		PerPage:    perPage,
+		Total:      total,
+		TotalPages: totalPages,
+		Links:      PageLinks{Self: link(page)},
+	}
+	if page < totalPages {
+		meta.Links.Next = link(page + 1)
+	}
+	if page > 1 {
+		meta.Links.Prev = link(min(page-1, max(totalPages, 1)))
+	}
+
+	return meta
 }
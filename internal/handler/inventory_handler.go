@@ -1,13 +1,14 @@
 package handler
 
 import (
+	"avenger/internal/apperr"
 	"avenger/internal/domain"
+	"avenger/internal/middleware"
 	"avenger/internal/service"
 	"encoding/json"
 	"log/slog"
 	"net/http"
 	"strconv"
-	"strings"
 
 	"github.com/go-playground/validator"
 	"github.com/julienschmidt/httprouter"
@@ -23,16 +24,41 @@ func NewInventoryHandler(s service.InventoryService) *InventoryHandler {
 }
 
 func (h *InventoryHandler) GetAll(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	data, err := h.service.GetAll()
+	q := r.URL.Query()
+	errs := map[string]string{}
+
+	page, perPage := parsePagination(q, service.DefaultInventoryPerPage, service.MaxInventoryPerPage, errs)
+	query := domain.InventoryQuery{
+		Page:       page,
+		PerPage:    perPage,
+		Status:     q.Get("status"),
+		CodePrefix: q.Get("code"),
+		Name:       q.Get("name"),
+		StockGTE:   parseIntParam(q, "stock_gte", errs),
+		StockLTE:   parseIntParam(q, "stock_lte", errs),
+		Sort:       parseSort(q.Get("sort"), domain.InventorySortFields, errs),
+	}
+
+	if query.Status != "" && query.Status != "active" && query.Status != "broken" {
+		errs["status"] = "status must be one of: active broken"
+	}
+
+	if len(errs) > 0 {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidQuery, "Invalid query parameters", errs)
+		return
+	}
+
+	data, total, err := h.service.GetAll(r.Context(), query)
 	if err != nil {
-		slog.Error("GetAll inventory error", slog.Any("error", err))
-		writeError(w, http.StatusInternalServerError, "Failed to retrieve inventories", nil)
+		logger(r).Error("GetAll inventory error", slog.Any("error", err))
+		writeAppError(w, r, err)
 		return
 	}
 
 	writeJSON(w, http.StatusOK, Response{
 		Message: "success",
 		Data:    data,
+		Meta:    newPageMeta(r, page, perPage, total),
 	})
 }
 
@@ -40,21 +66,20 @@ func (h *InventoryHandler) GetByID(w http.ResponseWriter, r *http.Request, p htt
 	idStr := p.ByName("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil || id <= 0 {
-		writeError(w, http.StatusBadRequest, "Invalid ID parameter", map[string]string{
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidID, "Invalid ID parameter", map[string]string{
 			"id": "ID must be a postivie integer",
 		})
 		return
 	}
 
-	data, err := h.service.GetByID(id)
+	data, err := h.service.GetByID(r.Context(), id)
 	if err != nil {
-		slog.Error("GetByID inventory error", slog.Int("id", id), slog.Any("error", err))
-		if strings.Contains(err.Error(), "not found") {
-			writeError(w, http.StatusNotFound, "Inventory not found", nil)
-			return
-		}
+		logger(r).Error("GetByID inventory error", slog.Int("id", id), slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
 	}
 
+	setETag(w, data.Version)
 	writeJSON(w, http.StatusOK, Response{
 		Message: "success",
 		Data:    data,
@@ -64,7 +89,7 @@ func (h *InventoryHandler) GetByID(w http.ResponseWriter, r *http.Request, p htt
 func (h *InventoryHandler) Create(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	var inv domain.Inventory
 	if err := json.NewDecoder(r.Body).Decode(&inv); err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid request body", map[string]string{
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidBody, "Invalid request body", map[string]string{
 			"body": "Request body must be valid JSON",
 		})
 		return
@@ -72,21 +97,15 @@ func (h *InventoryHandler) Create(w http.ResponseWriter, r *http.Request, p http
 
 	// Validate
 	if err := h.validate.Struct(inv); err != nil {
-		slog.Warn("Create inventory validation failed", slog.Any("error", err))
-		writeError(w, http.StatusBadRequest, "Validation failed", formatValidationErrors(err))
+		logger(r).Warn("Create inventory validation failed", slog.Any("error", err))
+		writeAppError(w, r, apperr.FromValidation(err))
 		return
 	}
 
-	id, err := h.service.Create(inv)
+	id, err := h.service.Create(r.Context(), inv)
 	if err != nil {
-		slog.Error("Create inventory error", slog.Any("error", err))
-
-		if strings.Contains(err.Error(), "duplicate") || strings.Contains(err.Error(), "already exists") {
-			writeError(w, http.StatusConflict, "inventory already exists", nil)
-			return
-		}
-
-		writeError(w, http.StatusInternalServerError, "failed to create inventory", nil)
+		logger(r).Error("Create inventory error", slog.Any("error", err))
+		writeAppError(w, r, err)
 		return
 	}
 
@@ -101,46 +120,100 @@ func (h *InventoryHandler) Update(w http.ResponseWriter, r *http.Request, p http
 	idStr := p.ByName("id")
 	idInt, err := strconv.Atoi(idStr)
 	if err != nil || idInt <= 0 {
-		writeError(w, http.StatusBadRequest, "Invalid ID parameter", map[string]string{
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidID, "Invalid ID parameter", map[string]string{
 			"id": "ID must be a positive integer",
 		})
 		return
 	}
 
+	expected, ok := ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+
 	var inv domain.Inventory
 	if err := json.NewDecoder(r.Body).Decode(&inv); err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid request body", map[string]string{
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidBody, "Invalid request body", map[string]string{
 			"body": "Request body must be valid JSON",
 		})
 		return
 	}
 
 	if err := h.validate.Struct(inv); err != nil {
-		slog.Warn("Update inventory validation failed", slog.Int("id", idInt), slog.Any("error", err))
-		writeError(w, http.StatusBadRequest, "Validation failed", formatValidationErrors(err))
+		logger(r).Warn("Update inventory validation failed", slog.Int("id", idInt), slog.Any("error", err))
+		writeAppError(w, r, apperr.FromValidation(err))
+		return
+	}
+	inv.Version = expected
+
+	version, err := h.service.Update(r.Context(), idInt, inv)
+	if err != nil {
+		logger(r).Error("Update inventory error", slog.Int("id", idInt), slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	setETag(w, version)
+	writeJSON(w, http.StatusOK, Response{
+		Message: "Inventory updated successfully",
+		Data:    map[string]any{"id": idInt, "version": version},
+	})
+}
+
+func (h *InventoryHandler) Patch(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	idStr := p.ByName("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil || id <= 0 {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidID, "Invalid ID parameter", map[string]string{
+			"id": "ID must be a positive integer",
+		})
 		return
 	}
 
-	if err := h.service.Update(idInt, inv); err != nil {
-		slog.Error("Update inventory error", slog.Int("id", idInt), slog.Any("error", err))
+	expected, ok := ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
 
-		if strings.Contains(err.Error(), "not found") {
-			writeError(w, http.StatusNotFound, "Inventory not found", nil)
-			return
-		}
+	current, err := h.service.GetByID(r.Context(), id)
+	if err != nil {
+		logger(r).Error("Patch inventory lookup error", slog.Int("id", id), slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
 
-		if strings.Contains(err.Error(), "duplicate") || strings.Contains(err.Error(), "already exists") {
-			writeError(w, http.StatusConflict, "Inventory code already exists", nil)
-			return
-		}
+	if expected != 0 && expected != current.Version {
+		writeVersionConflict(w, r, &apperr.VersionConflictError{Resource: "inventory", Current: current.Version})
+		return
+	}
 
-		writeError(w, http.StatusInternalServerError, "Failed to update inventory", nil)
+	doc, fields, ok := applyPatch(w, r, current)
+	if !ok {
 		return
 	}
 
+	var inv domain.Inventory
+	if err := json.Unmarshal(doc, &inv); err != nil {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidPatch, "Invalid patch", map[string]string{
+			"body": "Patched inventory has invalid field types",
+		})
+		return
+	}
+
+	// The service checks which fields may change and validates them.
+	inv.Version = current.Version
+
+	version, err := h.service.Patch(r.Context(), id, inv, fields)
+	if err != nil {
+		logger(r).Error("Patch inventory error", slog.Int("id", id), slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	setETag(w, version)
 	writeJSON(w, http.StatusOK, Response{
 		Message: "Inventory updated successfully",
-		Data:    map[string]any{"id": idInt},
+		Data:    map[string]any{"id": id, "version": version},
 	})
 }
 
@@ -148,21 +221,20 @@ func (h *InventoryHandler) Delete(w http.ResponseWriter, r *http.Request, p http
 	idStr := p.ByName("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil || id <= 0 {
-		writeError(w, http.StatusBadRequest, "Invalid ID parameter", map[string]string{
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidID, "Invalid ID parameter", map[string]string{
 			"id": "ID must be a positive integer",
 		})
 		return
 	}
 
-	if err := h.service.Delete(id); err != nil {
-		slog.Error("Delete inventory error", slog.Int("id", id), slog.Any("error", err))
-
-		if strings.Contains(err.Error(), "not found") {
-			writeError(w, http.StatusNotFound, "Inventory not found", nil)
-			return
-		}
+	expected, ok := ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
 
-		writeError(w, http.StatusInternalServerError, "Failed to delete inventory", nil)
+	if err := h.service.Delete(r.Context(), id, expected); err != nil {
+		logger(r).Error("Delete inventory error", slog.Int("id", id), slog.Any("error", err))
+		writeAppError(w, r, err)
 		return
 	}
 
@@ -172,3 +244,100 @@ func (h *InventoryHandler) Delete(w http.ResponseWriter, r *http.Request, p http
 	})
 
 }
+
+func (h *InventoryHandler) AdjustStock(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	idStr := p.ByName("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil || id <= 0 {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidID, "Invalid ID parameter", map[string]string{
+			"id": "ID must be a positive integer",
+		})
+		return
+	}
+
+	var m domain.StockMovement
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidBody, "Invalid request body", map[string]string{
+			"body": "Request body must be valid JSON",
+		})
+		return
+	}
+
+	if err := h.validate.Struct(m); err != nil {
+		logger(r).Warn("Stock movement validation failed", slog.Int("id", id), slog.Any("error", err))
+		writeAppError(w, r, apperr.FromValidation(err))
+		return
+	}
+
+	// Movements made with an API key have no user to attribute them to.
+	m.ActorID = nil
+	if claims, ok := middleware.ClaimsFromContext(r.Context()); ok && !claims.IsAPIKey() {
+		m.ActorID = &claims.UserID
+	}
+
+	movement, err := h.service.AdjustStock(r.Context(), id, m)
+	if err != nil {
+		logger(r).Error("Adjust stock error", slog.Int("id", id), slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, Response{
+		Message: "Stock adjusted successfully",
+		Data:    movement,
+	})
+}
+
+func (h *InventoryHandler) GetMovements(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	idStr := p.ByName("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil || id <= 0 {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidID, "Invalid ID parameter", map[string]string{
+			"id": "ID must be a positive integer",
+		})
+		return
+	}
+
+	errs := map[string]string{}
+	page, perPage := parsePagination(r.URL.Query(), service.DefaultInventoryPerPage, service.MaxInventoryPerPage, errs)
+	if len(errs) > 0 {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidQuery, "Invalid query parameters", errs)
+		return
+	}
+
+	data, total, err := h.service.GetMovements(r.Context(), id, page, perPage)
+	if err != nil {
+		logger(r).Error("Get stock movements error", slog.Int("id", id), slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, Response{
+		Message: "success",
+		Data:    data,
+		Meta:    newPageMeta(r, page, perPage, total),
+	})
+}
+
+func (h *InventoryHandler) Reconcile(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	idStr := p.ByName("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil || id <= 0 {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidID, "Invalid ID parameter", map[string]string{
+			"id": "ID must be a positive integer",
+		})
+		return
+	}
+
+	data, err := h.service.Reconcile(r.Context(), id)
+	if err != nil {
+		logger(r).Error("Reconcile stock error", slog.Int("id", id), slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, Response{
+		Message: "success",
+		Data:    data,
+	})
+}
@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"avenger/internal/apperr"
+	"avenger/internal/domain"
+	"avenger/internal/middleware"
+	"avenger/internal/service"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/julienschmidt/httprouter"
+)
+
+const maxInvitationPerPage = 100
+
+type InvitationHandler struct {
+	service  service.InvitationService
+	validate *validator.Validate
+}
+
+func NewInvitationHandler(s service.InvitationService) *InvitationHandler {
+	return &InvitationHandler{service: s, validate: validator.New()}
+}
+
+// invitationResponse carries the invite code, which is only ever shown once.
+type invitationResponse struct {
+	*domain.Invitation
+	Code string `json:"code"`
+}
+
+func (h *InvitationHandler) Create(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	var req domain.InvitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidBody, "Invalid request body", map[string]string{
+			"body": "Request body must be valid JSON",
+		})
+		return
+	}
+
+	if err := h.validate.Struct(req); err != nil {
+		logger(r).Warn("Create invitation validation failed", slog.Any("error", err))
+		writeAppError(w, r, apperr.FromValidation(err))
+		return
+	}
+
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	inv, code, err := h.service.Create(r.Context(), claims.UserID, req)
+	if err != nil {
+		logger(r).Error("Create invitation error", slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	logger(r).Info("Invitation created", slog.String("id", inv.ID), slog.String("role", inv.Role), slog.Int("by", claims.UserID))
+
+	writeJSON(w, http.StatusCreated, Response{
+		Message: "Invitation created successfully",
+		Data:    invitationResponse{Invitation: inv, Code: code},
+	})
+}
+
+func (h *InvitationHandler) GetAll(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	errs := map[string]string{}
+	page, perPage := parsePagination(r.URL.Query(), service.DefaultInvitationPerPage, maxInvitationPerPage, errs)
+	if len(errs) > 0 {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidQuery, "Invalid query parameters", errs)
+		return
+	}
+
+	invs, total, err := h.service.GetAll(r.Context(), page, perPage)
+	if err != nil {
+		logger(r).Error("GetAll invitations error", slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, Response{
+		Message: "success",
+		Data:    invs,
+		Meta:    newPageMeta(r, page, perPage, total),
+	})
+}
+
+func (h *InvitationHandler) Revoke(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
+	if err := h.service.Revoke(r.Context(), id); err != nil {
+		logger(r).Error("Revoke invitation error", slog.String("id", id), slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	logger(r).Info("Invitation revoked", slog.String("id", id), slog.Int("by", claims.UserID))
+
+	writeJSON(w, http.StatusOK, Response{
+		Message: "Invitation revoked successfully",
+	})
+}
@@ -0,0 +1,24 @@
+package handler
+
+import (
+	"avenger/pkg/utils"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type JWKSHandler struct {
+	keys *utils.KeySet
+}
+
+func NewJWKSHandler(keys *utils.KeySet) *JWKSHandler {
+	return &JWKSHandler{keys: keys}
+}
+
+// Get publishes the public keys that verify our access tokens. The response
+// is a bare JWK Set rather than the usual envelope so standard JOSE
+// libraries can consume it.
+func (h *JWKSHandler) Get(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, h.keys.JWKS())
+}
@@ -1,35 +1,122 @@
 package handler
 
 import (
+	"avenger/internal/apperr"
 	"avenger/internal/domain"
 	"avenger/internal/service"
+	"avenger/pkg/utils"
 	"encoding/json"
 	"log/slog"
 	"net/http"
 	"strconv"
-	"strings"
 
-	"github.com/go-playground/validator/v10"
 	"github.com/julienschmidt/httprouter"
 )
 
 type RecipeHandler struct {
-	service  service.RecipeService
-	validate *validator.Validate
+	service service.RecipeService
 }
 
 func NewRecipeHandler(s service.RecipeService) *RecipeHandler {
-	return &RecipeHandler{service: s, validate: validator.New()}
+	return &RecipeHandler{service: s}
 }
 
 func (h *RecipeHandler) GetAll(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	data, err := h.service.GetAll()
+	q := r.URL.Query()
+	errs := map[string]string{}
+
+	query := domain.RecipeQuery{
+		MaxCookTime: parseIntParam(q, "max_cook_time", errs),
+		Search:      q.Get("q"),
+	}
+
+	if v := parseIntParam(q, "limit", errs); v != nil {
+		if *v <= 0 {
+			errs["limit"] = "limit must be a positive integer"
+		} else {
+			query.Limit = min(*v, service.MaxRecipeLimit)
+		}
+	}
+
+	if v := q.Get("min_rating"); v != "" {
+		rating, err := strconv.ParseFloat(v, 64)
+		if err != nil || rating < 0 || rating > 5 {
+			errs["min_rating"] = "min_rating must be a number between 0 and 5"
+		} else {
+			query.MinRating = &rating
+		}
+	}
+
+	if sort := parseSort(q.Get("sort"), domain.RecipeSortFields, errs); len(sort) > 1 {
+		errs["sort"] = "recipes can only be sorted by a single field"
+	} else if len(sort) == 1 {
+		query.Sort = sort[0]
+	}
+
+	if v := q.Get("cursor"); v != "" {
+		var cursor domain.RecipeCursor
+		if err := utils.DecodeCursor(v, &cursor); err != nil {
+			errs["cursor"] = "cursor is invalid"
+		} else {
+			query.Cursor = &cursor
+		}
+	}
+
+	if len(errs) > 0 {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidQuery, "Invalid query parameters", errs)
+		return
+	}
+
+	data, next, err := h.service.GetAll(r.Context(), query)
+	if err != nil {
+		logger(r).Error("GetAll recipes error", slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	meta := CursorMeta{Limit: query.Limit}
+	if meta.Limit == 0 {
+		meta.Limit = service.DefaultRecipeLimit
+	}
+	if next != nil {
+		cursor, err := utils.EncodeCursor(next)
+		if err != nil {
+			logger(r).Error("Failed to encode recipe cursor", slog.Any("error", err))
+			writeError(w, r, http.StatusInternalServerError, apperr.CodeInternal, "Failed to retrieve recipes", nil)
+			return
+		}
+		meta.NextCursor = cursor
+
+		nq := r.URL.Query()
+		nq.Set("cursor", cursor)
+		meta.Next = r.URL.Path + "?" + nq.Encode()
+	}
+
+	writeJSON(w, http.StatusOK, Response{
+		Message: "success",
+		Data:    data,
+		Meta:    meta,
+	})
+}
+
+func (h *RecipeHandler) GetByID(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	idStr := p.ByName("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil || id <= 0 {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidID, "Invalid ID parameter", map[string]string{
+			"id": "ID must be a positive integer",
+		})
+		return
+	}
+
+	data, err := h.service.GetByID(r.Context(), id)
 	if err != nil {
-		slog.Error("GetAll recipes error", slog.Any("error", err))
-		writeError(w, http.StatusInternalServerError, "Failed to retrieve recipes", nil)
+		logger(r).Error("GetByID recipe error", slog.Int("id", id), slog.Any("error", err))
+		writeAppError(w, r, err)
 		return
 	}
 
+	setETag(w, data.Version)
 	writeJSON(w, http.StatusOK, Response{
 		Message: "success",
 		Data:    data,
@@ -39,15 +126,15 @@ func (h *RecipeHandler) GetAll(w http.ResponseWriter, r *http.Request, p httprou
 func (h *RecipeHandler) Create(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	var rec domain.Recipe
 	if err := json.NewDecoder(r.Body).Decode(&rec); err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid request body", map[string]string{
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidBody, "Invalid request body", map[string]string{
 			"body": "Request body must be valid JSON",
 		})
 		return
 	}
 
-	if err := h.service.Create(&rec); err != nil {
-		slog.Error("Create recipe error", slog.Any("error", err))
-		writeError(w, http.StatusInternalServerError, "Failed to create recipe", nil)
+	if err := h.service.Create(r.Context(), &rec); err != nil {
+		logger(r).Error("Create recipe error", slog.Any("error", err))
+		writeAppError(w, r, err)
 		return
 	}
 
@@ -57,24 +144,80 @@ func (h *RecipeHandler) Create(w http.ResponseWriter, r *http.Request, p httprou
 	})
 }
 
+func (h *RecipeHandler) Patch(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	idStr := p.ByName("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil || id <= 0 {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidID, "Invalid ID parameter", map[string]string{
+			"id": "ID must be a positive integer",
+		})
+		return
+	}
+
+	expected, ok := ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+
+	current, err := h.service.GetByID(r.Context(), id)
+	if err != nil {
+		logger(r).Error("Patch recipe lookup error", slog.Int("id", id), slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	if expected != 0 && expected != current.Version {
+		writeVersionConflict(w, r, &apperr.VersionConflictError{Resource: "recipe", Current: current.Version})
+		return
+	}
+
+	doc, fields, ok := applyPatch(w, r, current)
+	if !ok {
+		return
+	}
+
+	var rec domain.Recipe
+	if err := json.Unmarshal(doc, &rec); err != nil {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidPatch, "Invalid patch", map[string]string{
+			"body": "Patched recipe has invalid field types",
+		})
+		return
+	}
+
+	// The service checks which fields may change and validates them.
+	rec.Version = current.Version
+
+	if err := h.service.Patch(r.Context(), id, &rec, fields); err != nil {
+		logger(r).Error("Patch recipe error", slog.Int("id", id), slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	setETag(w, rec.Version)
+	writeJSON(w, http.StatusOK, Response{
+		Message: "Recipe updated successfully",
+		Data:    rec,
+	})
+}
+
 func (h *RecipeHandler) Delete(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	idStr := p.ByName("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil || id <= 0 {
-		writeError(w, http.StatusBadRequest, "Invalid ID parameter", map[string]string{
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidID, "Invalid ID parameter", map[string]string{
 			"id": "ID must be a positive integer",
 		})
 		return
 	}
 
-	if err := h.service.Delete(id); err != nil {
-		slog.Error("Delete recipe error", slog.Int("id", id), slog.Any("error", err))
-		if strings.Contains(err.Error(), "not found") {
-			writeError(w, http.StatusNotFound, "Recipe not found", nil)
-			return
-		}
+	expected, ok := ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
 
-		writeError(w, http.StatusInternalServerError, "Failed to delete recipe", nil)
+	if err := h.service.Delete(r.Context(), id, expected); err != nil {
+		logger(r).Error("Delete recipe error", slog.Int("id", id), slog.Any("error", err))
+		writeAppError(w, r, err)
 		return
 	}
 
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"avenger/internal/apperr"
+	"avenger/internal/domain"
+	"avenger/internal/middleware"
+	"avenger/internal/service"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type RoleHandler struct {
+	service service.RoleService
+}
+
+func NewRoleHandler(s service.RoleService) *RoleHandler {
+	return &RoleHandler{service: s}
+}
+
+func (h *RoleHandler) GetAll(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	roles, err := h.service.GetAll(r.Context())
+	if err != nil {
+		logger(r).Error("GetAll roles error", slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, Response{
+		Message: "success",
+		Data:    roles,
+	})
+}
+
+// Patch changes whether members of the role must use two-factor
+// authentication.
+func (h *RoleHandler) Patch(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	var input domain.RoleTwoFactor
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidBody, "Invalid request body", map[string]string{
+			"body": "Request body must be valid JSON",
+		})
+		return
+	}
+	if input.RequireTwoFactor == nil {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeValidation, "Validation failed", map[string]string{
+			"require_two_factor": "require_two_factor is required",
+		})
+		return
+	}
+
+	name := p.ByName("name")
+	role, err := h.service.SetRequireTwoFactor(r.Context(), name, *input.RequireTwoFactor)
+	if err != nil {
+		logger(r).Error("Patch role error", slog.String("role", name), slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	logger(r).Info("Role updated", slog.String("role", name), slog.Bool("require_two_factor", role.RequireTwoFactor), slog.Int("by", claims.UserID))
+
+	writeJSON(w, http.StatusOK, Response{
+		Message: "Role updated successfully",
+		Data:    role,
+	})
+}
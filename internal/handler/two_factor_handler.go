@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"avenger/internal/apperr"
+	"avenger/internal/domain"
+	"avenger/internal/middleware"
+	"avenger/internal/service"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// TwoFactorHandler serves the authenticated user's own 2FA settings.
+type TwoFactorHandler struct {
+	service service.TwoFactorService
+}
+
+func NewTwoFactorHandler(s service.TwoFactorService) *TwoFactorHandler {
+	return &TwoFactorHandler{service: s}
+}
+
+// Enroll starts TOTP enrollment and returns the secret as an otpauth URI
+// and QR code.
+func (h *TwoFactorHandler) Enroll(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+
+	enrollment, err := h.service.Enroll(r.Context(), claims.UserID)
+	if err != nil {
+		logger(r).Error("Two-factor enrollment error", slog.Int("user_id", claims.UserID), slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, Response{
+		Message: "Scan the QR code, then confirm with a code from your authenticator",
+		Data:    enrollment,
+	})
+}
+
+// Confirm enables 2FA and returns the recovery codes.
+func (h *TwoFactorHandler) Confirm(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	input, ok := decodeTwoFactorCode(w, r)
+	if !ok {
+		return
+	}
+
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	codes, err := h.service.Confirm(r.Context(), claims.UserID, claims.SessionID, input.Code)
+	if err != nil {
+		logger(r).Warn("Two-factor confirmation failed", slog.Int("user_id", claims.UserID), slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	logger(r).Info("Two-factor authentication enabled", slog.Int("user_id", claims.UserID))
+
+	writeJSON(w, http.StatusOK, Response{
+		Message: "Two-factor authentication enabled, store the recovery codes somewhere safe",
+		Data: map[string]any{
+			"recovery_codes": codes,
+		},
+	})
+}
+
+func (h *TwoFactorHandler) Disable(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	input, ok := decodeTwoFactorCode(w, r)
+	if !ok {
+		return
+	}
+
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	if err := h.service.Disable(r.Context(), claims.UserID, input.Code); err != nil {
+		logger(r).Warn("Disabling two-factor failed", slog.Int("user_id", claims.UserID), slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	logger(r).Info("Two-factor authentication disabled", slog.Int("user_id", claims.UserID))
+
+	writeJSON(w, http.StatusOK, Response{
+		Message: "Two-factor authentication disabled",
+	})
+}
+
+func (h *TwoFactorHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	input, ok := decodeTwoFactorCode(w, r)
+	if !ok {
+		return
+	}
+
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	codes, err := h.service.RegenerateRecoveryCodes(r.Context(), claims.UserID, input.Code)
+	if err != nil {
+		logger(r).Warn("Regenerating recovery codes failed", slog.Int("user_id", claims.UserID), slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, Response{
+		Message: "Recovery codes regenerated, the previous ones no longer work",
+		Data: map[string]any{
+			"recovery_codes": codes,
+		},
+	})
+}
+
+func decodeTwoFactorCode(w http.ResponseWriter, r *http.Request) (domain.TwoFactorCode, bool) {
+	var input domain.TwoFactorCode
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidBody, "Invalid request body", map[string]string{
+			"body": "Request body must be valid JSON",
+		})
+		return input, false
+	}
+	if input.Code == "" {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeValidation, "Validation failed", map[string]string{
+			"code": "code is required",
+		})
+		return input, false
+	}
+	return input, true
+}
@@ -0,0 +1,272 @@
+package handler
+
+import (
+	"avenger/internal/apperr"
+	"avenger/internal/domain"
+	"avenger/internal/middleware"
+	"avenger/internal/service"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type UserHandler struct {
+	service service.UserService
+	guard   service.LoginGuard
+}
+
+func NewUserHandler(s service.UserService, guard service.LoginGuard) *UserHandler {
+	return &UserHandler{service: s, guard: guard}
+}
+
+func (h *UserHandler) GetAll(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	q := r.URL.Query()
+	errs := map[string]string{}
+
+	page, perPage := parsePagination(q, service.DefaultUserPerPage, service.MaxUserPerPage, errs)
+	query := domain.UserQuery{
+		Page:    page,
+		PerPage: perPage,
+		Search:  q.Get("q"),
+		Role:    q.Get("role"),
+		Sort:    parseSort(q.Get("sort"), domain.UserSortFields, errs),
+	}
+
+	if v := q.Get("disabled"); v != "" {
+		disabled, err := strconv.ParseBool(v)
+		if err != nil {
+			errs["disabled"] = "disabled must be true or false"
+		} else {
+			query.Disabled = &disabled
+		}
+	}
+
+	if len(errs) > 0 {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidQuery, "Invalid query parameters", errs)
+		return
+	}
+
+	users, total, err := h.service.GetAll(r.Context(), query)
+	if err != nil {
+		logger(r).Error("GetAll users error", slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, Response{
+		Message: "success",
+		Data:    users,
+		Meta:    newPageMeta(r, page, perPage, total),
+	})
+}
+
+func (h *UserHandler) GetByID(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id, err := strconv.Atoi(p.ByName("id"))
+	if err != nil || id <= 0 {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidID, "Invalid ID parameter", map[string]string{
+			"id": "ID must be a positive integer",
+		})
+		return
+	}
+
+	user, err := h.service.GetByID(r.Context(), id)
+	if err != nil {
+		logger(r).Error("GetByID user error", slog.Int("id", id), slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, Response{
+		Message: "success",
+		Data:    user,
+	})
+}
+
+// Patch changes a user's profile, role or disabled state with a JSON Merge
+// Patch or JSON Patch document.
+func (h *UserHandler) Patch(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id, err := strconv.Atoi(p.ByName("id"))
+	if err != nil || id <= 0 {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidID, "Invalid ID parameter", map[string]string{
+			"id": "ID must be a positive integer",
+		})
+		return
+	}
+
+	current, err := h.service.GetByID(r.Context(), id)
+	if err != nil {
+		logger(r).Error("Patch user lookup error", slog.Int("id", id), slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	doc, fields, ok := applyPatch(w, r, current)
+	if !ok {
+		return
+	}
+
+	var user domain.User
+	if err := json.Unmarshal(doc, &user); err != nil {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidPatch, "Invalid patch", map[string]string{
+			"body": "Patched user has invalid field types",
+		})
+		return
+	}
+
+	// The service checks which fields may change and validates them.
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	if err := h.service.Patch(r.Context(), claims.UserID, id, &user, fields); err != nil {
+		logger(r).Error("Patch user error", slog.Int("id", id), slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	logger(r).Info("User updated", slog.Int("id", id), slog.Int("by", claims.UserID), slog.Any("fields", fields))
+
+	writeJSON(w, http.StatusOK, Response{
+		Message: "User updated successfully",
+		Data:    user,
+	})
+}
+
+func (h *UserHandler) Delete(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id, err := strconv.Atoi(p.ByName("id"))
+	if err != nil || id <= 0 {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidID, "Invalid ID parameter", map[string]string{
+			"id": "ID must be a positive integer",
+		})
+		return
+	}
+
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	if err := h.service.Delete(r.Context(), claims.UserID, id); err != nil {
+		logger(r).Error("Delete user error", slog.Int("id", id), slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	logger(r).Info("User deleted", slog.Int("id", id), slog.Int("by", claims.UserID))
+
+	writeJSON(w, http.StatusOK, Response{
+		Message: "User deleted successfully",
+		Data: map[string]any{
+			"id": id,
+		},
+	})
+}
+
+// Unlock clears the failed login counter and lockout of a user's account.
+func (h *UserHandler) Unlock(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id, err := strconv.Atoi(p.ByName("id"))
+	if err != nil || id <= 0 {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidID, "Invalid ID parameter", map[string]string{
+			"id": "ID must be a positive integer",
+		})
+		return
+	}
+
+	if err := h.guard.Unlock(r.Context(), id); err != nil {
+		logger(r).Error("Unlock user error", slog.Int("id", id), slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	logger(r).Info("User login unlocked", slog.Int("id", id), slog.Int("by", claims.UserID))
+
+	writeJSON(w, http.StatusOK, Response{
+		Message: "User unlocked successfully",
+		Data: map[string]any{
+			"id": id,
+		},
+	})
+}
+
+// GetMe returns the authenticated user's own record.
+func (h *UserHandler) GetMe(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+
+	user, err := h.service.GetByID(r.Context(), claims.UserID)
+	if err != nil {
+		logger(r).Error("GetMe error", slog.Int("user_id", claims.UserID), slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, Response{
+		Message: "success",
+		Data:    user,
+	})
+}
+
+// PatchMe lets users change their own full name, age and occupation.
+func (h *UserHandler) PatchMe(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+
+	current, err := h.service.GetByID(r.Context(), claims.UserID)
+	if err != nil {
+		logger(r).Error("PatchMe lookup error", slog.Int("user_id", claims.UserID), slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	doc, fields, ok := applyPatch(w, r, current)
+	if !ok {
+		return
+	}
+
+	var user domain.User
+	if err := json.Unmarshal(doc, &user); err != nil {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidPatch, "Invalid patch", map[string]string{
+			"body": "Patched profile has invalid field types",
+		})
+		return
+	}
+
+	if err := h.service.UpdateProfile(r.Context(), claims.UserID, &user, fields); err != nil {
+		logger(r).Warn("PatchMe error", slog.Int("user_id", claims.UserID), slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, Response{
+		Message: "Profile updated successfully",
+		Data:    user,
+	})
+}
+
+// ChangePassword sets a new password after verifying the current one. All
+// other sessions of the user are logged out.
+func (h *UserHandler) ChangePassword(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+
+	var input domain.PasswordChange
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeInvalidBody, "Invalid request body", map[string]string{
+			"body": "Request body must be valid JSON",
+		})
+		return
+	}
+
+	if input.CurrentPassword == "" || input.NewPassword == "" {
+		writeError(w, r, http.StatusBadRequest, apperr.CodeValidation, "Validation failed", map[string]string{
+			"password": "current_password and new_password are required",
+		})
+		return
+	}
+
+	if err := h.service.ChangePassword(r.Context(), claims.UserID, claims.SessionID, input); err != nil {
+		logger(r).Warn("Change password failed", slog.Int("user_id", claims.UserID), slog.Any("error", err))
+		writeAppError(w, r, err)
+		return
+	}
+
+	logger(r).Info("Password changed", slog.Int("user_id", claims.UserID))
+
+	writeJSON(w, http.StatusOK, Response{
+		Message: "Password changed successfully",
+	})
+}
@@ -0,0 +1,161 @@
+// Package health runs the dependency checks behind the readiness probe.
+package health
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Check probes one dependency. Run returns an error safe to show to the
+// probe when the dependency is unusable.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Statuses reported for the whole service and for each check.
+const (
+	StatusOK           = "ok"
+	StatusFailed       = "failed"
+	StatusReady        = "ready"
+	StatusNotReady     = "not_ready"
+	StatusShuttingDown = "shutting_down"
+)
+
+// CheckResult is the outcome of one check.
+type CheckResult struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// Report is the readiness of the service and the result of every check.
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// Ready reports whether the service should receive traffic.
+func (r Report) Ready() bool {
+	return r.Status == StatusReady
+}
+
+// reportTTL is how long a report is reused. The probe is public, so a
+// flood of requests must not turn into a flood of database queries.
+const reportTTL = time.Second
+
+// Checker runs the checks in parallel, each bounded by a timeout, and turns
+// not ready for good once draining starts.
+type Checker struct {
+	checks   []Check
+	timeout  time.Duration
+	draining atomic.Bool
+
+	mu       sync.Mutex // held while the checks run
+	last     Report
+	lastTime time.Time
+}
+
+func NewChecker(timeout time.Duration, checks ...Check) *Checker {
+	return &Checker{checks: checks, timeout: timeout}
+}
+
+// Drain makes every later readiness report fail, so load balancers stop
+// sending traffic before the server shuts down.
+func (c *Checker) Drain() {
+	c.draining.Store(true)
+}
+
+// Ready runs the checks, or returns the report of a run finished less than
+// reportTTL ago. Concurrent callers wait for a single run. While draining
+// none are run.
+func (c *Checker) Ready(ctx context.Context) Report {
+	if c.draining.Load() {
+		return Report{Status: StatusShuttingDown}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.lastTime.IsZero() && time.Since(c.lastTime) < reportTTL {
+		return c.last
+	}
+	// Others may reuse the report, so one caller leaving must not fail it.
+	c.last, c.lastTime = c.check(context.WithoutCancel(ctx)), time.Now()
+	return c.last
+}
+
+func (c *Checker) check(ctx context.Context) Report {
+	report := Report{Status: StatusReady, Checks: make(map[string]CheckResult, len(c.checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range c.checks {
+		wg.Go(func() {
+			result := c.run(ctx, check)
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[check.Name] = result
+			if result.Status != StatusOK {
+				report.Status = StatusNotReady
+			}
+		})
+	}
+	wg.Wait()
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, check Check) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check.Run(ctx)
+	result := CheckResult{Status: StatusOK, Duration: time.Since(start).Round(time.Microsecond).String()}
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %s", c.timeout)
+		}
+		result.Status, result.Error = StatusFailed, err.Error()
+	}
+	return result
+}
+
+// Ping checks that db accepts connections. The driver error is logged
+// rather than reported, as it may name hosts and users.
+func Ping(name string, db *sql.DB) Check {
+	return Check{Name: name, Run: func(ctx context.Context) error {
+		if err := db.PingContext(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			slog.ErrorContext(ctx, "Readiness ping failed", slog.String("check", name), slog.Any("error", err))
+			return errors.New("database unreachable")
+		}
+		return nil
+	}}
+}
+
+// Migrations checks that the schema_migrations table of db records at least
+// version expected. A newer schema is accepted, as during a rolling deploy
+// the previous release still runs against it.
+func Migrations(db *sql.DB, expected int) Check {
+	return Check{Name: "migrations", Run: func(ctx context.Context) error {
+		var version int
+		if err := db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			slog.ErrorContext(ctx, "Readiness migration check failed", slog.Any("error", err))
+			return errors.New("cannot read schema_migrations, run the migrations")
+		}
+		if version < expected {
+			return fmt.Errorf("schema at version %d, expected %d", version, expected)
+		}
+		return nil
+	}}
+}
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"avenger/internal/domain"
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// InventoryStatsSource reports the current inventory counts.
+type InventoryStatsSource interface {
+	Stats(ctx context.Context) (*domain.InventoryStats, error)
+}
+
+// statsTimeout bounds the database query behind one scrape.
+const statsTimeout = 5 * time.Second
+
+// inventoryCollector reads the inventory gauges from the database on every
+// scrape, so they are exact without hooking every write.
+type inventoryCollector struct {
+	source     InventoryStatsSource
+	byStatus   *prometheus.Desc
+	outOfStock *prometheus.Desc
+}
+
+// RegisterInventory exposes inventory counts by status and the number of
+// items at zero stock, read from source at scrape time.
+func RegisterInventory(source InventoryStatsSource) {
+	Registry.MustRegister(&inventoryCollector{
+		source: source,
+		byStatus: prometheus.NewDesc(prometheus.BuildFQName(namespace, "inventory", "items"),
+			"Inventory items by status.", []string{"status"}, nil),
+		outOfStock: prometheus.NewDesc(prometheus.BuildFQName(namespace, "inventory", "items_out_of_stock"),
+			"Inventory items at zero stock.", nil, nil),
+	})
+}
+
+func (c *inventoryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.byStatus
+	ch <- c.outOfStock
+}
+
+// Collect skips the gauges when the database cannot be read rather than
+// failing the whole scrape.
+func (c *inventoryCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), statsTimeout)
+	defer cancel()
+
+	stats, err := c.source.Stats(ctx)
+	if err != nil {
+		slog.Error("Failed to collect inventory metrics", slog.Any("error", err))
+		return
+	}
+
+	// Known statuses are always reported, so a gauge drops to zero instead
+	// of disappearing.
+	for _, status := range domain.InventoryStatuses {
+		if _, ok := stats.ByStatus[status]; !ok {
+			stats.ByStatus[status] = 0
+		}
+	}
+	for status, count := range stats.ByStatus {
+		ch <- prometheus.MustNewConstMetric(c.byStatus, prometheus.GaugeValue, float64(count), status)
+	}
+	ch <- prometheus.MustNewConstMetric(c.outOfStock, prometheus.GaugeValue, float64(stats.OutOfStock))
+}
@@ -0,0 +1,102 @@
+// Package metrics defines the Prometheus metrics of the service and the
+// registry they are exposed from. The registry is scraped over HTTP by
+// Handler, so it needs no Prometheus server to be read, in tests included.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "avenger"
+
+// Registry holds every metric of the service, plus the Go runtime and
+// process collectors.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// HTTPRequests counts finished requests by method, route template and
+	// status code.
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route template and status code.",
+	}, []string{"method", "route", "status"})
+
+	// HTTPDuration observes request latency by method, route template and
+	// status code.
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method, route template and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	// HTTPCanceled counts requests whose work was cut short, by reason:
+	// "timeout" when the route's timeout passed, "client" when the client
+	// disconnected.
+	HTTPCanceled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_canceled_total",
+		Help:      "HTTP requests canceled by route timeout or client disconnect.",
+	}, []string{"method", "route", "reason"})
+
+	// HTTPInFlight is the number of requests being served.
+	HTTPInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "http_requests_in_flight",
+		Help:      "HTTP requests currently being served.",
+	})
+
+	// Logins counts password logins by result ("success" or "failure") and
+	// failure reason, one of the domain.LoginFailed* values.
+	Logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "auth",
+		Name:      "logins_total",
+		Help:      "Login attempts by result and failure reason.",
+	}, []string{"result", "reason"})
+
+	// Authentications counts credentials presented to protected routes by
+	// method ("bearer" or "api_key") and result.
+	Authentications = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "auth",
+		Name:      "authentications_total",
+		Help:      "Bearer token and API key authentications by result.",
+	}, []string{"method", "result"})
+)
+
+// Results used as the "result" label.
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPDuration,
+		HTTPCanceled,
+		HTTPInFlight,
+		Logins,
+		Authentications,
+	)
+}
+
+// RegisterDB exposes the connection pool statistics of db, labelled
+// db_name=name.
+func RegisterDB(name string, db *sql.DB) {
+	Registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// Handler serves the registry in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
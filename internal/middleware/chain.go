@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"avenger/internal/apperr"
+	"avenger/internal/problem"
+	"avenger/pkg/reqctx"
+	"crypto/rand"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// Middleware wraps an http.Handler with behaviour that runs around it.
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps h with mws. The first middleware is the outermost one, so it
+// sees the request first and the response last.
+func Chain(h http.Handler, mws ...Middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// RequestIDHeader carries the request ID in both directions.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the length of client supplied request IDs.
+const maxRequestIDLength = 128
+
+// RequestID assigns every request an ID, reusing a well-formed X-Request-ID
+// from the client or proxy and generating one otherwise. The ID is echoed
+// in the response, stored in the context and attached to the request
+// scoped logger, so every log line of the request carries it.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = rand.Text()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		ctx := reqctx.WithRequestID(r.Context(), id)
+		ctx = reqctx.WithLogger(ctx, reqctx.Logger(ctx).With(slog.String("request_id", id)))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// validRequestID accepts IDs of printable ASCII without spaces, which keeps
+// log lines and response headers intact.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// RealIP stores the client address resolved by res in the context and adds
+// it to the request scoped logger.
+func (res *IPResolver) RealIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := res.ClientIP(r)
+		ctx := reqctx.WithClientIP(r.Context(), ip)
+		ctx = reqctx.WithLogger(ctx, reqctx.Logger(ctx).With(slog.String("client_ip", ip)))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// AccessLog logs one line per request with its status, response size and
+// duration once the handler has finished.
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := newStatusRecorder(w)
+
+		next.ServeHTTP(rec, r)
+
+		level := slog.LevelInfo
+		if rec.Status() >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		reqctx.Logger(r.Context()).LogAttrs(r.Context(), level, "HTTP request completed",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.Status()),
+			slog.Int64("bytes", rec.Bytes()),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("user_agent", r.UserAgent()),
+		)
+	})
+}
+
+// Recover turns a panic in next into a 500 response and logs it with the
+// stack. http.ErrAbortHandler is re-raised, as net/http expects.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := newStatusRecorder(w)
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+
+			reqctx.Logger(r.Context()).Error("PANIC occurred",
+				slog.Any("error", err),
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.String("stack", string(debug.Stack())),
+			)
+			// Too late for an error response once the handler has written.
+			if !rec.Written() {
+				problem.Write(rec, r, http.StatusInternalServerError, apperr.CodeInternal, "Internal server error", nil)
+			}
+		}()
+
+		next.ServeHTTP(rec, r)
+	})
+}
@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"avenger/internal/metrics"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Metrics records the count, latency and in-flight number of requests,
+// labelled by the route template registered with Route rather than the raw
+// path.
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := newStatusRecorder(w)
+		ctx, matched := withRoute(r.Context())
+
+		metrics.HTTPInFlight.Inc()
+		defer metrics.HTTPInFlight.Dec()
+
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		status := strconv.Itoa(rec.Status())
+		metrics.HTTPRequests.WithLabelValues(r.Method, matched.pattern, status).Inc()
+		metrics.HTTPDuration.WithLabelValues(r.Method, matched.pattern, status).Observe(time.Since(start).Seconds())
+	})
+}
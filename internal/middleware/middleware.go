@@ -1,84 +1,174 @@
 package middleware
 
 import (
+	"avenger/internal/apperr"
+	"avenger/internal/metrics"
+	"avenger/internal/problem"
+	"avenger/pkg/reqctx"
 	"avenger/pkg/utils"
-	"encoding/json"
-	"log"
+	"context"
+	"errors"
 	"log/slog"
 	"net/http"
 	"strings"
-	"time"
 )
 
-func LoggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
+type contextKey string
 
-		log.Printf("[%s] %s %s from %s", time.Now().Format("2006/01/02 15:04:05"), r.Method, r.URL.Path, r.RemoteAddr)
+const claimsKey contextKey = "claims"
 
-		next.ServeHTTP(w, r)
+// ClaimsFromContext returns the JWT claims stored by AuthMiddleware.
+func ClaimsFromContext(ctx context.Context) (*utils.JWTClaim, bool) {
+	claims, ok := ctx.Value(claimsKey).(*utils.JWTClaim)
+	return claims, ok
+}
 
-		duration := time.Since(start)
-		slog.Info("HTTP request completed", slog.String("method", r.Method), slog.String("path", r.URL.Path), slog.Duration("duration", duration))
-	})
+// SessionValidator reports whether the login session an access token
+// belongs to is still active.
+type SessionValidator interface {
+	Validate(ctx context.Context, sessionID string) error
+}
+
+// APIKeyAuthenticator resolves an API key to the claims it grants.
+type APIKeyAuthenticator interface {
+	Authenticate(ctx context.Context, key string) (*utils.JWTClaim, error)
+}
+
+// Authenticator builds middleware that authenticates requests with a bearer
+// access token whose session has not been revoked, or with an API key.
+type Authenticator struct {
+	sessions SessionValidator
+	apiKeys  APIKeyAuthenticator
+}
+
+func NewAuthenticator(sessions SessionValidator, apiKeys APIKeyAuthenticator) *Authenticator {
+	return &Authenticator{sessions: sessions, apiKeys: apiKeys}
 }
 
-func AuthMiddleware(next http.HandlerFunc, allowedRoles ...string) http.HandlerFunc {
+// AuthMiddleware rejects requests without a valid access token or API key
+// and stores the resulting claims in the request context. API keys are read
+// from X-API-Key or "Authorization: ApiKey <key>".
+func (a *Authenticator) AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 
+		if key := apiKey(r); key != "" {
+			a.authenticateAPIKey(w, r, key, next)
+			return
+		}
+
 		if authHeader == "" {
-			slog.Warn("Missing authorization header", slog.String("path", r.URL.Path))
-			writeAuthError(w, http.StatusUnauthorized, "Missing authorization token")
+			reqctx.Logger(r.Context()).Warn("Missing authorization header", slog.String("path", r.URL.Path))
+			writeAuthError(w, r, http.StatusUnauthorized, apperr.CodeMissingToken, "Missing authorization token")
 			return
 		}
 
 		if !strings.HasPrefix(authHeader, "Bearer ") {
-			slog.Warn("Invalid Authorization format", slog.String("path", r.URL.Path))
-			writeAuthError(w, http.StatusUnauthorized, "Invalid authorization format. Use: Bearer <token>")
+			reqctx.Logger(r.Context()).Warn("Invalid Authorization format", slog.String("path", r.URL.Path))
+			writeAuthError(w, r, http.StatusUnauthorized, apperr.CodeInvalidAuthScheme, "Invalid authorization format. Use: Bearer <token> or ApiKey <key>")
+			return
 		}
 
 		token := strings.TrimPrefix(authHeader, "Bearer ")
 		if token == "" {
-			slog.Warn("Empty token provided", slog.String("path", r.URL.Path))
-			writeAuthError(w, http.StatusUnauthorized, "Empty authorization token")
+			reqctx.Logger(r.Context()).Warn("Empty token provided", slog.String("path", r.URL.Path))
+			writeAuthError(w, r, http.StatusUnauthorized, apperr.CodeMissingToken, "Empty authorization token")
 			return
 		}
 
 		claims, err := utils.ValidateToken(token)
 		if err != nil {
-			slog.Warn("Invalid token", slog.String("path", r.URL.Path), slog.Any("error", err))
-			writeAuthError(w, http.StatusUnauthorized, "Invalid or expired token")
+			reqctx.Logger(r.Context()).Warn("Invalid token", slog.String("path", r.URL.Path), slog.Any("error", err))
+			metrics.Authentications.WithLabelValues("bearer", metrics.ResultFailure).Inc()
+			writeAuthError(w, r, http.StatusUnauthorized, apperr.CodeInvalidToken, "Invalid or expired token")
 			return
 		}
 
-		if len(allowedRoles) > 0 {
-			roleAllowed := false
-			for _, role := range allowedRoles {
-				if claims.Role == role {
-					roleAllowed = true
-					break
-				}
-			}
-
-			if !roleAllowed {
-				slog.Warn("Access forbidden", slog.String("path", r.URL.Path), slog.String("user_role", claims.Role), slog.Any("allowed_roles", allowedRoles))
-				writeAuthError(w, http.StatusForbidden, "You dont have permission to access this resource")
+		if err := a.sessions.Validate(r.Context(), claims.SessionID); err != nil {
+			var appErr *apperr.Error
+			if !errors.As(err, &appErr) || appErr.Kind != apperr.KindUnauthorized {
+				reqctx.Logger(r.Context()).Error("Failed to validate session", slog.String("session_id", claims.SessionID), slog.Any("error", err))
+				problem.Write(w, r, http.StatusInternalServerError, apperr.CodeInternal, "Internal server error", nil)
 				return
 			}
+			reqctx.Logger(r.Context()).Warn("Token for inactive session", slog.String("path", r.URL.Path), slog.String("session_id", claims.SessionID))
+			metrics.Authentications.WithLabelValues("bearer", metrics.ResultFailure).Inc()
+			writeAuthError(w, r, http.StatusUnauthorized, appErr.ErrorCode(), appErr.Message)
+			return
+		}
 
-			slog.Debug("Authentication successful", slog.Int("user_id", claims.UserID), slog.String("role", claims.Role), slog.String("path", r.URL.Path))
+		reqctx.Logger(r.Context()).Debug("Authentication successful", slog.Int("user_id", claims.UserID), slog.String("role", claims.Role), slog.String("path", r.URL.Path))
 
-			next(w, r)
+		metrics.Authentications.WithLabelValues("bearer", metrics.ResultSuccess).Inc()
+		next(w, r.WithContext(context.WithValue(r.Context(), claimsKey, claims)))
+	}
+}
+
+func (a *Authenticator) authenticateAPIKey(w http.ResponseWriter, r *http.Request, key string, next http.HandlerFunc) {
+	claims, err := a.apiKeys.Authenticate(r.Context(), key)
+	if err != nil {
+		var appErr *apperr.Error
+		if !errors.As(err, &appErr) || appErr.Kind != apperr.KindUnauthorized {
+			reqctx.Logger(r.Context()).Error("Failed to authenticate API key", slog.Any("error", err))
+			problem.Write(w, r, http.StatusInternalServerError, apperr.CodeInternal, "Internal server error", nil)
+			return
 		}
+		reqctx.Logger(r.Context()).Warn("Invalid API key", slog.String("path", r.URL.Path))
+		metrics.Authentications.WithLabelValues("api_key", metrics.ResultFailure).Inc()
+		writeAuthError(w, r, http.StatusUnauthorized, appErr.ErrorCode(), appErr.Message)
+		return
+	}
+
+	reqctx.Logger(r.Context()).Debug("API key authentication successful", slog.Uint64("api_key_id", uint64(claims.APIKeyID)), slog.String("path", r.URL.Path))
+
+	metrics.Authentications.WithLabelValues("api_key", metrics.ResultSuccess).Inc()
+	next(w, r.WithContext(context.WithValue(r.Context(), claimsKey, claims)))
+}
+
+// apiKey returns the API key presented with r, if any.
+func apiKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "ApiKey "); ok {
+		return strings.TrimSpace(key)
 	}
+	return ""
 }
 
-func writeAuthError(w http.ResponseWriter, status int, message string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(map[string]any{
-		"message": message,
-		"errors":  nil,
+// RequireUser is AuthMiddleware for routes that act on the logged in user
+// themselves, such as /me, and therefore refuse API keys.
+func (a *Authenticator) RequireUser(next http.HandlerFunc) http.HandlerFunc {
+	return a.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := ClaimsFromContext(r.Context())
+		if claims.IsAPIKey() {
+			reqctx.Logger(r.Context()).Warn("API key used on a user-only route", slog.String("path", r.URL.Path), slog.Uint64("api_key_id", uint64(claims.APIKeyID)))
+			writeAuthError(w, r, http.StatusForbidden, apperr.CodeAPIKeyNotAllowed, "This endpoint requires a user login, not an API key")
+			return
+		}
+		next(w, r)
 	})
 }
+
+// Authorize returns middleware that authenticates the request and then
+// requires the access token to grant perm.
+func (a *Authenticator) Authorize(perm string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return a.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+			claims, _ := ClaimsFromContext(r.Context())
+			if !claims.HasPermission(perm) {
+				reqctx.Logger(r.Context()).Warn("Access forbidden", slog.String("path", r.URL.Path), slog.Int("user_id", claims.UserID), slog.Uint64("api_key_id", uint64(claims.APIKeyID)), slog.String("role", claims.Role), slog.String("permission", perm))
+				writeAuthError(w, r, http.StatusForbidden, apperr.CodeForbidden, "You dont have permission to access this resource")
+				return
+			}
+			next(w, r)
+		})
+	}
+}
+
+func writeAuthError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="avenger"`)
+	}
+	problem.Write(w, r, status, code, message, nil)
+}
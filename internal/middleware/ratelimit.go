@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"avenger/internal/apperr"
+	"avenger/internal/problem"
+	"avenger/internal/repository"
+	"avenger/pkg/reqctx"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RateLimitPolicy allows Limit requests per Period for each client, all of
+// which may arrive at once. Name separates the buckets of different
+// policies.
+type RateLimitPolicy struct {
+	Name   string
+	Limit  int
+	Period time.Duration
+}
+
+func (p RateLimitPolicy) String() string {
+	return fmt.Sprintf("%d;w=%d", p.Limit, int(p.Period.Seconds()))
+}
+
+// RateLimiter throttles clients with GCRA, the token bucket expressed as a
+// single theoretical arrival time per client.
+type RateLimiter struct {
+	store repository.RateLimitStore
+	ips   *IPResolver
+	now   func() time.Time
+}
+
+func NewRateLimiter(store repository.RateLimitStore, ips *IPResolver) *RateLimiter {
+	return &RateLimiter{store: store, ips: ips, now: time.Now}
+}
+
+// Limit returns middleware enforcing policy. Requests are counted per API
+// key or user when an authentication middleware ran first, per client IP
+// otherwise. Every response carries RateLimit-* headers; refused ones get
+// 429 and Retry-After. A failing store lets requests through.
+func (l *RateLimiter) Limit(policy RateLimitPolicy) func(http.HandlerFunc) http.HandlerFunc {
+	return l.limit(policy, l.identity)
+}
+
+// LimitIP is Limit counting every request per client IP. Placed before
+// authentication it also throttles requests with invalid credentials, which
+// never reach a per-user limit.
+func (l *RateLimiter) LimitIP(policy RateLimitPolicy) func(http.HandlerFunc) http.HandlerFunc {
+	return l.limit(policy, l.ip)
+}
+
+func (l *RateLimiter) limit(policy RateLimitPolicy, identity func(*http.Request) string) func(http.HandlerFunc) http.HandlerFunc {
+	increment := policy.Period / time.Duration(policy.Limit)
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			now := l.now()
+			key := policy.Name + ":" + identity(r)
+
+			tat, allowed, err := l.store.Take(r.Context(), key, now, increment, policy.Period)
+			if err != nil {
+				reqctx.Logger(r.Context()).Error("Rate limit store failed, allowing request", slog.String("key", key), slog.Any("error", err))
+				next(w, r)
+				return
+			}
+
+			h := w.Header()
+			h.Set("RateLimit-Policy", policy.String())
+			h.Set("RateLimit-Limit", strconv.Itoa(policy.Limit))
+			h.Set("RateLimit-Reset", seconds(tat.Sub(now)))
+
+			if !allowed {
+				retryAfter := tat.Add(increment).Add(-policy.Period).Sub(now)
+				h.Set("RateLimit-Remaining", "0")
+				h.Set("Retry-After", seconds(retryAfter))
+				reqctx.Logger(r.Context()).Warn("Rate limit exceeded", slog.String("policy", policy.Name), slog.String("key", key), slog.String("path", r.URL.Path))
+				problem.Write(w, r, http.StatusTooManyRequests, apperr.CodeRateLimited, "Too many requests, slow down", nil)
+				return
+			}
+
+			remaining := int((policy.Period - tat.Sub(now)) / increment)
+			h.Set("RateLimit-Remaining", strconv.Itoa(max(remaining, 0)))
+			next(w, r)
+		}
+	}
+}
+
+func (l *RateLimiter) identity(r *http.Request) string {
+	if claims, ok := ClaimsFromContext(r.Context()); ok {
+		if claims.IsAPIKey() {
+			return "key:" + strconv.FormatUint(uint64(claims.APIKeyID), 10)
+		}
+		return "user:" + strconv.Itoa(claims.UserID)
+	}
+	return l.ip(r)
+}
+
+func (l *RateLimiter) ip(r *http.Request) string {
+	if ip := reqctx.ClientIP(r.Context()); ip != "" {
+		return "ip:" + ip
+	}
+	return "ip:" + l.ips.ClientIP(r)
+}
+
+// seconds rounds d up to whole seconds, never below zero.
+func seconds(d time.Duration) string {
+	if d <= 0 {
+		return "0"
+	}
+	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
+}
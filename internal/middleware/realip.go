@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// IPResolver finds the client address of a request. Forwarding headers are
+// only believed when the request comes from a trusted proxy, otherwise any
+// client could pick its own address.
+type IPResolver struct {
+	trusted []*net.IPNet
+}
+
+// NewIPResolver trusts the proxies in cidrs; plain addresses are accepted
+// as single-host networks.
+func NewIPResolver(cidrs []string) (*IPResolver, error) {
+	res := &IPResolver{}
+	for _, c := range cidrs {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		if !strings.Contains(c, "/") {
+			if ip := net.ParseIP(c); ip != nil && ip.To4() != nil {
+				c += "/32"
+			} else {
+				c += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", c, err)
+		}
+		res.trusted = append(res.trusted, network)
+	}
+	return res, nil
+}
+
+// ClientIP returns the address of the client that sent r. Behind trusted
+// proxies it is the right-most X-Forwarded-For entry that is not itself a
+// trusted proxy, falling back to X-Real-IP.
+func (res *IPResolver) ClientIP(r *http.Request) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	if !res.isTrusted(remote) {
+		return remote
+	}
+
+	// Proxies may append their own header line rather than extend the
+	// client's, so every line counts, in order.
+	if xff := strings.Join(r.Header.Values("X-Forwarded-For"), ","); xff != "" {
+		hops := strings.Split(xff, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+			if !res.isTrusted(hop) {
+				return hop
+			}
+		}
+	}
+	if real := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(real) != nil {
+		return real
+	}
+	return remote
+}
+
+func (res *IPResolver) isTrusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range res.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
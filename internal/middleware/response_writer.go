@@ -0,0 +1,59 @@
+package middleware
+
+import "net/http"
+
+// statusRecorder wraps an http.ResponseWriter to capture the status code
+// and the number of body bytes written. Unwrap lets http.ResponseController
+// reach the underlying writer for flushing and deadlines.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// newStatusRecorder wraps w, reusing it when it already is a recorder so
+// stacked middleware share one.
+func newStatusRecorder(w http.ResponseWriter) *statusRecorder {
+	if rec, ok := w.(*statusRecorder); ok {
+		return rec
+	}
+	return &statusRecorder{ResponseWriter: w}
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// Status returns the status sent to the client, 200 when the handler wrote
+// nothing.
+func (rec *statusRecorder) Status() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+	return rec.status
+}
+
+func (rec *statusRecorder) Bytes() int64 {
+	return rec.bytes
+}
+
+// Written reports whether the response header has been sent.
+func (rec *statusRecorder) Written() bool {
+	return rec.status != 0
+}
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// unmatchedRoute labels requests no route matched, so scanners probing
+// random paths cannot blow up the number of series.
+const unmatchedRoute = "unmatched"
+
+type routeKey struct{}
+
+// route is filled in by Route once the router has picked a handler; Metrics
+// reads it after the request to label its series.
+type route struct {
+	pattern string
+}
+
+// Route marks requests served by h as belonging to the route template
+// pattern, such as "/inventories/:id". The template labels the request
+// metrics and names the request span.
+func Route(pattern string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if matched, ok := r.Context().Value(routeKey{}).(*route); ok {
+			matched.pattern = pattern
+		}
+		span := trace.SpanFromContext(r.Context())
+		span.SetName(r.Method + " " + pattern)
+		span.SetAttributes(semconv.HTTPRoute(pattern))
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// withRoute prepares ctx to receive the route template picked by Route.
+func withRoute(ctx context.Context) (context.Context, *route) {
+	matched := &route{pattern: unmatchedRoute}
+	return context.WithValue(ctx, routeKey{}, matched), matched
+}
+
+// routePattern returns the route template of the request ctx belongs to.
+func routePattern(ctx context.Context) string {
+	if matched, ok := ctx.Value(routeKey{}).(*route); ok {
+		return matched.pattern
+	}
+	return unmatchedRoute
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"avenger/internal/metrics"
+	"avenger/pkg/reqctx"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Reasons a request is counted as canceled.
+const (
+	CancelTimeout = "timeout"
+	CancelClient  = "client"
+)
+
+// Timeout bounds the context of requests served by next to d, so the
+// database queries they run are canceled once it passes, as they are when
+// the client disconnects. Either way the request is logged and counted in
+// metrics.HTTPCanceled; the handler reports the failure to the client.
+func Timeout(d time.Duration) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+
+			var reason string
+			switch {
+			case r.Context().Err() != nil:
+				reason = CancelClient
+			case errors.Is(ctx.Err(), context.DeadlineExceeded):
+				reason = CancelTimeout
+			default:
+				return
+			}
+
+			pattern := routePattern(ctx)
+			metrics.HTTPCanceled.WithLabelValues(r.Method, pattern, reason).Inc()
+			reqctx.Logger(ctx).Warn("Request canceled",
+				slog.String("reason", reason),
+				slog.String("method", r.Method),
+				slog.String("route", pattern),
+				slog.Duration("timeout", d),
+				slog.Duration("elapsed", time.Since(start)),
+			)
+		})
+	}
+}
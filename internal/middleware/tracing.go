@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"avenger/pkg/reqctx"
+	"log/slog"
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing starts a server span per request, continuing the trace of an
+// incoming W3C traceparent header. The span is renamed after the route
+// template by Route; the trace ID is added to the request scoped logger.
+func Tracing(next http.Handler) http.Handler {
+	traced := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		span := trace.SpanFromContext(r.Context())
+		if id := reqctx.RequestID(r.Context()); id != "" {
+			span.SetAttributes(attribute.String("request.id", id))
+		}
+
+		ctx := r.Context()
+		if sc := span.SpanContext(); sc.HasTraceID() {
+			ctx = reqctx.WithLogger(ctx, reqctx.Logger(ctx).With(slog.String("trace_id", sc.TraceID().String())))
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+
+	return otelhttp.NewHandler(traced, "http.request",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method
+		}),
+	)
+}
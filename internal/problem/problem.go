@@ -0,0 +1,94 @@
+// Package problem writes error responses either as RFC 7807
+// application/problem+json documents or, for clients that do not ask for
+// them, in the legacy {"message", "errors"} envelope.
+package problem
+
+import (
+	"avenger/pkg/reqctx"
+	"encoding/json"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const ContentType = "application/problem+json"
+
+// TypeBase prefixes the error code to build the problem "type" URI.
+var TypeBase = "/problems/"
+
+// Problem is an RFC 7807 problem details document extended with a stable
+// machine-readable code, the request ID and per-field validation errors.
+type Problem struct {
+	Type      string            `json:"type"`
+	Title     string            `json:"title"`
+	Status    int               `json:"status"`
+	Detail    string            `json:"detail,omitempty"`
+	Instance  string            `json:"instance,omitempty"`
+	Code      string            `json:"code"`
+	RequestID string            `json:"request_id,omitempty"`
+	Errors    map[string]string `json:"errors,omitempty"`
+}
+
+// legacy mirrors the handler Response envelope returned before problem+json
+// was introduced.
+type legacy struct {
+	Message string            `json:"message"`
+	Errors  map[string]string `json:"errors,omitempty"`
+}
+
+// Write sends an error response for r. code is a stable identifier clients
+// can localize on, detail the human readable explanation and fields optional
+// per-field messages.
+func Write(w http.ResponseWriter, r *http.Request, status int, code, detail string, fields map[string]string) {
+	var body any
+	if Wants(r) {
+		w.Header().Set("Content-Type", ContentType)
+		body = Problem{
+			Type:      TypeBase + code,
+			Title:     http.StatusText(status),
+			Status:    status,
+			Detail:    detail,
+			Instance:  r.URL.Path,
+			Code:      code,
+			RequestID: requestID(r),
+			Errors:    fields,
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		body = legacy{Message: detail, Errors: fields}
+	}
+
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		reqctx.Logger(r.Context()).Error("Failed to encode error response", slog.Any("error", err))
+	}
+}
+
+// Wants reports whether the client listed application/problem+json in its
+// Accept header with a non-zero quality.
+func Wants(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || mediaType != ContentType {
+			continue
+		}
+		if q, ok := params["q"]; ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// requestID returns the ID assigned by the request ID middleware, falling
+// back to the X-Request-ID header when it did not run.
+func requestID(r *http.Request) string {
+	if id := reqctx.RequestID(r.Context()); id != "" {
+		return id
+	}
+	return r.Header.Get("X-Request-ID")
+}
@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"avenger/internal/apperr"
+	"avenger/internal/domain"
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type APIKeyRepository interface {
+	Create(ctx context.Context, key *domain.APIKey) error
+	GetByHash(ctx context.Context, hash string) (*domain.APIKey, error)
+	GetAll(ctx context.Context, page, perPage int) ([]domain.APIKey, int, error)
+	Revoke(ctx context.Context, id uint) error
+	RevokeAllForCreator(ctx context.Context, userID uint) error
+	Touch(ctx context.Context, id uint, at time.Time, every time.Duration) error
+}
+
+type apiKeyRepository struct {
+	DB *gorm.DB
+}
+
+func NewAPIKeyRepository(db *gorm.DB) APIKeyRepository {
+	return &apiKeyRepository{DB: db}
+}
+
+func (r *apiKeyRepository) Create(ctx context.Context, key *domain.APIKey) error {
+	return apperr.FromDB(r.DB.WithContext(ctx).Create(key).Error, "API key")
+}
+
+func (r *apiKeyRepository) GetByHash(ctx context.Context, hash string) (*domain.APIKey, error) {
+	var key domain.APIKey
+	if err := r.DB.WithContext(ctx).Where("key_hash = ?", hash).First(&key).Error; err != nil {
+		return nil, apperr.FromDB(err, "API key")
+	}
+	return &key, nil
+}
+
+// GetAll returns one page of keys, newest first, revoked ones included.
+func (r *apiKeyRepository) GetAll(ctx context.Context, page, perPage int) ([]domain.APIKey, int, error) {
+	var total int64
+	if err := r.DB.WithContext(ctx).Model(&domain.APIKey{}).Count(&total).Error; err != nil {
+		return nil, 0, apperr.FromDB(err, "API key")
+	}
+
+	var keys []domain.APIKey
+	err := r.DB.WithContext(ctx).Order("created_at DESC, id DESC").
+		Offset((page - 1) * perPage).
+		Limit(perPage).
+		Find(&keys).Error
+	if err != nil {
+		return nil, 0, apperr.FromDB(err, "API key")
+	}
+	return keys, int(total), nil
+}
+
+func (r *apiKeyRepository) Revoke(ctx context.Context, id uint) error {
+	result := r.DB.WithContext(ctx).Model(&domain.APIKey{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now().UTC())
+	if result.Error != nil {
+		return apperr.FromDB(result.Error, "API key")
+	}
+	if result.RowsAffected == 0 {
+		var count int64
+		if err := r.DB.WithContext(ctx).Model(&domain.APIKey{}).Where("id = ?", id).Count(&count).Error; err != nil {
+			return apperr.FromDB(err, "API key")
+		}
+		if count == 0 {
+			return apperr.FromDB(gorm.ErrRecordNotFound, "API key")
+		}
+		return apperr.Conflict("API key was already revoked")
+	}
+	return nil
+}
+
+// RevokeAllForCreator revokes every active key created by the user.
+func (r *apiKeyRepository) RevokeAllForCreator(ctx context.Context, userID uint) error {
+	err := r.DB.WithContext(ctx).Model(&domain.APIKey{}).
+		Where("created_by = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now().UTC()).Error
+	return apperr.FromDB(err, "API key")
+}
+
+// Touch records that the key was used at. To spare a write per request it
+// only does so when the stored timestamp is older than every.
+func (r *apiKeyRepository) Touch(ctx context.Context, id uint, at time.Time, every time.Duration) error {
+	err := r.DB.WithContext(ctx).Model(&domain.APIKey{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", id, at.Add(-every)).
+		Update("last_used_at", at).Error
+	return apperr.FromDB(err, "API key")
+}
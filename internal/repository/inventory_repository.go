@@ -1,17 +1,24 @@
 package repository
 
 import (
+	"avenger/internal/apperr"
 	"avenger/internal/domain"
+	"context"
 	"database/sql"
+	"fmt"
 	"strings"
 )
 
 type InventoryRepository interface {
-	GetAll() ([]domain.Inventory, error)
-	GetByID(id int) (*domain.Inventory, error)
-	Create(inv domain.Inventory) (int, error)
-	Update(id int, inv domain.Inventory) error
-	Delete(id int) error
+	GetAll(ctx context.Context, q domain.InventoryQuery) ([]domain.Inventory, int, error)
+	GetByID(ctx context.Context, id int) (*domain.Inventory, error)
+	Create(ctx context.Context, inv domain.Inventory) (int, error)
+	Update(ctx context.Context, id int, inv domain.Inventory) (int, error)
+	Delete(ctx context.Context, id, version int) error
+	AdjustStock(ctx context.Context, m *domain.StockMovement) error
+	GetMovements(ctx context.Context, inventoryID, page, perPage int) ([]domain.StockMovement, int, error)
+	LedgerStock(ctx context.Context, inventoryID int) (int, error)
+	Stats(ctx context.Context) (*domain.InventoryStats, error)
 }
 
 type inventoryRepository struct {
@@ -22,48 +29,135 @@ func NewInventoryRepository(db *sql.DB) InventoryRepository {
 	return &inventoryRepository{DB: db}
 }
 
-func (r *inventoryRepository) GetAll() ([]domain.Inventory, error) {
-	rows, err := r.DB.Query("SELECT id, name, code, stock, description, status FROM inventories ORDER BY id ASC")
+// inventorySortColumns maps the public sort fields to their SQL columns so
+// that nothing supplied by the client ends up in the query text.
+var inventorySortColumns = map[string]string{
+	"id":     "id",
+	"name":   "name",
+	"code":   "code",
+	"stock":  "stock",
+	"status": "status",
+}
+
+func (r *inventoryRepository) GetAll(ctx context.Context, q domain.InventoryQuery) ([]domain.Inventory, int, error) {
+	where, args := inventoryWhere(q)
+
+	var total int
+	if err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM inventories"+where, args...).Scan(&total); err != nil {
+		return nil, 0, apperr.FromDB(err, "Inventory")
+	}
+
+	query := "SELECT id, name, code, stock, description, status, version FROM inventories" + where + inventoryOrderBy(q.Sort)
+	args = append(args, q.PerPage, (q.Page-1)*q.PerPage)
+	query += fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	rows, err := r.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, 0, apperr.FromDB(err, "Inventory")
 	}
 	defer rows.Close()
 
-	var list []domain.Inventory
+	list := []domain.Inventory{}
 	for rows.Next() {
 		var inv domain.Inventory
-		err := rows.Scan(&inv.ID, &inv.Name, &inv.Code, &inv.Stock, &inv.Description, &inv.Status)
+		err := rows.Scan(&inv.ID, &inv.Name, &inv.Code, &inv.Stock, &inv.Description, &inv.Status, &inv.Version)
 		if err != nil {
-			return nil, err
+			return nil, 0, apperr.FromDB(err, "Inventory")
 		}
 		list = append(list, inv)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, apperr.FromDB(err, "Inventory")
+	}
 
-	return list, nil
+	return list, total, nil
 }
 
-func (r *inventoryRepository) GetByID(id int) (*domain.Inventory, error) {
-	row := r.DB.QueryRow("SELECT id, name, code, stock, description, status FROM inventories WHERE id = $1", id)
-	var inv domain.Inventory
-	err := row.Scan(&inv.ID, &inv.Name, &inv.Code, &inv.Stock, &inv.Description, &inv.Status)
-	if err == sql.ErrNoRows {
-		return nil, nil
+func inventoryWhere(q domain.InventoryQuery) (string, []any) {
+	var conds []string
+	var args []any
+
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if q.Status != "" {
+		add("status = $%d", q.Status)
+	}
+	if q.CodePrefix != "" {
+		add(`code LIKE $%d ESCAPE '\'`, escapeLike(q.CodePrefix)+"%")
+	}
+	if q.Name != "" {
+		add(`name ILIKE $%d ESCAPE '\'`, "%"+escapeLike(q.Name)+"%")
+	}
+	if q.StockGTE != nil {
+		add("stock >= $%d", *q.StockGTE)
+	}
+	if q.StockLTE != nil {
+		add("stock <= $%d", *q.StockLTE)
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+func inventoryOrderBy(sort []domain.SortField) string {
+	terms := make([]string, 0, len(sort)+1)
+	hasID := false
+	for _, s := range sort {
+		col, ok := inventorySortColumns[s.Field]
+		if !ok {
+			continue
+		}
+		if col == "id" {
+			hasID = true
+		}
+		if s.Desc {
+			terms = append(terms, col+" DESC")
+		} else {
+			terms = append(terms, col+" ASC")
+		}
 	}
+	// Always finish on the primary key so pages are stable.
+	if !hasID {
+		terms = append(terms, "id ASC")
+	}
+	return " ORDER BY " + strings.Join(terms, ", ")
+}
+
+// escapeLike escapes the LIKE wildcards in s so it is matched literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+func (r *inventoryRepository) GetByID(ctx context.Context, id int) (*domain.Inventory, error) {
+	row := r.DB.QueryRowContext(ctx, "SELECT id, name, code, stock, description, status, version FROM inventories WHERE id = $1", id)
+	var inv domain.Inventory
+	err := row.Scan(&inv.ID, &inv.Name, &inv.Code, &inv.Stock, &inv.Description, &inv.Status, &inv.Version)
 	if err != nil {
-		return nil, err
+		return nil, apperr.FromDB(err, "Inventory")
 	}
 
 	return &inv, nil
 }
 
-func (r *inventoryRepository) Create(inv domain.Inventory) (int, error) {
+func (r *inventoryRepository) Create(ctx context.Context, inv domain.Inventory) (int, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, apperr.FromDB(err, "Inventory")
+	}
+	defer tx.Rollback()
+
 	query := `
 	INSERT INTO inventories (name, code, stock, description, status)
 	VALUES ($1, $2, $3, $4, $5)
 	RETURNING id`
 
 	var id int
-	err := r.DB.QueryRow(
+	err = tx.QueryRowContext(ctx,
 		query,
 		inv.Name,
 		inv.Code,
@@ -73,48 +167,210 @@ func (r *inventoryRepository) Create(inv domain.Inventory) (int, error) {
 	).Scan(&id)
 
 	if err != nil {
-		if strings.Contains(err.Error(), "duplicate key") || strings.Contains(err.Error(), "unique constraint") {
-			return 0, sql.ErrConnDone
+		return 0, apperr.FromDB(err, "Inventory")
+	}
+
+	if inv.Stock != 0 {
+		opening := domain.StockMovement{
+			InventoryID: id,
+			Delta:       inv.Stock,
+			Reason:      domain.MovementReceive,
+			Reference:   "opening balance",
+			StockAfter:  inv.Stock,
+		}
+		if err := insertMovement(ctx, tx, &opening); err != nil {
+			return 0, apperr.FromDB(err, "Inventory")
 		}
-		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, apperr.FromDB(err, "Inventory")
 	}
 
 	return id, nil
 }
 
-func (r *inventoryRepository) Update(id int, inv domain.Inventory) error {
-	result, err := r.DB.Exec(`UPDATE inventories SET name=$1, code=$2, stock=$3, description=$4, status=$5 WHERE id=$6`, inv.Name, inv.Code, inv.Stock, inv.Description, inv.Status, id)
+// Update overwrites the inventory and returns its new version. When
+// inv.Version is non-zero the write only succeeds if it is still the current
+// version. A change of stock is recorded in the ledger as an adjustment so
+// the ledger stays in balance.
+func (r *inventoryRepository) Update(ctx context.Context, id int, inv domain.Inventory) (int, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, apperr.FromDB(err, "Inventory")
+	}
+	defer tx.Rollback()
+
+	var current, version int
+	err = tx.QueryRowContext(ctx, "SELECT stock, version FROM inventories WHERE id=$1 FOR UPDATE", id).Scan(&current, &version)
 	if err != nil {
-		if strings.Contains(err.Error(), "duplicate key") || strings.Contains(err.Error(), "unique constraint") {
-			return sql.ErrConnDone
+		return 0, apperr.FromDB(err, "Inventory")
+	}
+	if inv.Version != 0 && inv.Version != version {
+		return 0, &apperr.VersionConflictError{Resource: "inventory", Current: version}
+	}
+
+	err = tx.QueryRowContext(ctx, `
+	UPDATE inventories
+	SET name=$1, code=$2, stock=$3, description=$4, status=$5, version=version+1, updated_at=CURRENT_TIMESTAMP
+	WHERE id=$6 AND version=$7
+	RETURNING version`, inv.Name, inv.Code, inv.Stock, inv.Description, inv.Status, id, version).Scan(&version)
+	if err != nil {
+		return 0, apperr.FromDB(err, "Inventory")
+	}
+
+	if delta := inv.Stock - current; delta != 0 {
+		adjust := domain.StockMovement{
+			InventoryID: id,
+			Delta:       delta,
+			Reason:      domain.MovementAdjust,
+			Reference:   "inventory update",
+			StockAfter:  inv.Stock,
 		}
-		return err
+		if err := insertMovement(ctx, tx, &adjust); err != nil {
+			return 0, apperr.FromDB(err, "Inventory")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, apperr.FromDB(err, "Inventory")
+	}
+
+	return version, nil
+}
+
+// Delete removes the inventory. A non-zero version makes the delete
+// conditional on it still being the current version.
+func (r *inventoryRepository) Delete(ctx context.Context, id, version int) error {
+	result, err := r.DB.ExecContext(ctx, "DELETE FROM inventories WHERE id=$1 AND ($2 = 0 OR version=$2)", id, version)
+	if err != nil {
+		return apperr.FromDB(err, "Inventory")
 	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return err
+		return apperr.FromDB(err, "Inventory")
 	}
 	if rowsAffected == 0 {
-		return sql.ErrNoRows
+		var current int
+		if err := r.DB.QueryRowContext(ctx, "SELECT version FROM inventories WHERE id=$1", id).Scan(&current); err != nil {
+			return apperr.FromDB(err, "Inventory")
+		}
+		return &apperr.VersionConflictError{Resource: "inventory", Current: current}
 	}
 
 	return nil
 }
 
-func (r *inventoryRepository) Delete(id int) error {
-	result, err := r.DB.Exec("DELETE FROM inventories WHERE id=$1", id)
+// AdjustStock applies m.Delta to the inventory stock and records m in the
+// ledger within one transaction. The inventory row is locked so concurrent
+// movements are serialized.
+func (r *inventoryRepository) AdjustStock(ctx context.Context, m *domain.StockMovement) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return apperr.FromDB(err, "Inventory")
 	}
+	defer tx.Rollback()
 
-	rowsAffected, err := result.RowsAffected()
+	var stock int
+	err = tx.QueryRowContext(ctx, "SELECT stock FROM inventories WHERE id=$1 FOR UPDATE", m.InventoryID).Scan(&stock)
 	if err != nil {
-		return err
+		return apperr.FromDB(err, "Inventory")
 	}
-	if rowsAffected == 0 {
-		return sql.ErrNoRows
+
+	m.StockAfter = stock + m.Delta
+	if m.StockAfter < 0 {
+		return apperr.Conflict("Insufficient stock").WithCode(apperr.CodeInsufficientStock).WithField("delta", "stock cannot go below zero")
 	}
 
-	return nil
+	_, err = tx.ExecContext(ctx, "UPDATE inventories SET stock=$1, version=version+1, updated_at=CURRENT_TIMESTAMP WHERE id=$2", m.StockAfter, m.InventoryID)
+	if err != nil {
+		return apperr.FromDB(err, "Inventory")
+	}
+
+	if err := insertMovement(ctx, tx, m); err != nil {
+		return apperr.FromDB(err, "Inventory")
+	}
+
+	return apperr.FromDB(tx.Commit(), "Inventory")
+}
+
+func (r *inventoryRepository) GetMovements(ctx context.Context, inventoryID, page, perPage int) ([]domain.StockMovement, int, error) {
+	var total int
+	if err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM stock_movements WHERE inventory_id=$1", inventoryID).Scan(&total); err != nil {
+		return nil, 0, apperr.FromDB(err, "Inventory")
+	}
+
+	rows, err := r.DB.QueryContext(ctx, `
+	SELECT id, inventory_id, delta, reason, reference, actor_id, stock_after, created_at
+	FROM stock_movements
+	WHERE inventory_id=$1
+	ORDER BY id DESC
+	LIMIT $2 OFFSET $3`, inventoryID, perPage, (page-1)*perPage)
+	if err != nil {
+		return nil, 0, apperr.FromDB(err, "Inventory")
+	}
+	defer rows.Close()
+
+	list := []domain.StockMovement{}
+	for rows.Next() {
+		var m domain.StockMovement
+		var actor sql.NullInt64
+		err := rows.Scan(&m.ID, &m.InventoryID, &m.Delta, &m.Reason, &m.Reference, &actor, &m.StockAfter, &m.CreatedAt)
+		if err != nil {
+			return nil, 0, apperr.FromDB(err, "Inventory")
+		}
+		if actor.Valid {
+			id := int(actor.Int64)
+			m.ActorID = &id
+		}
+		list = append(list, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, apperr.FromDB(err, "Inventory")
+	}
+
+	return list, total, nil
+}
+
+// LedgerStock returns the stock of the inventory as derived from the ledger.
+func (r *inventoryRepository) LedgerStock(ctx context.Context, inventoryID int) (int, error) {
+	var stock int
+	err := r.DB.QueryRowContext(ctx, "SELECT COALESCE(SUM(delta), 0) FROM stock_movements WHERE inventory_id=$1", inventoryID).Scan(&stock)
+	return stock, apperr.FromDB(err, "Inventory")
+}
+
+// Stats counts inventories by status and those at zero stock.
+func (r *inventoryRepository) Stats(ctx context.Context) (*domain.InventoryStats, error) {
+	rows, err := r.DB.QueryContext(ctx, "SELECT status, COUNT(*), COUNT(*) FILTER (WHERE stock = 0) FROM inventories GROUP BY status")
+	if err != nil {
+		return nil, fmt.Errorf("count inventories: %w", err)
+	}
+	defer rows.Close()
+
+	stats := &domain.InventoryStats{ByStatus: map[string]int{}}
+	for rows.Next() {
+		var status string
+		var count, outOfStock int
+		if err := rows.Scan(&status, &count, &outOfStock); err != nil {
+			return nil, fmt.Errorf("scan inventory counts: %w", err)
+		}
+		stats.ByStatus[status] = count
+		stats.OutOfStock += outOfStock
+	}
+	return stats, rows.Err()
+}
+
+func insertMovement(ctx context.Context, tx *sql.Tx, m *domain.StockMovement) error {
+	return tx.QueryRowContext(ctx, `
+	INSERT INTO stock_movements (inventory_id, delta, reason, reference, actor_id, stock_after)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at`,
+		m.InventoryID,
+		m.Delta,
+		m.Reason,
+		m.Reference,
+		m.ActorID,
+		m.StockAfter,
+	).Scan(&m.ID, &m.CreatedAt)
 }
@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"avenger/internal/apperr"
+	"avenger/internal/domain"
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type InvitationRepository interface {
+	Create(ctx context.Context, inv *domain.Invitation) error
+	GetByID(ctx context.Context, id string) (*domain.Invitation, error)
+	GetAll(ctx context.Context, page, perPage int) ([]domain.Invitation, int, error)
+	Revoke(ctx context.Context, id string) error
+	Redeem(ctx context.Context, id string, user *domain.User) error
+}
+
+type invitationRepository struct {
+	DB *gorm.DB
+}
+
+func NewInvitationRepository(db *gorm.DB) InvitationRepository {
+	return &invitationRepository{DB: db}
+}
+
+func (r *invitationRepository) Create(ctx context.Context, inv *domain.Invitation) error {
+	return apperr.FromDB(r.DB.WithContext(ctx).Create(inv).Error, "Invitation")
+}
+
+func (r *invitationRepository) GetByID(ctx context.Context, id string) (*domain.Invitation, error) {
+	var inv domain.Invitation
+	if err := r.DB.WithContext(ctx).Where("id = ?", id).First(&inv).Error; err != nil {
+		return nil, apperr.FromDB(err, "Invitation")
+	}
+	return &inv, nil
+}
+
+// GetAll returns one page of invitations, newest first.
+func (r *invitationRepository) GetAll(ctx context.Context, page, perPage int) ([]domain.Invitation, int, error) {
+	var total int64
+	if err := r.DB.WithContext(ctx).Model(&domain.Invitation{}).Count(&total).Error; err != nil {
+		return nil, 0, apperr.FromDB(err, "Invitation")
+	}
+
+	var invs []domain.Invitation
+	err := r.DB.WithContext(ctx).Order("created_at DESC, id").
+		Offset((page - 1) * perPage).
+		Limit(perPage).
+		Find(&invs).Error
+	if err != nil {
+		return nil, 0, apperr.FromDB(err, "Invitation")
+	}
+	return invs, int(total), nil
+}
+
+// Revoke invalidates a pending invitation.
+func (r *invitationRepository) Revoke(ctx context.Context, id string) error {
+	result := r.DB.WithContext(ctx).Model(&domain.Invitation{}).
+		Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now().UTC())
+	if result.Error != nil {
+		return apperr.FromDB(result.Error, "Invitation")
+	}
+	if result.RowsAffected == 0 {
+		if _, err := r.GetByID(ctx, id); err != nil {
+			return err
+		}
+		return apperr.Conflict("Invitation was already used or revoked")
+	}
+	return nil
+}
+
+// Redeem creates user and marks the invitation used in one transaction, so
+// an invitation yields at most one account.
+func (r *invitationRepository) Redeem(ctx context.Context, id string, user *domain.User) error {
+	err := r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now().UTC()
+		result := tx.Model(&domain.Invitation{}).
+			Where("id = ? AND used_at IS NULL AND revoked_at IS NULL AND expires_at > ?", id, now).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return apperr.Conflict("Invitation is no longer valid").WithCode(apperr.CodeInvalidInvitation)
+		}
+
+		if err := tx.Create(user).Error; err != nil {
+			return apperr.FromDB(err, "User")
+		}
+
+		return tx.Model(&domain.Invitation{}).Where("id = ?", id).Update("used_by", user.ID).Error
+	})
+	return apperr.FromDB(err, "Invitation")
+}
@@ -0,0 +1,25 @@
+package repository
+
+import (
+	"avenger/internal/apperr"
+	"avenger/internal/domain"
+	"context"
+
+	"gorm.io/gorm"
+)
+
+type LoginAttemptRepository interface {
+	Create(ctx context.Context, attempt *domain.LoginAttempt) error
+}
+
+type loginAttemptRepository struct {
+	DB *gorm.DB
+}
+
+func NewLoginAttemptRepository(db *gorm.DB) LoginAttemptRepository {
+	return &loginAttemptRepository{DB: db}
+}
+
+func (r *loginAttemptRepository) Create(ctx context.Context, attempt *domain.LoginAttempt) error {
+	return apperr.FromDB(r.DB.WithContext(ctx).Create(attempt).Error, "Login attempt")
+}
@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"avenger/internal/apperr"
+	"avenger/internal/domain"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// LoginCounterStore keeps failed login counters. The in-memory store is
+// enough for a single instance; use the Postgres one when several instances
+// share the load so a client cannot spread its attempts across them.
+type LoginCounterStore interface {
+	// Get returns the counter for key, nil when there is none.
+	Get(ctx context.Context, key string) (*domain.LoginCounter, error)
+	// Increment records a failure at now and returns the number of failures,
+	// starting over when the previous one is older than window.
+	Increment(ctx context.Context, key string, now time.Time, window time.Duration) (int, error)
+	Lock(ctx context.Context, key string, until time.Time) error
+	Reset(ctx context.Context, key string) error
+}
+
+type loginCounterRepository struct {
+	DB *gorm.DB
+}
+
+// NewLoginCounterRepository returns a LoginCounterStore backed by the
+// login_counters table.
+func NewLoginCounterRepository(db *gorm.DB) LoginCounterStore {
+	return &loginCounterRepository{DB: db}
+}
+
+func (r *loginCounterRepository) Get(ctx context.Context, key string) (*domain.LoginCounter, error) {
+	var counter domain.LoginCounter
+	if err := r.DB.WithContext(ctx).Where("key = ?", key).First(&counter).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, apperr.FromDB(err, "Login counter")
+	}
+	return &counter, nil
+}
+
+// Increment is a single upsert so concurrent failures are all counted.
+func (r *loginCounterRepository) Increment(ctx context.Context, key string, now time.Time, window time.Duration) (int, error) {
+	var failures int
+	err := r.DB.WithContext(ctx).Raw(`
+		INSERT INTO login_counters (key, failures, last_failure_at) VALUES (?, 1, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_counters.last_failure_at < ? THEN 1 ELSE login_counters.failures + 1 END,
+			last_failure_at = EXCLUDED.last_failure_at
+		RETURNING failures`, key, now, now.Add(-window)).Scan(&failures).Error
+	return failures, apperr.FromDB(err, "Login counter")
+}
+
+func (r *loginCounterRepository) Lock(ctx context.Context, key string, until time.Time) error {
+	err := r.DB.WithContext(ctx).Model(&domain.LoginCounter{}).Where("key = ?", key).Update("locked_until", until).Error
+	return apperr.FromDB(err, "Login counter")
+}
+
+func (r *loginCounterRepository) Reset(ctx context.Context, key string) error {
+	return apperr.FromDB(r.DB.WithContext(ctx).Where("key = ?", key).Delete(&domain.LoginCounter{}).Error, "Login counter")
+}
+
+// memorySweepInterval is how often the in-memory store drops stale counters.
+const memorySweepInterval = 5 * time.Minute
+
+type memoryLoginCounterStore struct {
+	mu        sync.Mutex
+	counters  map[string]*domain.LoginCounter
+	window    time.Duration
+	lastSweep time.Time
+}
+
+// NewMemoryLoginCounterStore returns a LoginCounterStore local to this
+// process.
+func NewMemoryLoginCounterStore() LoginCounterStore {
+	return &memoryLoginCounterStore{counters: map[string]*domain.LoginCounter{}}
+}
+
+func (s *memoryLoginCounterStore) Get(ctx context.Context, key string) (*domain.LoginCounter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counter, ok := s.counters[key]
+	if !ok {
+		return nil, nil
+	}
+	copied := *counter
+	return &copied, nil
+}
+
+func (s *memoryLoginCounterStore) Increment(ctx context.Context, key string, now time.Time, window time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.window = window
+	s.sweep(now)
+
+	counter, ok := s.counters[key]
+	if !ok {
+		counter = &domain.LoginCounter{Key: key}
+		s.counters[key] = counter
+	}
+	if counter.LastFailureAt.Before(now.Add(-window)) {
+		counter.Failures = 0
+	}
+	counter.Failures++
+	counter.LastFailureAt = now
+	return counter.Failures, nil
+}
+
+func (s *memoryLoginCounterStore) Lock(ctx context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if counter, ok := s.counters[key]; ok {
+		counter.LockedUntil = &until
+	}
+	return nil
+}
+
+func (s *memoryLoginCounterStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.counters, key)
+	return nil
+}
+
+// sweep drops counters whose failures have aged out of the window and whose
+// lock has expired. The caller holds s.mu.
+func (s *memoryLoginCounterStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < memorySweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, counter := range s.counters {
+		if _, locked := counter.Locked(now); !locked && counter.LastFailureAt.Before(now.Add(-s.window)) {
+			delete(s.counters, key)
+		}
+	}
+}
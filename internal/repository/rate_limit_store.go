@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"avenger/internal/apperr"
+	"avenger/internal/domain"
+	"context"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RateLimitStore keeps GCRA buckets. Like LoginCounterStore it has an
+// in-memory implementation for a single instance and a Postgres one shared
+// by all instances.
+type RateLimitStore interface {
+	// Take admits one request for key at now when the bucket's theoretical
+	// arrival time, advanced by increment, is no more than burst ahead of
+	// now. It returns the new arrival time when admitted and the current one
+	// when not.
+	Take(ctx context.Context, key string, now time.Time, increment, burst time.Duration) (time.Time, bool, error)
+}
+
+type rateLimitRepository struct {
+	DB *gorm.DB
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// NewRateLimitRepository returns a RateLimitStore backed by the rate_limits
+// table.
+func NewRateLimitRepository(db *gorm.DB) RateLimitStore {
+	return &rateLimitRepository{DB: db}
+}
+
+// Take is a single upsert: the update only happens when the request is
+// admitted, so concurrent requests cannot overspend a bucket.
+func (r *rateLimitRepository) Take(ctx context.Context, key string, now time.Time, increment, burst time.Duration) (time.Time, bool, error) {
+	r.sweep(ctx, now)
+
+	nowNs, inc, burstNs := now.UnixNano(), increment.Nanoseconds(), burst.Nanoseconds()
+	var tats []int64
+	err := r.DB.WithContext(ctx).Raw(`
+		INSERT INTO rate_limits AS r (key, tat) VALUES (?, ?)
+		ON CONFLICT (key) DO UPDATE SET tat = GREATEST(r.tat, ?) + ?
+		WHERE GREATEST(r.tat, ?) + ? - ? <= ?
+		RETURNING tat`, key, nowNs+inc, nowNs, inc, nowNs, inc, burstNs, nowNs).Scan(&tats).Error
+	if err != nil {
+		return time.Time{}, false, apperr.FromDB(err, "Rate limit")
+	}
+	if len(tats) > 0 {
+		return time.Unix(0, tats[0]), true, nil
+	}
+
+	var bucket domain.RateLimitBucket
+	if err := r.DB.WithContext(ctx).Where("key = ?", key).First(&bucket).Error; err != nil {
+		return time.Time{}, false, apperr.FromDB(err, "Rate limit")
+	}
+	return time.Unix(0, bucket.TAT), false, nil
+}
+
+// sweep deletes buckets that have fully drained, at most once per
+// memorySweepInterval per instance.
+func (r *rateLimitRepository) sweep(ctx context.Context, now time.Time) {
+	r.mu.Lock()
+	if now.Sub(r.lastSweep) < memorySweepInterval {
+		r.mu.Unlock()
+		return
+	}
+	r.lastSweep = now
+	r.mu.Unlock()
+
+	r.DB.WithContext(ctx).Where("tat < ?", now.UnixNano()).Delete(&domain.RateLimitBucket{})
+}
+
+type memoryRateLimitStore struct {
+	mu        sync.Mutex
+	tats      map[string]time.Time
+	lastSweep time.Time
+}
+
+// NewMemoryRateLimitStore returns a RateLimitStore local to this process.
+func NewMemoryRateLimitStore() RateLimitStore {
+	return &memoryRateLimitStore{tats: map[string]time.Time{}}
+}
+
+func (s *memoryRateLimitStore) Take(ctx context.Context, key string, now time.Time, increment, burst time.Duration) (time.Time, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) >= memorySweepInterval {
+		s.lastSweep = now
+		for k, tat := range s.tats {
+			if tat.Before(now) {
+				delete(s.tats, k)
+			}
+		}
+	}
+
+	tat := s.tats[key]
+	if tat.Before(now) {
+		tat = now
+	}
+	next := tat.Add(increment)
+	if next.Sub(now) > burst {
+		return s.tats[key], false, nil
+	}
+	s.tats[key] = next
+	return next, true, nil
+}
@@ -1,15 +1,20 @@
 package repository
 
 import (
+	"avenger/internal/apperr"
 	"avenger/internal/domain"
+	"context"
+	"fmt"
 
 	"gorm.io/gorm"
 )
 
 type RecipeRepository interface {
-	GetAll() ([]domain.Recipe, error)
-	Create(recipe *domain.Recipe) error
-	Delete(id int) error
+	GetAll(ctx context.Context, q domain.RecipeQuery) ([]domain.Recipe, error)
+	GetByID(ctx context.Context, id int) (*domain.Recipe, error)
+	Create(ctx context.Context, recipe *domain.Recipe) error
+	Update(ctx context.Context, recipe *domain.Recipe) error
+	Delete(ctx context.Context, id, version int) error
 }
 
 type recipeRepository struct {
@@ -20,24 +25,120 @@ func NewRecipeRepository(db *gorm.DB) RecipeRepository {
 	return &recipeRepository{DB: db}
 }
 
-func (r *recipeRepository) GetAll() ([]domain.Recipe, error) {
-	var recipes []domain.Recipe
-	err := r.DB.Order("id ASC").Find(&recipes).Error
-	return recipes, err
+var recipeSortColumns = map[string]string{
+	"id":         "id",
+	"rating":     "rating",
+	"cook_time":  "cook_time",
+	"created_at": "created_at",
 }
 
-func (r *recipeRepository) Create(recipe *domain.Recipe) error {
-	return r.DB.Create(recipe).Error
+// GetAll returns up to q.Limit recipes that come after q.Cursor in the
+// requested order. Ties are broken by id so the ordering is total.
+func (r *recipeRepository) GetAll(ctx context.Context, q domain.RecipeQuery) ([]domain.Recipe, error) {
+	db := r.DB.WithContext(ctx).Model(&domain.Recipe{})
+
+	if q.MinRating != nil {
+		db = db.Where("rating >= ?", *q.MinRating)
+	}
+	if q.MaxCookTime != nil {
+		db = db.Where("cook_time <= ?", *q.MaxCookTime)
+	}
+	if q.Search != "" {
+		pattern := "%" + escapeLike(q.Search) + "%"
+		db = db.Where("(name ILIKE ? OR description ILIKE ?)", pattern, pattern)
+	}
+
+	col, ok := recipeSortColumns[q.Sort.Field]
+	if !ok {
+		col = "id"
+	}
+	op, dir := ">", "ASC"
+	if q.Sort.Desc {
+		op, dir = "<", "DESC"
+	}
+
+	if c := q.Cursor; c != nil {
+		switch col {
+		case "id":
+			db = db.Where("id "+op+" ?", c.ID)
+		case "rating":
+			db = db.Where(fmt.Sprintf("(rating, id) %s (?, ?)", op), c.Rating, c.ID)
+		case "cook_time":
+			db = db.Where(fmt.Sprintf("(cook_time, id) %s (?, ?)", op), c.CookTime, c.ID)
+		case "created_at":
+			db = db.Where(fmt.Sprintf("(created_at, id) %s (?, ?)", op), c.CreatedAt, c.ID)
+		}
+	}
+
+	if col != "id" {
+		db = db.Order(col + " " + dir)
+	}
+	db = db.Order("id " + dir)
+
+	recipes := []domain.Recipe{}
+	err := db.Limit(q.Limit).Find(&recipes).Error
+	return recipes, apperr.FromDB(err, "Recipe")
+}
+
+func (r *recipeRepository) GetByID(ctx context.Context, id int) (*domain.Recipe, error) {
+	var recipe domain.Recipe
+	if err := r.DB.WithContext(ctx).First(&recipe, id).Error; err != nil {
+		return nil, apperr.FromDB(err, "Recipe")
+	}
+	return &recipe, nil
+}
+
+func (r *recipeRepository) Create(ctx context.Context, recipe *domain.Recipe) error {
+	return apperr.FromDB(r.DB.WithContext(ctx).Create(recipe).Error, "Recipe")
+}
+
+// Update writes the editable columns of recipe, conditional on recipe.Version
+// still being current, and bumps recipe.Version on success.
+func (r *recipeRepository) Update(ctx context.Context, recipe *domain.Recipe) error {
+	result := r.DB.WithContext(ctx).Model(&domain.Recipe{}).
+		Where("id = ? AND version = ?", recipe.ID, recipe.Version).
+		Updates(map[string]any{
+			"name":        recipe.Name,
+			"description": recipe.Description,
+			"cook_time":   recipe.CookTime,
+			"rating":      recipe.Rating,
+			"version":     gorm.Expr("version + 1"),
+		})
+	if result.Error != nil {
+		return apperr.FromDB(result.Error, "Recipe")
+	}
+
+	if result.RowsAffected == 0 {
+		var current domain.Recipe
+		if err := r.DB.WithContext(ctx).Select("version").First(&current, recipe.ID).Error; err != nil {
+			return apperr.FromDB(err, "Recipe")
+		}
+		return &apperr.VersionConflictError{Resource: "recipe", Current: current.Version}
+	}
+
+	recipe.Version++
+	return nil
 }
 
-func (r *recipeRepository) Delete(id int) error {
-	result := r.DB.Delete(&domain.Recipe{}, id)
+// Delete soft-deletes the recipe. A non-zero version makes the delete
+// conditional on it still being the current version.
+func (r *recipeRepository) Delete(ctx context.Context, id, version int) error {
+	db := r.DB.WithContext(ctx)
+	if version != 0 {
+		db = db.Where("version = ?", version)
+	}
+
+	result := db.Delete(&domain.Recipe{}, id)
 	if result.Error != nil {
-		return result.Error
+		return apperr.FromDB(result.Error, "Recipe")
 	}
 
 	if result.RowsAffected == 0 {
-		return gorm.ErrRecordNotFound
+		var current domain.Recipe
+		if err := r.DB.WithContext(ctx).Select("version").First(&current, id).Error; err != nil {
+			return apperr.FromDB(err, "Recipe")
+		}
+		return &apperr.VersionConflictError{Resource: "recipe", Current: current.Version}
 	}
 
 	return nil
@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"avenger/internal/apperr"
+	"avenger/internal/domain"
+	"context"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type RoleRepository interface {
+	GetPermissions(ctx context.Context, role string) ([]string, error)
+	Exists(ctx context.Context, role string) (bool, error)
+	GetAll(ctx context.Context) ([]domain.Role, error)
+	RequiresTwoFactor(ctx context.Context, role string) (bool, error)
+	SetRequireTwoFactor(ctx context.Context, role string, required bool) (*domain.Role, error)
+	Seed(ctx context.Context, defaults map[string][]string) error
+}
+
+type roleRepository struct {
+	DB *gorm.DB
+}
+
+func NewRoleRepository(db *gorm.DB) RoleRepository {
+	return &roleRepository{DB: db}
+}
+
+// GetPermissions returns the permissions granted to role, empty for roles
+// that do not exist.
+func (r *roleRepository) GetPermissions(ctx context.Context, role string) ([]string, error) {
+	var perms []string
+	err := r.DB.WithContext(ctx).Model(&domain.RolePermission{}).
+		Where("role_name = ?", role).
+		Order("permission").
+		Pluck("permission", &perms).Error
+	return perms, apperr.FromDB(err, "Role")
+}
+
+func (r *roleRepository) Exists(ctx context.Context, role string) (bool, error) {
+	var count int64
+	err := r.DB.WithContext(ctx).Model(&domain.Role{}).Where("name = ?", role).Count(&count).Error
+	return count > 0, apperr.FromDB(err, "Role")
+}
+
+func (r *roleRepository) GetAll(ctx context.Context) ([]domain.Role, error) {
+	var roles []domain.Role
+	err := r.DB.WithContext(ctx).Order("name").Find(&roles).Error
+	return roles, apperr.FromDB(err, "Role")
+}
+
+// RequiresTwoFactor reports whether members of role need a second factor,
+// false for roles that do not exist.
+func (r *roleRepository) RequiresTwoFactor(ctx context.Context, role string) (bool, error) {
+	var required []bool
+	err := r.DB.WithContext(ctx).Model(&domain.Role{}).Where("name = ?", role).Pluck("require_two_factor", &required).Error
+	return len(required) > 0 && required[0], apperr.FromDB(err, "Role")
+}
+
+func (r *roleRepository) SetRequireTwoFactor(ctx context.Context, role string, required bool) (*domain.Role, error) {
+	var roles []domain.Role
+	err := r.DB.WithContext(ctx).Model(&roles).
+		Clauses(clause.Returning{}).
+		Where("name = ?", role).
+		Update("require_two_factor", required).Error
+	if err != nil {
+		return nil, apperr.FromDB(err, "Role")
+	}
+	if len(roles) == 0 {
+		return nil, apperr.FromDB(gorm.ErrRecordNotFound, "Role")
+	}
+	return &roles[0], nil
+}
+
+// Seed creates each missing role with its default permissions. Roles that
+// already exist are left untouched so changes made in the database stick.
+func (r *roleRepository) Seed(ctx context.Context, defaults map[string][]string) error {
+	err := r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for name, perms := range defaults {
+			res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&domain.Role{Name: name})
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				continue
+			}
+			rows := make([]domain.RolePermission, len(perms))
+			for i, p := range perms {
+				rows[i] = domain.RolePermission{RoleName: name, Permission: p}
+			}
+			if err := tx.Create(&rows).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return apperr.FromDB(err, "Role")
+}
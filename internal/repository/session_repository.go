@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"avenger/internal/apperr"
+	"avenger/internal/domain"
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type SessionRepository interface {
+	Create(ctx context.Context, session *domain.Session, token *domain.RefreshToken) error
+	GetByID(ctx context.Context, id string) (*domain.Session, error)
+	GetRefreshToken(ctx context.Context, hash string) (*domain.RefreshToken, error)
+	Rotate(ctx context.Context, used, next *domain.RefreshToken, expiresAt time.Time) (bool, error)
+	Revoke(ctx context.Context, id, reason string) error
+	MarkTwoFactor(ctx context.Context, id string) error
+	RevokeAllForUser(ctx context.Context, userID uint, exceptID, reason string) error
+}
+
+type sessionRepository struct {
+	DB *gorm.DB
+}
+
+func NewSessionRepository(db *gorm.DB) SessionRepository {
+	return &sessionRepository{DB: db}
+}
+
+// Create stores a new session together with its first refresh token.
+func (r *sessionRepository) Create(ctx context.Context, session *domain.Session, token *domain.RefreshToken) error {
+	err := r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(session).Error; err != nil {
+			return err
+		}
+		token.SessionID = session.ID
+		return tx.Create(token).Error
+	})
+	return apperr.FromDB(err, "Session")
+}
+
+func (r *sessionRepository) GetByID(ctx context.Context, id string) (*domain.Session, error) {
+	var session domain.Session
+	if err := r.DB.WithContext(ctx).Where("id = ?", id).First(&session).Error; err != nil {
+		return nil, apperr.FromDB(err, "Session")
+	}
+	return &session, nil
+}
+
+func (r *sessionRepository) GetRefreshToken(ctx context.Context, hash string) (*domain.RefreshToken, error) {
+	var token domain.RefreshToken
+	if err := r.DB.WithContext(ctx).Where("token_hash = ?", hash).First(&token).Error; err != nil {
+		return nil, apperr.FromDB(err, "Refresh token")
+	}
+	return &token, nil
+}
+
+// Rotate marks used as spent and stores next in its place, extending the
+// session to expiresAt. It returns false without changing anything when used
+// was already spent by a concurrent request.
+func (r *sessionRepository) Rotate(ctx context.Context, used, next *domain.RefreshToken, expiresAt time.Time) (bool, error) {
+	rotated := false
+	err := r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now().UTC()
+		res := tx.Model(&domain.RefreshToken{}).
+			Where("id = ? AND used_at IS NULL", used.ID).
+			Update("used_at", now)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return nil
+		}
+
+		next.SessionID = used.SessionID
+		if err := tx.Create(next).Error; err != nil {
+			return err
+		}
+
+		rotated = true
+		return tx.Model(&domain.Session{}).
+			Where("id = ?", used.SessionID).
+			Updates(map[string]any{"last_used_at": now, "expires_at": expiresAt, "ip": next.IP}).Error
+	})
+	return rotated, apperr.FromDB(err, "Session")
+}
+
+// Revoke ends a session. Revoking an already revoked session keeps the
+// original reason.
+func (r *sessionRepository) Revoke(ctx context.Context, id, reason string) error {
+	err := r.DB.WithContext(ctx).Model(&domain.Session{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Updates(map[string]any{"revoked_at": time.Now().UTC(), "revoked_reason": reason}).Error
+	return apperr.FromDB(err, "Session")
+}
+
+// MarkTwoFactor records that the session's user proved a second factor.
+func (r *sessionRepository) MarkTwoFactor(ctx context.Context, id string) error {
+	err := r.DB.WithContext(ctx).Model(&domain.Session{}).Where("id = ?", id).Update("two_factor", true).Error
+	return apperr.FromDB(err, "Session")
+}
+
+// RevokeAllForUser ends every active session of the user except exceptID,
+// which may be empty.
+func (r *sessionRepository) RevokeAllForUser(ctx context.Context, userID uint, exceptID, reason string) error {
+	err := r.DB.WithContext(ctx).Model(&domain.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL AND id <> ?", userID, exceptID).
+		Updates(map[string]any{"revoked_at": time.Now().UTC(), "revoked_reason": reason}).Error
+	return apperr.FromDB(err, "Session")
+}
@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"avenger/internal/apperr"
+	"avenger/internal/domain"
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type TwoFactorRepository interface {
+	GetTOTP(ctx context.Context, userID uint) (*domain.TOTPCredential, error)
+	SavePending(ctx context.Context, cred *domain.TOTPCredential) error
+	Confirm(ctx context.Context, userID uint, step int64, codes []domain.RecoveryCode) (bool, error)
+	UseStep(ctx context.Context, userID uint, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userID uint, hash string) (bool, error)
+	ReplaceRecoveryCodes(ctx context.Context, userID uint, codes []domain.RecoveryCode) error
+	Delete(ctx context.Context, userID uint) error
+}
+
+type twoFactorRepository struct {
+	DB *gorm.DB
+}
+
+func NewTwoFactorRepository(db *gorm.DB) TwoFactorRepository {
+	return &twoFactorRepository{DB: db}
+}
+
+func (r *twoFactorRepository) GetTOTP(ctx context.Context, userID uint) (*domain.TOTPCredential, error) {
+	var cred domain.TOTPCredential
+	if err := r.DB.WithContext(ctx).Where("user_id = ?", userID).First(&cred).Error; err != nil {
+		return nil, apperr.FromDB(err, "Two-factor credential")
+	}
+	return &cred, nil
+}
+
+// SavePending stores a new unconfirmed secret, replacing a previous
+// unconfirmed one. A confirmed credential is never overwritten.
+func (r *twoFactorRepository) SavePending(ctx context.Context, cred *domain.TOTPCredential) error {
+	result := r.DB.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"secret", "last_used_step", "created_at"}),
+		Where:     clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "user_totp.confirmed_at IS NULL"}}},
+	}).Create(cred)
+	if result.Error != nil {
+		return apperr.FromDB(result.Error, "Two-factor credential")
+	}
+	if result.RowsAffected == 0 {
+		return apperr.Conflict("Two-factor authentication is already enabled").WithCode(apperr.CodeTwoFactorEnabled)
+	}
+	return nil
+}
+
+// Confirm enables the pending credential, accepting step as its first code,
+// and replaces the user's recovery codes. It returns false when there was no
+// pending credential.
+func (r *twoFactorRepository) Confirm(ctx context.Context, userID uint, step int64, codes []domain.RecoveryCode) (bool, error) {
+	confirmed := false
+	err := r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&domain.TOTPCredential{}).
+			Where("user_id = ? AND confirmed_at IS NULL AND last_used_step < ?", userID, step).
+			Updates(map[string]any{"confirmed_at": time.Now().UTC(), "last_used_step": step})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		confirmed = true
+		return replaceRecoveryCodes(tx, userID, codes)
+	})
+	return confirmed, apperr.FromDB(err, "Two-factor credential")
+}
+
+// UseStep records step as used. It returns false when step, or a later one,
+// was already used, which makes each code single use even under concurrent
+// requests.
+func (r *twoFactorRepository) UseStep(ctx context.Context, userID uint, step int64) (bool, error) {
+	result := r.DB.WithContext(ctx).Model(&domain.TOTPCredential{}).
+		Where("user_id = ? AND last_used_step < ?", userID, step).
+		Update("last_used_step", step)
+	if result.Error != nil {
+		return false, apperr.FromDB(result.Error, "Two-factor credential")
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// UseRecoveryCode spends an unused recovery code; false when there is none
+// with that hash.
+func (r *twoFactorRepository) UseRecoveryCode(ctx context.Context, userID uint, hash string) (bool, error) {
+	result := r.DB.WithContext(ctx).Model(&domain.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hash).
+		Update("used_at", time.Now().UTC())
+	if result.Error != nil {
+		return false, apperr.FromDB(result.Error, "Recovery code")
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (r *twoFactorRepository) ReplaceRecoveryCodes(ctx context.Context, userID uint, codes []domain.RecoveryCode) error {
+	err := r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return replaceRecoveryCodes(tx, userID, codes)
+	})
+	return apperr.FromDB(err, "Recovery code")
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID uint, codes []domain.RecoveryCode) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&domain.RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	return tx.Create(&codes).Error
+}
+
+// Delete removes the user's credential and recovery codes.
+func (r *twoFactorRepository) Delete(ctx context.Context, userID uint) error {
+	err := r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&domain.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&domain.TOTPCredential{}).Error
+	})
+	return apperr.FromDB(err, "Two-factor credential")
+}
@@ -1,14 +1,25 @@
 package repository
 
 import (
+	"avenger/internal/apperr"
 	"avenger/internal/domain"
+	"context"
+	"strings"
 
 	"gorm.io/gorm"
 )
 
 type UserRepository interface {
-	Register(user *domain.User) error
-	GetByEmail(email string) (*domain.User, error)
+	Register(ctx context.Context, user *domain.User) error
+	GetByEmail(ctx context.Context, email string) (*domain.User, error)
+	GetByID(ctx context.Context, id uint) (*domain.User, error)
+	GetAll(ctx context.Context, q domain.UserQuery) ([]domain.User, int, error)
+	Update(ctx context.Context, user *domain.User, fields []string) error
+	UpdateProfile(ctx context.Context, user *domain.User) error
+	UpdatePassword(ctx context.Context, id uint, hash string) error
+	MarkEmailVerified(ctx context.Context, id uint) error
+	CountByRole(ctx context.Context, role string) (int, error)
+	Delete(ctx context.Context, id uint) error
 }
 
 type userRepository struct {
@@ -19,17 +30,145 @@ func NewUserRepository(db *gorm.DB) UserRepository {
 	return &userRepository{DB: db}
 }
 
-func (r *userRepository) Register(user *domain.User) error {
-	return r.DB.Create(user).Error
+var userSortColumns = map[string]string{
+	"id":         "id",
+	"email":      "email",
+	"full_name":  "full_name",
+	"role":       "role",
+	"created_at": "created_at",
 }
 
-func (r *userRepository) GetByEmail(email string) (*domain.User, error) {
+func (r *userRepository) Register(ctx context.Context, user *domain.User) error {
+	return apperr.FromDB(r.DB.WithContext(ctx).Create(user).Error, "User")
+}
+
+func (r *userRepository) GetByEmail(ctx context.Context, email string) (*domain.User, error) {
 	var user domain.User
-	if err := r.DB.Where("email = ?", email).First(&user).Error; err != nil {
+	if err := r.DB.WithContext(ctx).Where("email = ?", email).First(&user).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return nil, nil
 		}
-		return nil, err
+		return nil, apperr.FromDB(err, "User")
 	}
 	return &user, nil
 }
+
+func (r *userRepository) GetByID(ctx context.Context, id uint) (*domain.User, error) {
+	var user domain.User
+	if err := r.DB.WithContext(ctx).First(&user, id).Error; err != nil {
+		return nil, apperr.FromDB(err, "User")
+	}
+	return &user, nil
+}
+
+// GetAll returns one page of users matching q and the total number of
+// matches. Soft-deleted users are never included.
+func (r *userRepository) GetAll(ctx context.Context, q domain.UserQuery) ([]domain.User, int, error) {
+	db := r.DB.WithContext(ctx).Model(&domain.User{})
+
+	if q.Search != "" {
+		pattern := "%" + escapeLike(strings.ToLower(q.Search)) + "%"
+		db = db.Where("(LOWER(email) LIKE ? ESCAPE '\\' OR LOWER(full_name) LIKE ? ESCAPE '\\')", pattern, pattern)
+	}
+	if q.Role != "" {
+		db = db.Where("role = ?", q.Role)
+	}
+	if q.Disabled != nil {
+		db = db.Where("disabled = ?", *q.Disabled)
+	}
+
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, apperr.FromDB(err, "User")
+	}
+
+	for _, s := range q.Sort {
+		if col, ok := userSortColumns[s.Field]; ok {
+			dir := " ASC"
+			if s.Desc {
+				dir = " DESC"
+			}
+			db = db.Order(col + dir)
+		}
+	}
+
+	var users []domain.User
+	err := db.Order("id ASC").
+		Offset((q.Page - 1) * q.PerPage).
+		Limit(q.PerPage).
+		Find(&users).Error
+	if err != nil {
+		return nil, 0, apperr.FromDB(err, "User")
+	}
+	return users, int(total), nil
+}
+
+// Update writes the administrator editable fields of user named in fields,
+// by JSON member. Columns not named are left alone, so a patch cannot write
+// back a role or disabled state another administrator changed meanwhile.
+func (r *userRepository) Update(ctx context.Context, user *domain.User, fields []string) error {
+	columns := map[string]any{
+		"full_name":  user.FullName,
+		"age":        user.Age,
+		"occupation": user.Occupation,
+		"role":       user.Role,
+		"disabled":   user.Disabled,
+	}
+	values := make(map[string]any, len(fields))
+	for _, f := range fields {
+		if v, ok := columns[f]; ok {
+			values[f] = v
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return r.updateColumns(ctx, user.ID, values)
+}
+
+// UpdateProfile writes only the fields users may edit about themselves, so
+// it cannot race with an administrator changing role or account state.
+func (r *userRepository) UpdateProfile(ctx context.Context, user *domain.User) error {
+	return r.updateColumns(ctx, user.ID, map[string]any{
+		"full_name":  user.FullName,
+		"age":        user.Age,
+		"occupation": user.Occupation,
+	})
+}
+
+func (r *userRepository) UpdatePassword(ctx context.Context, id uint, hash string) error {
+	return r.updateColumns(ctx, id, map[string]any{"password": hash})
+}
+
+func (r *userRepository) MarkEmailVerified(ctx context.Context, id uint) error {
+	return r.updateColumns(ctx, id, map[string]any{"email_verified_at": gorm.Expr("COALESCE(email_verified_at, NOW())")})
+}
+
+func (r *userRepository) CountByRole(ctx context.Context, role string) (int, error) {
+	var count int64
+	err := r.DB.WithContext(ctx).Model(&domain.User{}).Where("role = ?", role).Count(&count).Error
+	return int(count), apperr.FromDB(err, "User")
+}
+
+func (r *userRepository) updateColumns(ctx context.Context, id uint, values map[string]any) error {
+	result := r.DB.WithContext(ctx).Model(&domain.User{}).Where("id = ?", id).Updates(values)
+	if result.Error != nil {
+		return apperr.FromDB(result.Error, "User")
+	}
+	if result.RowsAffected == 0 {
+		return apperr.FromDB(gorm.ErrRecordNotFound, "User")
+	}
+	return nil
+}
+
+// Delete soft-deletes the user through gorm.Model.DeletedAt.
+func (r *userRepository) Delete(ctx context.Context, id uint) error {
+	result := r.DB.WithContext(ctx).Delete(&domain.User{}, id)
+	if result.Error != nil {
+		return apperr.FromDB(result.Error, "User")
+	}
+	if result.RowsAffected == 0 {
+		return apperr.FromDB(gorm.ErrRecordNotFound, "User")
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"avenger/internal/apperr"
+	"avenger/internal/domain"
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type UserTokenRepository interface {
+	Create(ctx context.Context, token *domain.UserToken) error
+	Consume(ctx context.Context, hash, purpose string) (*domain.UserToken, error)
+	IssuedSince(ctx context.Context, userID uint, purpose string, since time.Time) (int, *time.Time, error)
+}
+
+type userTokenRepository struct {
+	DB *gorm.DB
+}
+
+func NewUserTokenRepository(db *gorm.DB) UserTokenRepository {
+	return &userTokenRepository{DB: db}
+}
+
+// Create stores token and expires the user's other unused tokens of the
+// same purpose, so only the most recently mailed link works.
+func (r *userTokenRepository) Create(ctx context.Context, token *domain.UserToken) error {
+	err := r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&domain.UserToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", token.UserID, token.Purpose, time.Now().UTC()).
+			Update("expires_at", time.Now().UTC()).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(token).Error
+	})
+	return apperr.FromDB(err, "Token")
+}
+
+// Consume marks an unused, unexpired token as used and returns it. The
+// check and the update are a single statement, so a token can only be
+// consumed once.
+func (r *userTokenRepository) Consume(ctx context.Context, hash, purpose string) (*domain.UserToken, error) {
+	var tokens []domain.UserToken
+	now := time.Now().UTC()
+	err := r.DB.WithContext(ctx).Model(&tokens).
+		Clauses(clause.Returning{}).
+		Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", hash, purpose, now).
+		Update("used_at", now).Error
+	if err != nil {
+		return nil, apperr.FromDB(err, "Token")
+	}
+	if len(tokens) == 0 {
+		return nil, apperr.FromDB(gorm.ErrRecordNotFound, "Token")
+	}
+	return &tokens[0], nil
+}
+
+// IssuedSince counts the user's tokens of purpose created after since and
+// returns when the latest of them was created.
+func (r *userTokenRepository) IssuedSince(ctx context.Context, userID uint, purpose string, since time.Time) (int, *time.Time, error) {
+	var row struct {
+		Count int
+		Last  *time.Time
+	}
+	err := r.DB.WithContext(ctx).Model(&domain.UserToken{}).
+		Select("COUNT(*) AS count, MAX(created_at) AS last").
+		Where("user_id = ? AND purpose = ? AND created_at > ?", userID, purpose, since).
+		Scan(&row).Error
+	if err != nil {
+		return 0, nil, apperr.FromDB(err, "Token")
+	}
+	return row.Count, row.Last, nil
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"avenger/internal/apperr"
+	"avenger/internal/domain"
+	"avenger/internal/repository"
+	"avenger/pkg/debug"
+	"avenger/pkg/utils"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// APIKeyPrefix starts every key so leaked keys are easy to grep for.
+	APIKeyPrefix            = "avk_"
+	DefaultAPIKeyPerPage    = 20
+	apiKeyVisibleLength     = len(APIKeyPrefix) + 8
+	apiKeyLastUsedPrecision = time.Minute
+)
+
+// APIKeyService mints, lists, revokes and authenticates API keys.
+type APIKeyService interface {
+	Create(ctx context.Context, actorID int, actorPerms []string, req domain.APIKeyRequest) (*domain.APIKey, string, error)
+	GetAll(ctx context.Context, page, perPage int) ([]domain.APIKey, int, error)
+	Revoke(ctx context.Context, id int) error
+	Authenticate(ctx context.Context, key string) (*utils.JWTClaim, error)
+}
+
+type apiKeyService struct {
+	repo repository.APIKeyRepository
+}
+
+func NewAPIKeyService(r repository.APIKeyRepository) APIKeyService {
+	return &apiKeyService{repo: r}
+}
+
+// Create mints a key whose scopes are a subset of the creator's own
+// permissions and returns it with the plain key, which is shown only once.
+func (s *apiKeyService) Create(ctx context.Context, actorID int, actorPerms []string, req domain.APIKeyRequest) (*domain.APIKey, string, error) {
+	debug.LogDebugContext(ctx, "User %d creating API key %q with scopes %v", actorID, req.Name, req.Scopes)
+
+	scopes := make([]string, 0, len(req.Scopes))
+	for _, scope := range req.Scopes {
+		// Keys cannot manage users, so user and key administration always
+		// needs a person behind it.
+		if scope == domain.PermUserManage {
+			return nil, "", apperr.Validation("Validation failed", map[string]string{
+				"scopes": scope + " cannot be granted to an API key",
+			})
+		}
+		if !slices.Contains(actorPerms, scope) {
+			return nil, "", apperr.Validation("Validation failed", map[string]string{
+				"scopes": fmt.Sprintf("%s is not a permission you hold", scope),
+			})
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	secret, err := utils.RandomToken(32)
+	if err != nil {
+		return nil, "", fmt.Errorf("create API key: %w", err)
+	}
+	id, err := utils.RandomToken(6)
+	if err != nil {
+		return nil, "", fmt.Errorf("create API key: %w", err)
+	}
+	raw := APIKeyPrefix + id + secret
+
+	key := &domain.APIKey{
+		Name:      strings.TrimSpace(req.Name),
+		Prefix:    raw[:apiKeyVisibleLength],
+		KeyHash:   utils.HashToken(raw),
+		Scopes:    scopes,
+		CreatedBy: uint(actorID),
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := time.Now().UTC().AddDate(0, 0, req.ExpiresInDays)
+		key.ExpiresAt = &expiresAt
+	}
+
+	if err := s.repo.Create(ctx, key); err != nil {
+		debug.ErrorDebugContext(ctx, "Error while storing API key: %v", err)
+		return nil, "", fmt.Errorf("create API key: %w", err)
+	}
+
+	debug.LogDebugContext(ctx, "Created API key %d (%s)", key.ID, key.Prefix)
+	return key, raw, nil
+}
+
+func (s *apiKeyService) GetAll(ctx context.Context, page, perPage int) ([]domain.APIKey, int, error) {
+	keys, total, err := s.repo.GetAll(ctx, page, perPage)
+	if err != nil {
+		debug.ErrorDebugContext(ctx, "Error while fetching API keys: %v", err)
+		return nil, 0, fmt.Errorf("get API keys: %w", err)
+	}
+	return keys, total, nil
+}
+
+func (s *apiKeyService) Revoke(ctx context.Context, id int) error {
+	debug.LogDebugContext(ctx, "Revoking API key %d", id)
+	if id <= 0 {
+		return invalidUserID()
+	}
+	if err := s.repo.Revoke(ctx, uint(id)); err != nil {
+		debug.ErrorDebugContext(ctx, "Error while revoking API key %d: %v", id, err)
+		return fmt.Errorf("revoke API key %d: %w", id, err)
+	}
+	return nil
+}
+
+// Authenticate returns the claims of an active key: its scopes as
+// permissions and no user.
+func (s *apiKeyService) Authenticate(ctx context.Context, raw string) (*utils.JWTClaim, error) {
+	invalid := apperr.Unauthorized("Invalid, expired or revoked API key").WithCode(apperr.CodeInvalidAPIKey)
+	if !strings.HasPrefix(raw, APIKeyPrefix) {
+		return nil, invalid
+	}
+
+	key, err := s.repo.GetByHash(ctx, utils.HashToken(raw))
+	if err != nil {
+		if apperr.IsNotFound(err) {
+			return nil, invalid
+		}
+		return nil, fmt.Errorf("authenticate API key: %w", err)
+	}
+
+	now := time.Now().UTC()
+	if !key.Active(now) {
+		debug.LogDebugContext(ctx, "Inactive API key %d presented", key.ID)
+		return nil, invalid
+	}
+
+	if err := s.repo.Touch(ctx, key.ID, now, apiKeyLastUsedPrecision); err != nil {
+		// Losing a last-used timestamp must not fail the request.
+		debug.ErrorDebugContext(ctx, "Failed to record use of API key %d: %v", key.ID, err)
+	}
+
+	return &utils.JWTClaim{APIKeyID: key.ID, Permissions: key.Scopes}, nil
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"avenger/internal/apperr"
+	"avenger/internal/domain"
+	"avenger/internal/repository"
+	"avenger/pkg/debug"
+	"avenger/pkg/mailer"
+	"avenger/pkg/utils"
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultEmailVerificationTTL = 24 * time.Hour
+
+	// Resend throttling per user.
+	VerificationResendInterval = time.Minute
+	VerificationResendPerHour  = 5
+)
+
+type EmailVerificationService interface {
+	Send(ctx context.Context, user *domain.User) error
+	Resend(ctx context.Context, email string) error
+	Verify(ctx context.Context, token string) error
+}
+
+type emailVerificationService struct {
+	users     repository.UserRepository
+	tokens    repository.UserTokenRepository
+	mailer    mailer.Mailer
+	verifyURL string
+	ttl       time.Duration
+}
+
+// NewEmailVerificationService mails links of the form verifyURL?token=...
+// that stay valid for ttl.
+func NewEmailVerificationService(users repository.UserRepository, tokens repository.UserTokenRepository, m mailer.Mailer, verifyURL string, ttl time.Duration) EmailVerificationService {
+	if ttl <= 0 {
+		ttl = DefaultEmailVerificationTTL
+	}
+	return &emailVerificationService{users: users, tokens: tokens, mailer: m, verifyURL: verifyURL, ttl: ttl}
+}
+
+// Send issues a verification token for user and mails it in the background.
+func (s *emailVerificationService) Send(ctx context.Context, user *domain.User) error {
+	debug.LogDebugContext(ctx, "Sending email verification to user ID: %d", user.ID)
+
+	raw, err := utils.RandomToken(32)
+	if err != nil {
+		return fmt.Errorf("send verification: %w", err)
+	}
+
+	token := &domain.UserToken{
+		UserID:    user.ID,
+		Purpose:   domain.TokenEmailVerification,
+		TokenHash: utils.HashToken(raw),
+		ExpiresAt: time.Now().UTC().Add(s.ttl),
+	}
+	if err := s.tokens.Create(ctx, token); err != nil {
+		debug.ErrorDebugContext(ctx, "Error while storing verification token for user %d: %v", user.ID, err)
+		return fmt.Errorf("send verification: %w", err)
+	}
+
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening the link below. It expires in %s.\n\n%s\n\nIf you did not create an account, you can ignore this email.\n",
+			user.FullName, s.ttl, s.link(raw)),
+	}
+	go func() {
+		if err := s.mailer.Send(msg); err != nil {
+			debug.ErrorDebugContext(ctx, "Failed to send verification email to user %d: %v", user.ID, err)
+		}
+	}()
+
+	return nil
+}
+
+// Resend mails a new verification link to an unverified account. Unknown,
+// verified and throttled addresses are silently ignored so the response
+// does not reveal which addresses are registered. All return right after
+// the lookup: the throttle check and Send run in the background, so the
+// response time does not tell them apart either.
+func (s *emailVerificationService) Resend(ctx context.Context, email string) error {
+	email = strings.TrimSpace(email)
+	debug.LogDebugContext(ctx, "Verification resend requested for: %s", email)
+
+	user, err := s.users.GetByEmail(ctx, email)
+	if err != nil {
+		return fmt.Errorf("resend verification: %w", err)
+	}
+	if user == nil || user.Disabled || user.EmailVerified() {
+		debug.LogDebugContext(ctx, "Verification resend not applicable, not sending")
+		return nil
+	}
+
+	go s.resend(context.WithoutCancel(ctx), user)
+	return nil
+}
+
+// resend sends user a new link unless they asked too often. Failures are
+// only logged, as Resend has already answered.
+func (s *emailVerificationService) resend(ctx context.Context, user *domain.User) {
+	count, last, err := s.tokens.IssuedSince(ctx, user.ID, domain.TokenEmailVerification, time.Now().UTC().Add(-time.Hour))
+	if err != nil {
+		debug.ErrorDebugContext(ctx, "Error while counting verification tokens of user %d: %v", user.ID, err)
+		return
+	}
+	if count >= VerificationResendPerHour || (last != nil && time.Since(*last) < VerificationResendInterval) {
+		debug.ErrorDebugContext(ctx, "Verification resend throttled for user %d (%d in the last hour)", user.ID, count)
+		return
+	}
+
+	if err := s.Send(ctx, user); err != nil {
+		debug.ErrorDebugContext(ctx, "Failed to resend verification to user %d: %v", user.ID, err)
+	}
+}
+
+// Verify consumes a verification token and marks the address as verified.
+func (s *emailVerificationService) Verify(ctx context.Context, token string) error {
+	consumed, err := s.tokens.Consume(ctx, utils.HashToken(token), domain.TokenEmailVerification)
+	if err != nil {
+		if apperr.IsNotFound(err) {
+			debug.LogDebugContext(ctx, "Invalid or expired verification token")
+			return apperr.Validation("Invalid or expired verification token", map[string]string{
+				"token": "token is invalid, expired or already used",
+			}).WithCode(apperr.CodeInvalidVerifyToken)
+		}
+		return fmt.Errorf("verify email: %w", err)
+	}
+
+	if err := s.users.MarkEmailVerified(ctx, consumed.UserID); err != nil {
+		debug.ErrorDebugContext(ctx, "Error while marking user %d verified: %v", consumed.UserID, err)
+		return fmt.Errorf("verify email: %w", err)
+	}
+
+	debug.LogDebugContext(ctx, "Email verified for user ID: %d", consumed.UserID)
+	return nil
+}
+
+func (s *emailVerificationService) link(token string) string {
+	sep := "?"
+	if strings.Contains(s.verifyURL, "?") {
+		sep = "&"
+	}
+	return s.verifyURL + sep + "token=" + url.QueryEscape(token)
+}
@@ -1,22 +1,32 @@
 package service
 
 import (
+	"avenger/internal/apperr"
 	"avenger/internal/domain"
 	"avenger/internal/repository"
 	"avenger/pkg/debug"
-	"database/sql"
-	"errors"
+	"context"
+	"fmt"
 	"strings"
 
 	"github.com/go-playground/validator"
 )
 
+const (
+	DefaultInventoryPerPage = 20
+	MaxInventoryPerPage     = 100
+)
+
 type InventoryService interface {
-	GetAll() ([]domain.Inventory, error)
-	GetByID(id int) (*domain.Inventory, error)
-	Create(inv domain.Inventory) (int, error)
-	Update(id int, inv domain.Inventory) error
-	Delete(id int) error
+	GetAll(ctx context.Context, q domain.InventoryQuery) ([]domain.Inventory, int, error)
+	GetByID(ctx context.Context, id int) (*domain.Inventory, error)
+	Create(ctx context.Context, inv domain.Inventory) (int, error)
+	Update(ctx context.Context, id int, inv domain.Inventory) (int, error)
+	Patch(ctx context.Context, id int, inv domain.Inventory, fields []string) (int, error)
+	Delete(ctx context.Context, id, version int) error
+	AdjustStock(ctx context.Context, id int, m domain.StockMovement) (*domain.StockMovement, error)
+	GetMovements(ctx context.Context, id, page, perPage int) ([]domain.StockMovement, int, error)
+	Reconcile(ctx context.Context, id int) (*domain.StockReconciliation, error)
 }
 
 type inventoryService struct {
@@ -28,140 +38,277 @@ func NewInventoryService(r repository.InventoryRepository) InventoryService {
 	return &inventoryService{repo: r, validate: validator.New()}
 }
 
-func (s *inventoryService) GetAll() ([]domain.Inventory, error) {
-	debug.LogDebug("Fetching all inventories")
+func (s *inventoryService) GetAll(ctx context.Context, q domain.InventoryQuery) (_ []domain.Inventory, _ int, err error) {
+	ctx, span := tracer.Start(ctx, "InventoryService.GetAll")
+	defer func() { endSpan(span, err) }()
+
+	debug.LogDebugContext(ctx, "Fetching inventories: page=%d per_page=%d", q.Page, q.PerPage)
+
+	if q.Page <= 0 {
+		q.Page = 1
+	}
+	if q.PerPage <= 0 {
+		q.PerPage = DefaultInventoryPerPage
+	}
+	if q.PerPage > MaxInventoryPerPage {
+		q.PerPage = MaxInventoryPerPage
+	}
+	q.CodePrefix = strings.ToUpper(strings.TrimSpace(q.CodePrefix))
+	q.Name = strings.TrimSpace(q.Name)
 
-	inventories, err := s.repo.GetAll()
+	inventories, total, err := s.repo.GetAll(ctx, q)
 	if err != nil {
-		debug.ErrorDebug("Failed to fetch inventory: %v", err)
-		return nil, errors.New("failed to retrieve from database")
+		debug.ErrorDebugContext(ctx, "Failed to fetch inventory: %v", err)
+		return nil, 0, fmt.Errorf("list inventories: %w", err)
 	}
 
-	debug.LogDebug("Successfully fetched %d inventories", len(inventories))
-	return inventories, nil
+	debug.LogDebugContext(ctx, "Successfully fetched %d of %d inventories", len(inventories), total)
+	return inventories, total, nil
 }
 
-func (s *inventoryService) GetByID(id int) (*domain.Inventory, error) {
-	debug.LogDebug("Fetching inventory with ID: %d", id)
+func (s *inventoryService) GetByID(ctx context.Context, id int) (_ *domain.Inventory, err error) {
+	ctx, span := tracer.Start(ctx, "InventoryService.GetByID")
+	defer func() { endSpan(span, err) }()
+
+	debug.LogDebugContext(ctx, "Fetching inventory with ID: %d", id)
 	if id <= 0 {
-		debug.ErrorDebug("invalid intentory ID: %d", id)
-		return nil, errors.New("invalid inventory ID")
+		debug.ErrorDebugContext(ctx, "invalid intentory ID: %d", id)
+		return nil, invalidInventoryID()
 	}
 
-	inventory, err := s.repo.GetByID(id)
+	inventory, err := s.repo.GetByID(ctx, id)
 	if err != nil {
-		debug.LogDebug("Database error while fetching inventory ID %d: %v", id, err)
-		return nil, errors.New("failed to retrieve inventory from database")
-	}
-
-	if inventory == nil {
-		debug.LogDebug("inventory not found for ID: %d", id)
-		return nil, errors.New("inventory not found")
+		debug.LogDebugContext(ctx, "Error while fetching inventory ID %d: %v", id, err)
+		return nil, fmt.Errorf("get inventory %d: %w", id, err)
 	}
 
-	debug.LogDebug("Successfully fetched inventory: %d", id)
+	debug.LogDebugContext(ctx, "Successfully fetched inventory: %d", id)
 	return inventory, nil
 }
 
-func (s *inventoryService) Create(inv domain.Inventory) (int, error) {
-	debug.LogDebug("Creating new inventory")
-	if err := s.validate.Struct(inv); err != nil {
-		debug.ErrorDebug("validation error", err)
-		return 0, errors.New("invalid inventory data")
-	}
+func (s *inventoryService) Create(ctx context.Context, inv domain.Inventory) (_ int, err error) {
+	ctx, span := tracer.Start(ctx, "InventoryService.Create")
+	defer func() { endSpan(span, err) }()
 
-	if inv.Stock < 0 {
-		debug.ErrorDebug("invalid stock value")
-		return 0, errors.New("stock cannot be negative")
-	}
-
-	if inv.Status != "active" && inv.Status != "broken" {
-		debug.ErrorDebug("invalid status value")
-		return 0, errors.New("status must be active or broken")
+	debug.LogDebugContext(ctx, "Creating new inventory")
+	if err := s.validate.Struct(inv); err != nil {
+		debug.ErrorDebugContext(ctx, "validation error: %v", err)
+		return 0, apperr.FromValidation(err)
 	}
 
 	inv.Code = strings.ToUpper(strings.TrimSpace(inv.Code))
 	inv.Name = strings.TrimSpace(inv.Name)
 	inv.Description = strings.TrimSpace(inv.Description)
 
-	id, err := s.repo.Create(inv)
+	id, err := s.repo.Create(ctx, inv)
 	if err != nil {
-		if err == sql.ErrConnDone {
-			debug.ErrorDebug("Duplicate inventory code")
-			return 0, errors.New("inventory code already exists")
-		}
-		debug.ErrorDebug("Database error while creating inventory")
-		return 0, errors.New("failed to create inventory in database")
+		debug.ErrorDebugContext(ctx, "Error while creating inventory %s: %v", inv.Code, err)
+		return 0, fmt.Errorf("create inventory %s: %w", inv.Code, err)
 	}
 
-	debug.LogDebug("successfully created inventory")
+	debug.LogDebugContext(ctx, "successfully created inventory")
 	return id, nil
 }
 
-func (s *inventoryService) Update(id int, inv domain.Inventory) error {
-	debug.LogDebug("Updating inventory ID")
+// Update overwrites the inventory and returns its new version. A non-zero
+// inv.Version is the version the caller expects to replace.
+func (s *inventoryService) Update(ctx context.Context, id int, inv domain.Inventory) (_ int, err error) {
+	ctx, span := tracer.Start(ctx, "InventoryService.Update")
+	defer func() { endSpan(span, err) }()
+
+	debug.LogDebugContext(ctx, "Updating inventory ID %d", id)
 	if id <= 0 {
-		debug.ErrorDebug("invalid inventory id for update")
-		return errors.New("invalid inventory id")
+		debug.ErrorDebugContext(ctx, "invalid inventory id for update")
+		return 0, invalidInventoryID()
 	}
 
 	inv.ID = id
 	if err := s.validate.Struct(inv); err != nil {
-		debug.ErrorDebug("validation failed for update")
-		return errors.New("invalid inventory data" + err.Error())
+		debug.ErrorDebugContext(ctx, "validation failed for update: %v", err)
+		return 0, apperr.FromValidation(err)
+	}
+
+	return s.save(ctx, id, inv)
+}
+
+// Patch writes a partially modified inventory. Only the JSON members listed
+// in fields were supplied by the caller, so only those are validated.
+func (s *inventoryService) Patch(ctx context.Context, id int, inv domain.Inventory, fields []string) (_ int, err error) {
+	ctx, span := tracer.Start(ctx, "InventoryService.Patch")
+	defer func() { endSpan(span, err) }()
+
+	debug.LogDebugContext(ctx, "Patching inventory ID %d fields %v", id, fields)
+	if id <= 0 {
+		debug.ErrorDebugContext(ctx, "invalid inventory id for patch")
+		return 0, invalidInventoryID()
 	}
 
-	if inv.Stock < 0 {
-		debug.ErrorDebug("invalid stock value for update")
-		return errors.New("stock cannot be negative")
+	structFields := make([]string, 0, len(fields))
+	errs := map[string]string{}
+	for _, f := range fields {
+		name, ok := domain.InventoryPatchFields[f]
+		if !ok {
+			errs[f] = f + " cannot be changed"
+			continue
+		}
+		structFields = append(structFields, name)
+	}
+	if len(errs) > 0 {
+		debug.ErrorDebugContext(ctx, "Fields cannot be patched: %v", errs)
+		return 0, apperr.Validation("Validation failed", errs)
 	}
 
-	if inv.Status != "active" && inv.Status != "broken" {
-		debug.ErrorDebug("Invalid status value for update: %s", inv.Status)
-		return errors.New("status must be either 'active' or 'broken'")
+	inv.ID = id
+	if err := s.validate.StructPartial(inv, structFields...); err != nil {
+		debug.ErrorDebugContext(ctx, "validation failed for patch: %v", err)
+		return 0, apperr.FromValidation(err)
 	}
 
+	return s.save(ctx, id, inv)
+}
+
+func (s *inventoryService) save(ctx context.Context, id int, inv domain.Inventory) (int, error) {
 	inv.Code = strings.ToUpper(strings.TrimSpace(inv.Code))
 	inv.Name = strings.TrimSpace(inv.Name)
 	inv.Description = strings.TrimSpace(inv.Description)
 
-	err := s.repo.Update(id, inv)
+	version, err := s.repo.Update(ctx, id, inv)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			debug.ErrorDebug("Inventory not found for update: ID %d", id)
-			return errors.New("inventory not found")
-		}
-		if err == sql.ErrConnDone {
-			debug.ErrorDebug("Duplicate inventory code on update: %s", inv.Code)
-			return errors.New("inventory code already exists")
-		}
-		debug.LogDebug("Database error while updating inventory ID %d: %v", id, err)
-		return errors.New("failed to update inventory in database")
+		debug.ErrorDebugContext(ctx, "Error while updating inventory ID %d (expected version %d): %v", id, inv.Version, err)
+		return 0, fmt.Errorf("update inventory %d: %w", id, err)
 	}
 
-	debug.LogDebug("Successfully updated inventory ID: %d", id)
+	debug.LogDebugContext(ctx, "Successfully updated inventory ID: %d to version %d", id, version)
+	return version, nil
+}
+
+// Delete removes the inventory. A non-zero version must match the current one.
+func (s *inventoryService) Delete(ctx context.Context, id, version int) (err error) {
+	ctx, span := tracer.Start(ctx, "InventoryService.Delete")
+	defer func() { endSpan(span, err) }()
+
+	debug.LogDebugContext(ctx, "Deleting inventory")
+
+	if id <= 0 {
+		debug.ErrorDebugContext(ctx, "invalid inventory for deletion")
+		return invalidInventoryID()
+	}
+
+	if err := s.repo.Delete(ctx, id, version); err != nil {
+		debug.ErrorDebugContext(ctx, "Error while deleting inventory ID %d (expected version %d): %v", id, version, err)
+		return fmt.Errorf("delete inventory %d: %w", id, err)
+	}
+
+	debug.LogDebugContext(ctx, "Successfully deleted inventory")
+
 	return nil
 }
 
-func (s *inventoryService) Delete(id int) error {
-	debug.LogDebug("Deleting inventory")
+func (s *inventoryService) AdjustStock(ctx context.Context, id int, m domain.StockMovement) (_ *domain.StockMovement, err error) {
+	ctx, span := tracer.Start(ctx, "InventoryService.AdjustStock")
+	defer func() { endSpan(span, err) }()
 
+	debug.LogDebugContext(ctx, "Adjusting stock for inventory ID %d by %d (%s)", id, m.Delta, m.Reason)
 	if id <= 0 {
-		debug.ErrorDebug("invalid inventory for deletion")
-		return errors.New("invalid inventory id")
+		debug.ErrorDebugContext(ctx, "invalid inventory id for stock adjustment")
+		return nil, invalidInventoryID()
 	}
 
-	err := s.repo.Delete(id)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			debug.ErrorDebug("inventory not found for deletion")
-			return errors.New("inventory not found")
+	if err := s.validate.Struct(m); err != nil {
+		debug.ErrorDebugContext(ctx, "validation failed for stock movement: %v", err)
+		return nil, apperr.FromValidation(err)
+	}
+
+	switch m.Reason {
+	case domain.MovementReceive:
+		if m.Delta < 0 {
+			return nil, apperr.Validation("Validation failed", map[string]string{
+				"delta": "receive must have a positive delta",
+			})
+		}
+	case domain.MovementIssue, domain.MovementScrap:
+		if m.Delta > 0 {
+			return nil, apperr.Validation("Validation failed", map[string]string{
+				"delta": m.Reason + " must have a negative delta",
+			})
 		}
-		debug.LogDebug("database error while deleting")
-		return errors.New("failed to delete inventory from database")
 	}
 
-	debug.LogDebug("Successfully deleted inventory")
+	m.InventoryID = id
+	m.Reference = strings.TrimSpace(m.Reference)
 
-	return nil
+	if err := s.repo.AdjustStock(ctx, &m); err != nil {
+		debug.ErrorDebugContext(ctx, "Error while adjusting stock for ID %d: %v", id, err)
+		return nil, fmt.Errorf("adjust stock of inventory %d: %w", id, err)
+	}
+
+	debug.LogDebugContext(ctx, "Successfully adjusted stock for inventory ID %d, stock now %d", id, m.StockAfter)
+	return &m, nil
+}
+
+func (s *inventoryService) GetMovements(ctx context.Context, id, page, perPage int) (_ []domain.StockMovement, _ int, err error) {
+	ctx, span := tracer.Start(ctx, "InventoryService.GetMovements")
+	defer func() { endSpan(span, err) }()
+
+	debug.LogDebugContext(ctx, "Fetching stock movements for inventory ID %d", id)
+
+	if _, err := s.GetByID(ctx, id); err != nil {
+		return nil, 0, err
+	}
+
+	if page <= 0 {
+		page = 1
+	}
+	if perPage <= 0 {
+		perPage = DefaultInventoryPerPage
+	}
+	if perPage > MaxInventoryPerPage {
+		perPage = MaxInventoryPerPage
+	}
+
+	movements, total, err := s.repo.GetMovements(ctx, id, page, perPage)
+	if err != nil {
+		debug.ErrorDebugContext(ctx, "Failed to fetch stock movements: %v", err)
+		return nil, 0, fmt.Errorf("list stock movements of inventory %d: %w", id, err)
+	}
+
+	debug.LogDebugContext(ctx, "Successfully fetched %d stock movements", len(movements))
+	return movements, total, nil
+}
+
+// Reconcile compares the stored stock with the ledger balance.
+func (s *inventoryService) Reconcile(ctx context.Context, id int) (_ *domain.StockReconciliation, err error) {
+	ctx, span := tracer.Start(ctx, "InventoryService.Reconcile")
+	defer func() { endSpan(span, err) }()
+
+	debug.LogDebugContext(ctx, "Reconciling stock for inventory ID %d", id)
+
+	inv, err := s.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	ledger, err := s.repo.LedgerStock(ctx, id)
+	if err != nil {
+		debug.ErrorDebugContext(ctx, "Failed to compute ledger stock: %v", err)
+		return nil, fmt.Errorf("compute ledger stock of inventory %d: %w", id, err)
+	}
+
+	rec := &domain.StockReconciliation{
+		InventoryID: id,
+		Stock:       inv.Stock,
+		LedgerStock: ledger,
+		Drift:       inv.Stock - ledger,
+	}
+	if rec.Drift != 0 {
+		debug.ErrorDebugContext(ctx, "Stock drift detected for inventory ID %d: stock=%d ledger=%d", id, inv.Stock, ledger)
+	}
+
+	return rec, nil
+}
+
+func invalidInventoryID() error {
+	return apperr.Validation("Invalid ID parameter", map[string]string{
+		"id": "ID must be a positive integer",
+	}).WithCode(apperr.CodeInvalidID)
 }
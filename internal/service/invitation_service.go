@@ -0,0 +1,129 @@
+package service
+
+import (
+	"avenger/internal/apperr"
+	"avenger/internal/domain"
+	"avenger/internal/repository"
+	"avenger/pkg/debug"
+	"avenger/pkg/mailer"
+	"avenger/pkg/utils"
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultInvitationTTL     = 7 * 24 * time.Hour
+	DefaultInvitationPerPage = 20
+)
+
+// InvitationService lets superadmins invite accounts with a given role.
+type InvitationService interface {
+	Create(ctx context.Context, actorID int, req domain.InvitationRequest) (*domain.Invitation, string, error)
+	GetAll(ctx context.Context, page, perPage int) ([]domain.Invitation, int, error)
+	Revoke(ctx context.Context, id string) error
+}
+
+type invitationService struct {
+	repo      repository.InvitationRepository
+	roles     repository.RoleRepository
+	mailer    mailer.Mailer
+	inviteURL string
+}
+
+// NewInvitationService mails links of the form inviteURL?code=... for
+// invitations bound to an email address.
+func NewInvitationService(r repository.InvitationRepository, roles repository.RoleRepository, m mailer.Mailer, inviteURL string) InvitationService {
+	return &invitationService{repo: r, roles: roles, mailer: m, inviteURL: inviteURL}
+}
+
+// Create stores an invitation and returns it along with its signed code.
+func (s *invitationService) Create(ctx context.Context, actorID int, req domain.InvitationRequest) (*domain.Invitation, string, error) {
+	debug.LogDebugContext(ctx, "User %d inviting role %s", actorID, req.Role)
+
+	exists, err := s.roles.Exists(ctx, req.Role)
+	if err != nil {
+		return nil, "", fmt.Errorf("create invitation: %w", err)
+	}
+	if !exists {
+		return nil, "", apperr.Validation("Validation failed", map[string]string{
+			"role": "role does not exist",
+		})
+	}
+
+	id, err := utils.RandomToken(16)
+	if err != nil {
+		return nil, "", fmt.Errorf("create invitation: %w", err)
+	}
+
+	ttl := DefaultInvitationTTL
+	if req.ExpiresInHours > 0 {
+		ttl = time.Duration(req.ExpiresInHours) * time.Hour
+	}
+
+	inv := &domain.Invitation{
+		ID:        id,
+		Role:      req.Role,
+		Email:     strings.TrimSpace(req.Email),
+		CreatedBy: uint(actorID),
+		ExpiresAt: time.Now().UTC().Add(ttl),
+	}
+
+	code, err := utils.GenerateInviteCode(inv.ID, &utils.InviteClaim{Role: inv.Role, Email: inv.Email}, inv.ExpiresAt)
+	if err != nil {
+		return nil, "", fmt.Errorf("sign invite code: %w", err)
+	}
+
+	if err := s.repo.Create(ctx, inv); err != nil {
+		debug.ErrorDebugContext(ctx, "Error while storing invitation: %v", err)
+		return nil, "", fmt.Errorf("create invitation: %w", err)
+	}
+
+	if inv.Email != "" {
+		msg := mailer.Message{
+			To:      inv.Email,
+			Subject: "You have been invited",
+			Body: fmt.Sprintf("Hi,\n\nYou have been invited to create an account with the %s role. The invitation expires on %s.\n\n%s\n",
+				inv.Role, inv.ExpiresAt.Format(time.RFC1123), s.link(code)),
+		}
+		go func() {
+			if err := s.mailer.Send(msg); err != nil {
+				debug.ErrorDebugContext(ctx, "Failed to send invitation %s: %v", inv.ID, err)
+			}
+		}()
+	}
+
+	debug.LogDebugContext(ctx, "Created invitation %s for role %s", inv.ID, inv.Role)
+	return inv, code, nil
+}
+
+func (s *invitationService) GetAll(ctx context.Context, page, perPage int) ([]domain.Invitation, int, error) {
+	invs, total, err := s.repo.GetAll(ctx, page, perPage)
+	if err != nil {
+		debug.ErrorDebugContext(ctx, "Error while fetching invitations: %v", err)
+		return nil, 0, fmt.Errorf("get invitations: %w", err)
+	}
+	return invs, total, nil
+}
+
+func (s *invitationService) Revoke(ctx context.Context, id string) error {
+	debug.LogDebugContext(ctx, "Revoking invitation %s", id)
+	if err := s.repo.Revoke(ctx, id); err != nil {
+		debug.ErrorDebugContext(ctx, "Error while revoking invitation %s: %v", id, err)
+		return fmt.Errorf("revoke invitation %s: %w", id, err)
+	}
+	return nil
+}
+
+func (s *invitationService) link(code string) string {
+	if s.inviteURL == "" {
+		return "Invite code: " + code
+	}
+	sep := "?"
+	if strings.Contains(s.inviteURL, "?") {
+		sep = "&"
+	}
+	return s.inviteURL + sep + "code=" + url.QueryEscape(code)
+}
@@ -0,0 +1,192 @@
+package service
+
+import (
+	"avenger/internal/apperr"
+	"avenger/internal/domain"
+	"avenger/internal/metrics"
+	"avenger/internal/repository"
+	"avenger/pkg/debug"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LockoutPolicy decides how long logins are refused after repeated
+// failures. The first FreeFailures failures cost nothing; each further one
+// doubles the wait starting from BaseDelay, and reaching MaxFailures locks
+// logins for Lockout.
+type LockoutPolicy struct {
+	MaxFailures  int
+	FreeFailures int
+	BaseDelay    time.Duration
+	Lockout      time.Duration
+}
+
+// Delay returns how long to refuse logins after the given number of
+// consecutive failures.
+func (p LockoutPolicy) Delay(failures int) time.Duration {
+	if failures >= p.MaxFailures {
+		return p.Lockout
+	}
+	if failures <= p.FreeFailures {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := p.FreeFailures + 1; i < failures && delay < p.Lockout; i++ {
+		delay *= 2
+	}
+	return min(delay, p.Lockout)
+}
+
+var (
+	DefaultAccountLockoutPolicy = LockoutPolicy{MaxFailures: 5, FreeFailures: 2, BaseDelay: time.Second, Lockout: 15 * time.Minute}
+	DefaultIPLockoutPolicy      = LockoutPolicy{MaxFailures: 20, FreeFailures: 10, BaseDelay: time.Second, Lockout: 15 * time.Minute}
+)
+
+const DefaultLoginFailureWindow = 15 * time.Minute
+
+// LoginGuardConfig configures brute-force protection. Failures older than
+// Window are forgotten.
+type LoginGuardConfig struct {
+	Account LockoutPolicy
+	IP      LockoutPolicy
+	Window  time.Duration
+}
+
+// LoginGuard throttles password guessing per account and per client IP and
+// keeps an audit trail of failed logins.
+type LoginGuard interface {
+	// Check refuses the attempt while its account or IP is backing off.
+	Check(ctx context.Context, attempt domain.LoginAttempt) error
+	// Failed records a failed attempt; attempt.Reason says why.
+	Failed(ctx context.Context, attempt domain.LoginAttempt) error
+	// Succeeded clears the account's failures.
+	Succeeded(ctx context.Context, email string) error
+	// Unlock clears the failures and lockout of a user's account.
+	Unlock(ctx context.Context, userID int) error
+}
+
+type loginGuard struct {
+	counters repository.LoginCounterStore
+	attempts repository.LoginAttemptRepository
+	users    repository.UserRepository
+	cfg      LoginGuardConfig
+}
+
+func NewLoginGuard(counters repository.LoginCounterStore, attempts repository.LoginAttemptRepository, users repository.UserRepository, cfg LoginGuardConfig) LoginGuard {
+	if cfg.Account.MaxFailures <= 0 {
+		cfg.Account = DefaultAccountLockoutPolicy
+	}
+	if cfg.IP.MaxFailures <= 0 {
+		cfg.IP = DefaultIPLockoutPolicy
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = DefaultLoginFailureWindow
+	}
+	return &loginGuard{counters: counters, attempts: attempts, users: users, cfg: cfg}
+}
+
+func (g *loginGuard) Check(ctx context.Context, attempt domain.LoginAttempt) error {
+	now := time.Now().UTC()
+
+	var wait time.Duration
+	for _, key := range []string{accountKey(attempt.Email), ipKey(attempt.IP)} {
+		counter, err := g.counters.Get(ctx, key)
+		if err != nil {
+			debug.ErrorDebugContext(ctx, "Error while reading login counter %s: %v", key, err)
+			return fmt.Errorf("check login counter: %w", err)
+		}
+		if left, locked := counter.Locked(now); locked {
+			wait = max(wait, left)
+		}
+	}
+	if wait == 0 {
+		return nil
+	}
+
+	debug.LogDebugContext(ctx, "Login refused for %s from %s, retry in %s", attempt.Email, attempt.IP, wait)
+	attempt.Reason = domain.LoginFailedLocked
+	g.audit(ctx, attempt)
+	return apperr.TooManyRequests("Too many failed login attempts, try again later", wait).WithCode(apperr.CodeLoginLocked)
+}
+
+func (g *loginGuard) Failed(ctx context.Context, attempt domain.LoginAttempt) error {
+	g.audit(ctx, attempt)
+
+	// A correct password on a disabled account is not a guess.
+	if attempt.Reason == domain.LoginFailedDisabled {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	if err := g.fail(ctx, accountKey(attempt.Email), g.cfg.Account, now); err != nil {
+		return err
+	}
+	return g.fail(ctx, ipKey(attempt.IP), g.cfg.IP, now)
+}
+
+func (g *loginGuard) fail(ctx context.Context, key string, policy LockoutPolicy, now time.Time) error {
+	failures, err := g.counters.Increment(ctx, key, now, g.cfg.Window)
+	if err != nil {
+		debug.ErrorDebugContext(ctx, "Error while counting login failure %s: %v", key, err)
+		return fmt.Errorf("count login failure: %w", err)
+	}
+
+	delay := policy.Delay(failures)
+	if delay == 0 {
+		return nil
+	}
+	debug.LogDebugContext(ctx, "Login backoff for %s after %d failures: %s", key, failures, delay)
+	if err := g.counters.Lock(ctx, key, now.Add(delay)); err != nil {
+		debug.ErrorDebugContext(ctx, "Error while locking %s: %v", key, err)
+		return fmt.Errorf("lock %s: %w", key, err)
+	}
+	return nil
+}
+
+func (g *loginGuard) Succeeded(ctx context.Context, email string) error {
+	metrics.Logins.WithLabelValues(metrics.ResultSuccess, "").Inc()
+	if err := g.counters.Reset(ctx, accountKey(email)); err != nil {
+		return fmt.Errorf("reset login counter: %w", err)
+	}
+	return nil
+}
+
+func (g *loginGuard) Unlock(ctx context.Context, userID int) error {
+	debug.LogDebugContext(ctx, "Unlocking login of user %d", userID)
+	if userID <= 0 {
+		return invalidUserID()
+	}
+
+	user, err := g.users.GetByID(ctx, uint(userID))
+	if err != nil {
+		return fmt.Errorf("unlock user %d: %w", userID, err)
+	}
+	if err := g.counters.Reset(ctx, accountKey(user.Email)); err != nil {
+		debug.ErrorDebugContext(ctx, "Error while unlocking user %d: %v", userID, err)
+		return fmt.Errorf("unlock user %d: %w", userID, err)
+	}
+	return nil
+}
+
+// audit counts and stores attempt; losing an audit row must not block
+// logins, so errors are only logged.
+func (g *loginGuard) audit(ctx context.Context, attempt domain.LoginAttempt) {
+	metrics.Logins.WithLabelValues(metrics.ResultFailure, attempt.Reason).Inc()
+
+	attempt.ID = 0
+	attempt.Email = truncate(strings.TrimSpace(attempt.Email), 100)
+	attempt.UserAgent = truncate(attempt.UserAgent, 255)
+	if err := g.attempts.Create(ctx, &attempt); err != nil {
+		debug.ErrorDebugContext(ctx, "Failed to record login attempt for %s: %v", attempt.Email, err)
+	}
+}
+
+func accountKey(email string) string {
+	return truncate("account:"+strings.ToLower(strings.TrimSpace(email)), 150)
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
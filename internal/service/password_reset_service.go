@@ -0,0 +1,146 @@
+package service
+
+import (
+	"avenger/internal/apperr"
+	"avenger/internal/domain"
+	"avenger/internal/repository"
+	"avenger/pkg/debug"
+	"avenger/pkg/mailer"
+	"avenger/pkg/utils"
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const DefaultPasswordResetTTL = time.Hour
+
+// PasswordResetService implements the forgot/reset password flow.
+type PasswordResetService interface {
+	Forgot(ctx context.Context, email string) error
+	Reset(ctx context.Context, reset domain.PasswordReset) error
+}
+
+type passwordResetService struct {
+	users      repository.UserRepository
+	tokens     repository.UserTokenRepository
+	sessions   repository.SessionRepository
+	mailer     mailer.Mailer
+	resetURL   string
+	ttl        time.Duration
+	bcryptCost int
+}
+
+// NewPasswordResetService mails links of the form resetURL?token=... that
+// stay valid for ttl, and hashes new passwords with bcryptCost.
+func NewPasswordResetService(users repository.UserRepository, tokens repository.UserTokenRepository, sessions repository.SessionRepository, m mailer.Mailer, resetURL string, ttl time.Duration, bcryptCost int) PasswordResetService {
+	if ttl <= 0 {
+		ttl = DefaultPasswordResetTTL
+	}
+	return &passwordResetService{users: users, tokens: tokens, sessions: sessions, mailer: m, resetURL: resetURL, ttl: ttl, bcryptCost: bcryptCost}
+}
+
+// Forgot mails a reset link when email belongs to an active account. It
+// returns nil for unknown or disabled accounts so callers cannot tell them
+// apart. Both return right after the lookup: the token is stored and mailed
+// in the background, so the response time does not tell them apart either.
+func (s *passwordResetService) Forgot(ctx context.Context, email string) error {
+	email = strings.TrimSpace(email)
+	debug.LogDebugContext(ctx, "Password reset requested for: %s", email)
+
+	user, err := s.users.GetByEmail(ctx, email)
+	if err != nil {
+		debug.ErrorDebugContext(ctx, "Error while fetching user for password reset: %v", err)
+		return fmt.Errorf("forgot password: %w", err)
+	}
+	if user == nil || user.Disabled {
+		debug.LogDebugContext(ctx, "Password reset for unknown or disabled account, not sending")
+		return nil
+	}
+
+	go s.issue(context.WithoutCancel(ctx), *user)
+	return nil
+}
+
+// issue stores a reset token for user and mails its link. Failures are only
+// logged, as Forgot has already answered.
+func (s *passwordResetService) issue(ctx context.Context, user domain.User) {
+	raw, err := utils.RandomToken(32)
+	if err != nil {
+		debug.ErrorDebugContext(ctx, "Error while generating password reset token for user %d: %v", user.ID, err)
+		return
+	}
+
+	token := &domain.UserToken{
+		UserID:    user.ID,
+		Purpose:   domain.TokenPasswordReset,
+		TokenHash: utils.HashToken(raw),
+		ExpiresAt: time.Now().UTC().Add(s.ttl),
+	}
+	if err := s.tokens.Create(ctx, token); err != nil {
+		debug.ErrorDebugContext(ctx, "Error while storing password reset token for user %d: %v", user.ID, err)
+		return
+	}
+	debug.LogDebugContext(ctx, "Password reset token issued for user ID: %d", user.ID)
+
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the link below to choose a new password. It expires in %s and can be used once.\n\n%s\n\nIf you did not ask for this, you can ignore this email.\n",
+			user.FullName, s.ttl, s.link(raw)),
+	}
+	if err := s.mailer.Send(msg); err != nil {
+		debug.ErrorDebugContext(ctx, "Failed to send password reset email to user %d: %v", user.ID, err)
+	}
+}
+
+// Reset consumes a reset token, sets the new password and logs the user out
+// everywhere.
+func (s *passwordResetService) Reset(ctx context.Context, reset domain.PasswordReset) error {
+	if len(reset.NewPassword) < 8 {
+		return apperr.Validation("Validation failed", map[string]string{
+			"new_password": "password minimal 8 karakter",
+		})
+	}
+
+	token, err := s.tokens.Consume(ctx, utils.HashToken(reset.Token), domain.TokenPasswordReset)
+	if err != nil {
+		if apperr.IsNotFound(err) {
+			debug.LogDebugContext(ctx, "Invalid or expired password reset token")
+			return apperr.Validation("Invalid or expired reset token", map[string]string{
+				"token": "token is invalid, expired or already used",
+			}).WithCode(apperr.CodeInvalidResetToken)
+		}
+		return fmt.Errorf("reset password: %w", err)
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(reset.NewPassword), s.bcryptCost)
+	if err != nil {
+		return fmt.Errorf("hash password: %w", err)
+	}
+
+	if err := s.users.UpdatePassword(ctx, token.UserID, string(hashed)); err != nil {
+		debug.ErrorDebugContext(ctx, "Error while resetting password of user %d: %v", token.UserID, err)
+		return fmt.Errorf("reset password: %w", err)
+	}
+
+	// As in ChangePassword, revoke regardless of the client.
+	if err := s.sessions.RevokeAllForUser(context.WithoutCancel(ctx), token.UserID, "", domain.RevokedPasswordReset); err != nil {
+		debug.ErrorDebugContext(ctx, "Error while revoking sessions of user %d: %v", token.UserID, err)
+		return fmt.Errorf("revoke sessions of user %d: %w", token.UserID, err)
+	}
+
+	debug.LogDebugContext(ctx, "Successfully reset password of user ID: %d", token.UserID)
+	return nil
+}
+
+func (s *passwordResetService) link(token string) string {
+	sep := "?"
+	if strings.Contains(s.resetURL, "?") {
+		sep = "&"
+	}
+	return s.resetURL + sep + "token=" + url.QueryEscape(token)
+}
@@ -1,85 +1,204 @@
 package service
 
 import (
+	"avenger/internal/apperr"
 	"avenger/internal/domain"
 	"avenger/internal/repository"
 	"avenger/pkg/debug"
-	"errors"
+	"context"
+	"fmt"
 	"strings"
 
-	"gorm.io/gorm"
+	"github.com/go-playground/validator/v10"
+)
+
+const (
+	DefaultRecipeLimit = 20
+	MaxRecipeLimit     = 100
 )
 
 type RecipeService interface {
-	GetAll() ([]domain.Recipe, error)
-	Create(recipe *domain.Recipe) error
-	Delete(id int) error
+	GetAll(ctx context.Context, q domain.RecipeQuery) ([]domain.Recipe, *domain.RecipeCursor, error)
+	GetByID(ctx context.Context, id int) (*domain.Recipe, error)
+	Create(ctx context.Context, recipe *domain.Recipe) error
+	Patch(ctx context.Context, id int, recipe *domain.Recipe, fields []string) error
+	Delete(ctx context.Context, id, version int) error
 }
 
 type recipeService struct {
-	repo repository.RecipeRepository
+	repo     repository.RecipeRepository
+	validate *validator.Validate
 }
 
 func NewRecipeService(r repository.RecipeRepository) RecipeService {
-	return &recipeService{repo: r}
+	return &recipeService{repo: r, validate: validator.New()}
+}
+
+// GetAll returns one page of recipes and the cursor of the next page, which
+// is nil when there are no more results.
+func (s *recipeService) GetAll(ctx context.Context, q domain.RecipeQuery) (_ []domain.Recipe, _ *domain.RecipeCursor, err error) {
+	ctx, span := tracer.Start(ctx, "RecipeService.GetAll")
+	defer func() { endSpan(span, err) }()
+
+	debug.LogDebugContext(ctx, "Fetching recipes: limit=%d sort=%s", q.Limit, q.Sort)
+
+	if q.Limit <= 0 {
+		q.Limit = DefaultRecipeLimit
+	}
+	if q.Limit > MaxRecipeLimit {
+		q.Limit = MaxRecipeLimit
+	}
+	if q.Sort.Field == "" {
+		q.Sort.Field = "id"
+	}
+	if q.Cursor != nil && q.Cursor.Sort != q.Sort.String() {
+		debug.ErrorDebugContext(ctx, "Cursor sort %q does not match requested sort %q", q.Cursor.Sort, q.Sort)
+		return nil, nil, apperr.Validation("Invalid query parameters", map[string]string{
+			"cursor": "cursor does not match the requested sort",
+		}).WithCode(apperr.CodeInvalidCursor)
+	}
+	q.Search = strings.TrimSpace(q.Search)
+
+	// Ask for one extra row to learn whether another page exists.
+	limit := q.Limit
+	q.Limit++
+
+	recipes, err := s.repo.GetAll(ctx, q)
+	if err != nil {
+		debug.ErrorDebugContext(ctx, "Failed to fetch recipes: %v", err)
+		return nil, nil, fmt.Errorf("list recipes: %w", err)
+	}
+
+	var next *domain.RecipeCursor
+	if len(recipes) > limit {
+		recipes = recipes[:limit]
+		next = domain.NewRecipeCursor(recipes[limit-1], q.Sort)
+	}
+
+	debug.LogDebugContext(ctx, "Successfully fetched %d recipes", len(recipes))
+	return recipes, next, nil
 }
 
-func (s *recipeService) GetAll() ([]domain.Recipe, error) {
-	debug.LogDebug("Fetching all recipes")
+func (s *recipeService) GetByID(ctx context.Context, id int) (_ *domain.Recipe, err error) {
+	ctx, span := tracer.Start(ctx, "RecipeService.GetByID")
+	defer func() { endSpan(span, err) }()
 
-	recipes, err := s.repo.GetAll()
+	debug.LogDebugContext(ctx, "Fetching recipe with ID: %d", id)
+	if id <= 0 {
+		debug.ErrorDebugContext(ctx, "Invalid recipe ID: %d", id)
+		return nil, invalidRecipeID()
+	}
+
+	recipe, err := s.repo.GetByID(ctx, id)
 	if err != nil {
-		debug.ErrorDebug("Failed to fetch recipes")
-		return nil, errors.New("failed to retrieve recipes from database")
+		debug.ErrorDebugContext(ctx, "Error while fetching recipe ID %d: %v", id, err)
+		return nil, fmt.Errorf("get recipe %d: %w", id, err)
 	}
 
-	debug.LogDebug("Successfully fetched %d recipes", len(recipes))
-	return recipes, nil
+	debug.LogDebugContext(ctx, "Successfully fetched recipe: %d", id)
+	return recipe, nil
 }
 
-func (s *recipeService) Create(recipe *domain.Recipe) error {
-	debug.LogDebug("Creating new recipe")
+func (s *recipeService) Create(ctx context.Context, recipe *domain.Recipe) (err error) {
+	ctx, span := tracer.Start(ctx, "RecipeService.Create")
+	defer func() { endSpan(span, err) }()
+
+	debug.LogDebugContext(ctx, "Creating new recipe")
 	if recipe.CookTime <= 0 {
-		debug.ErrorDebug("Invalid cook time")
-		return errors.New("cook time must be greater than 0")
+		debug.ErrorDebugContext(ctx, "Invalid cook time")
+		return apperr.Validation("Validation failed", map[string]string{
+			"cook_time": "cook time must be greater than 0",
+		})
 	}
 
 	if recipe.Rating < 0 || recipe.Rating > 5 {
-		debug.ErrorDebug("Invalid rating")
-		return errors.New("rating must be between 0 and 5")
+		debug.ErrorDebugContext(ctx, "Invalid rating")
+		return apperr.Validation("Validation failed", map[string]string{
+			"rating": "rating must be between 0 and 5",
+		})
 	}
 
 	recipe.Name = strings.TrimSpace(recipe.Name)
 	recipe.Description = strings.TrimSpace(recipe.Description)
 
-	err := s.repo.Create(recipe)
-	if err != nil {
-		debug.ErrorDebug("Database error while creating recipe")
-		return errors.New("failed to create recipe in database")
+	if err := s.repo.Create(ctx, recipe); err != nil {
+		debug.ErrorDebugContext(ctx, "Error while creating recipe: %v", err)
+		return fmt.Errorf("create recipe: %w", err)
 	}
 
-	debug.LogDebug("Successfully created recipe")
+	debug.LogDebugContext(ctx, "Successfully created recipe")
 	return nil
 }
 
-func (s *recipeService) Delete(id int) error {
-	debug.LogDebug("Deleting recipe")
+// Patch writes a partially modified recipe. Only the JSON members listed in
+// fields were supplied by the caller, so only those are validated.
+// recipe.Version must hold the version being replaced.
+func (s *recipeService) Patch(ctx context.Context, id int, recipe *domain.Recipe, fields []string) (err error) {
+	ctx, span := tracer.Start(ctx, "RecipeService.Patch")
+	defer func() { endSpan(span, err) }()
 
+	debug.LogDebugContext(ctx, "Patching recipe ID %d fields %v", id, fields)
 	if id <= 0 {
-		debug.ErrorDebug("Invalid recipe ID for deletion %d", id)
-		return errors.New("invalid recipe ID")
+		debug.ErrorDebugContext(ctx, "Invalid recipe ID for patch %d", id)
+		return invalidRecipeID()
 	}
 
-	err := s.repo.Delete(id)
-	if err != nil {
-		if err == gorm.ErrRecordNotFound {
-			debug.LogDebug("Recipe not found for deletion")
-			return errors.New("recipe not found")
+	structFields := make([]string, 0, len(fields))
+	errs := map[string]string{}
+	for _, f := range fields {
+		name, ok := domain.RecipePatchFields[f]
+		if !ok {
+			errs[f] = f + " cannot be changed"
+			continue
 		}
-		debug.ErrorDebug("Database error while deleting recipe ID")
-		return errors.New("failed to delete recipe from database")
+		structFields = append(structFields, name)
+	}
+	if len(errs) > 0 {
+		debug.ErrorDebugContext(ctx, "Fields cannot be patched: %v", errs)
+		return apperr.Validation("Validation failed", errs)
 	}
 
-	debug.LogDebug("Successfully delete recipe ID: %d", id)
+	recipe.ID = uint(id)
+	recipe.Name = strings.TrimSpace(recipe.Name)
+	recipe.Description = strings.TrimSpace(recipe.Description)
+
+	if err := s.validate.StructPartial(*recipe, structFields...); err != nil {
+		debug.ErrorDebugContext(ctx, "Validation failed for patch of recipe ID %d: %v", id, err)
+		return apperr.FromValidation(err)
+	}
+
+	if err := s.repo.Update(ctx, recipe); err != nil {
+		debug.ErrorDebugContext(ctx, "Error while patching recipe ID %d (expected version %d): %v", id, recipe.Version, err)
+		return fmt.Errorf("update recipe %d: %w", id, err)
+	}
+
+	debug.LogDebugContext(ctx, "Successfully patched recipe ID: %d to version %d", id, recipe.Version)
 	return nil
 }
+
+// Delete soft-deletes the recipe. A non-zero version must match the current one.
+func (s *recipeService) Delete(ctx context.Context, id, version int) (err error) {
+	ctx, span := tracer.Start(ctx, "RecipeService.Delete")
+	defer func() { endSpan(span, err) }()
+
+	debug.LogDebugContext(ctx, "Deleting recipe")
+
+	if id <= 0 {
+		debug.ErrorDebugContext(ctx, "Invalid recipe ID for deletion %d", id)
+		return invalidRecipeID()
+	}
+
+	if err := s.repo.Delete(ctx, id, version); err != nil {
+		debug.ErrorDebugContext(ctx, "Error while deleting recipe ID %d (expected version %d): %v", id, version, err)
+		return fmt.Errorf("delete recipe %d: %w", id, err)
+	}
+
+	debug.LogDebugContext(ctx, "Successfully delete recipe ID: %d", id)
+	return nil
+}
+
+func invalidRecipeID() error {
+	return apperr.Validation("Invalid ID parameter", map[string]string{
+		"id": "ID must be a positive integer",
+	}).WithCode(apperr.CodeInvalidID)
+}
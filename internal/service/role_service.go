@@ -0,0 +1,64 @@
+package service
+
+import (
+	"avenger/internal/domain"
+	"avenger/internal/repository"
+	"avenger/pkg/debug"
+	"context"
+	"fmt"
+)
+
+type RoleService interface {
+	SeedDefaults(ctx context.Context) error
+	Permissions(ctx context.Context, role string) ([]string, error)
+	GetAll(ctx context.Context) ([]domain.Role, error)
+	SetRequireTwoFactor(ctx context.Context, role string, required bool) (*domain.Role, error)
+}
+
+type roleService struct {
+	repo repository.RoleRepository
+}
+
+func NewRoleService(r repository.RoleRepository) RoleService {
+	return &roleService{repo: r}
+}
+
+func (s *roleService) SeedDefaults(ctx context.Context) error {
+	debug.LogDebugContext(ctx, "Seeding default roles")
+	if err := s.repo.Seed(ctx, domain.DefaultRolePermissions); err != nil {
+		debug.ErrorDebugContext(ctx, "Error while seeding roles: %v", err)
+		return fmt.Errorf("seed roles: %w", err)
+	}
+	return nil
+}
+
+func (s *roleService) Permissions(ctx context.Context, role string) ([]string, error) {
+	perms, err := s.repo.GetPermissions(ctx, role)
+	if err != nil {
+		debug.ErrorDebugContext(ctx, "Error while fetching permissions of role %s: %v", role, err)
+		return nil, fmt.Errorf("get permissions of role %s: %w", role, err)
+	}
+	return perms, nil
+}
+
+func (s *roleService) GetAll(ctx context.Context) ([]domain.Role, error) {
+	roles, err := s.repo.GetAll(ctx)
+	if err != nil {
+		debug.ErrorDebugContext(ctx, "Error while fetching roles: %v", err)
+		return nil, fmt.Errorf("get roles: %w", err)
+	}
+	return roles, nil
+}
+
+// SetRequireTwoFactor turns the 2FA requirement of role on or off. Members
+// without a second factor keep their sessions but lose their permissions at
+// the next token refresh until they enroll.
+func (s *roleService) SetRequireTwoFactor(ctx context.Context, role string, required bool) (*domain.Role, error) {
+	debug.LogDebugContext(ctx, "Setting require_two_factor=%t on role %s", required, role)
+	updated, err := s.repo.SetRequireTwoFactor(ctx, role, required)
+	if err != nil {
+		debug.ErrorDebugContext(ctx, "Error while updating role %s: %v", role, err)
+		return nil, fmt.Errorf("update role %s: %w", role, err)
+	}
+	return updated, nil
+}
@@ -0,0 +1,296 @@
+package service
+
+import (
+	"avenger/internal/apperr"
+	"avenger/internal/domain"
+	"avenger/internal/repository"
+	"avenger/pkg/debug"
+	"avenger/pkg/utils"
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	DefaultAccessTokenTTL  = 15 * time.Minute
+	DefaultRefreshTokenTTL = 7 * 24 * time.Hour
+)
+
+// How logins of users who have not verified their email are treated.
+const (
+	// UnverifiedLoginDeny refuses to log them in.
+	UnverifiedLoginDeny = "deny"
+	// UnverifiedLoginLimited logs them in with a token that grants no
+	// permissions, enough for /me and resending the verification.
+	UnverifiedLoginLimited = "limited"
+	// UnverifiedLoginAllow treats them like verified users.
+	UnverifiedLoginAllow = "allow"
+)
+
+type SessionConfig struct {
+	AccessTTL       time.Duration
+	RefreshTTL      time.Duration
+	UnverifiedLogin string
+}
+
+// SessionService issues access/refresh token pairs, rotates refresh tokens
+// and revokes sessions.
+type SessionService interface {
+	Create(ctx context.Context, user *domain.User, userAgent, ip string, twoFactor bool) (*domain.TokenPair, error)
+	Refresh(ctx context.Context, refreshToken, userAgent, ip string) (*domain.TokenPair, error)
+	Revoke(ctx context.Context, sessionID string) error
+	Validate(ctx context.Context, sessionID string) error
+}
+
+type sessionService struct {
+	repo       repository.SessionRepository
+	users      repository.UserRepository
+	roles      repository.RoleRepository
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	unverified string
+}
+
+func NewSessionService(r repository.SessionRepository, users repository.UserRepository, roles repository.RoleRepository, cfg SessionConfig) SessionService {
+	if cfg.AccessTTL <= 0 {
+		cfg.AccessTTL = DefaultAccessTokenTTL
+	}
+	if cfg.RefreshTTL <= 0 {
+		cfg.RefreshTTL = DefaultRefreshTokenTTL
+	}
+	if cfg.UnverifiedLogin == "" {
+		cfg.UnverifiedLogin = UnverifiedLoginDeny
+	}
+	return &sessionService{
+		repo:       r,
+		users:      users,
+		roles:      roles,
+		accessTTL:  cfg.AccessTTL,
+		refreshTTL: cfg.RefreshTTL,
+		unverified: cfg.UnverifiedLogin,
+	}
+}
+
+// Create starts a session for user. twoFactor records that the login was
+// completed with a second factor.
+func (s *sessionService) Create(ctx context.Context, user *domain.User, userAgent, ip string, twoFactor bool) (*domain.TokenPair, error) {
+	debug.LogDebugContext(ctx, "Creating session for user ID: %d", user.ID)
+
+	if !user.EmailVerified() && s.unverified == UnverifiedLoginDeny {
+		debug.LogDebugContext(ctx, "Login refused for unverified user ID: %d", user.ID)
+		return nil, apperr.Forbidden("Please verify your email address before logging in").WithCode(apperr.CodeEmailNotVerified)
+	}
+
+	id, err := utils.RandomToken(24)
+	if err != nil {
+		return nil, fmt.Errorf("create session: %w", err)
+	}
+
+	now := time.Now().UTC()
+	session := &domain.Session{
+		ID:         id,
+		UserID:     user.ID,
+		TwoFactor:  twoFactor,
+		UserAgent:  truncate(userAgent, 255),
+		IP:         ip,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(s.refreshTTL),
+	}
+
+	refresh, token, err := s.newRefreshToken(ctx, userAgent, ip, session.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("create session: %w", err)
+	}
+
+	if err := s.repo.Create(ctx, session, token); err != nil {
+		debug.ErrorDebugContext(ctx, "Error while creating session for user %d: %v", user.ID, err)
+		return nil, fmt.Errorf("create session: %w", err)
+	}
+
+	debug.LogDebugContext(ctx, "Successfully created session %s", session.ID)
+	return s.tokenPair(ctx, user, session, refresh)
+}
+
+// Refresh exchanges a refresh token for a new pair. Each refresh token works
+// once; presenting a spent one revokes the whole session, since either the
+// legitimate client or an attacker is holding a stolen copy.
+func (s *sessionService) Refresh(ctx context.Context, refreshToken, userAgent, ip string) (*domain.TokenPair, error) {
+	invalid := apperr.Unauthorized("Invalid or expired refresh token").WithCode(apperr.CodeInvalidRefreshToken)
+
+	if refreshToken == "" {
+		return nil, invalid
+	}
+
+	token, err := s.repo.GetRefreshToken(ctx, utils.HashToken(refreshToken))
+	if err != nil {
+		if apperr.IsNotFound(err) {
+			debug.LogDebugContext(ctx, "Unknown refresh token presented")
+			return nil, invalid
+		}
+		return nil, fmt.Errorf("refresh session: %w", err)
+	}
+
+	session, err := s.repo.GetByID(ctx, token.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("refresh session: %w", err)
+	}
+
+	now := time.Now().UTC()
+	if !session.Active(now) || now.After(token.ExpiresAt) {
+		debug.LogDebugContext(ctx, "Refresh attempted on inactive session %s", session.ID)
+		return nil, invalid
+	}
+
+	if token.UsedAt != nil {
+		return nil, s.revokeReused(ctx, session.ID)
+	}
+
+	if token.UserAgent != truncate(userAgent, 255) {
+		debug.ErrorDebugContext(ctx, "Refresh token for session %s presented by a different user agent", session.ID)
+		if err := s.repo.Revoke(ctx, session.ID, domain.RevokedAgentChanged); err != nil {
+			return nil, fmt.Errorf("refresh session: %w", err)
+		}
+		return nil, invalid
+	}
+
+	user, err := s.users.GetByID(ctx, session.UserID)
+	if err != nil {
+		if apperr.IsNotFound(err) {
+			return nil, invalid
+		}
+		return nil, fmt.Errorf("refresh session: %w", err)
+	}
+	if user.Disabled {
+		debug.LogDebugContext(ctx, "Refresh attempted by disabled user %d", user.ID)
+		return nil, invalid
+	}
+
+	expiresAt := now.Add(s.refreshTTL)
+	refresh, next, err := s.newRefreshToken(ctx, userAgent, ip, expiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("refresh session: %w", err)
+	}
+
+	rotated, err := s.repo.Rotate(ctx, token, next, expiresAt)
+	if err != nil {
+		debug.ErrorDebugContext(ctx, "Error while rotating refresh token for session %s: %v", session.ID, err)
+		return nil, fmt.Errorf("refresh session: %w", err)
+	}
+	if !rotated {
+		// Lost a race with another request presenting the same token.
+		return nil, s.revokeReused(ctx, session.ID)
+	}
+
+	debug.LogDebugContext(ctx, "Successfully rotated refresh token for session %s", session.ID)
+	return s.tokenPair(ctx, user, session, refresh)
+}
+
+func (s *sessionService) revokeReused(ctx context.Context, sessionID string) error {
+	debug.ErrorDebugContext(ctx, "Refresh token reuse detected, revoking session %s", sessionID)
+	// A thief must not keep the session by hanging up before this runs.
+	if err := s.repo.Revoke(context.WithoutCancel(ctx), sessionID, domain.RevokedTokenReuse); err != nil {
+		return fmt.Errorf("revoke session %s: %w", sessionID, err)
+	}
+	return apperr.Unauthorized("Refresh token has already been used, please log in again").WithCode(apperr.CodeRefreshTokenReused)
+}
+
+func (s *sessionService) Revoke(ctx context.Context, sessionID string) error {
+	debug.LogDebugContext(ctx, "Revoking session %s", sessionID)
+	if err := s.repo.Revoke(ctx, sessionID, domain.RevokedLogout); err != nil {
+		debug.ErrorDebugContext(ctx, "Error while revoking session %s: %v", sessionID, err)
+		return fmt.Errorf("revoke session %s: %w", sessionID, err)
+	}
+	return nil
+}
+
+// Validate returns an Unauthorized error unless sessionID names an active
+// session.
+func (s *sessionService) Validate(ctx context.Context, sessionID string) error {
+	revoked := apperr.Unauthorized("Session has been revoked or has expired").WithCode(apperr.CodeSessionRevoked)
+	if sessionID == "" {
+		return revoked
+	}
+
+	session, err := s.repo.GetByID(ctx, sessionID)
+	if err != nil {
+		if apperr.IsNotFound(err) {
+			return revoked
+		}
+		return fmt.Errorf("validate session %s: %w", sessionID, err)
+	}
+	if !session.Active(time.Now()) {
+		return revoked
+	}
+	return nil
+}
+
+func (s *sessionService) newRefreshToken(ctx context.Context, userAgent, ip string, expiresAt time.Time) (string, *domain.RefreshToken, error) {
+	refresh, err := utils.RandomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+	return refresh, &domain.RefreshToken{
+		TokenHash: utils.HashToken(refresh),
+		UserAgent: truncate(userAgent, 255),
+		IP:        ip,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+func (s *sessionService) tokenPair(ctx context.Context, user *domain.User, session *domain.Session, refresh string) (*domain.TokenPair, error) {
+	perms, err := s.permissions(ctx, user, session)
+	if err != nil {
+		return nil, err
+	}
+
+	access, err := utils.GenerateJWT(&utils.JWTClaim{
+		UserID:      int(user.ID),
+		Role:        user.Role,
+		SessionID:   session.ID,
+		Permissions: perms,
+	}, s.accessTTL)
+	if err != nil {
+		return nil, fmt.Errorf("generate access token: %w", err)
+	}
+	return &domain.TokenPair{
+		AccessToken:      access,
+		RefreshToken:     refresh,
+		TokenType:        "Bearer",
+		ExpiresIn:        int(s.accessTTL.Seconds()),
+		RefreshExpiresIn: int(s.refreshTTL.Seconds()),
+	}, nil
+}
+
+// permissions returns what the access token of session grants. Unverified
+// users (unless allowed) and sessions without a second factor for roles that
+// require one get none: enough for /me, verification and 2FA enrollment.
+func (s *sessionService) permissions(ctx context.Context, user *domain.User, session *domain.Session) ([]string, error) {
+	if !user.EmailVerified() && s.unverified != UnverifiedLoginAllow {
+		return nil, nil
+	}
+
+	if !session.TwoFactor {
+		required, err := s.roles.RequiresTwoFactor(ctx, user.Role)
+		if err != nil {
+			return nil, fmt.Errorf("resolve two-factor requirement: %w", err)
+		}
+		if required {
+			debug.LogDebugContext(ctx, "Session %s lacks the second factor role %s requires", session.ID, user.Role)
+			return nil, nil
+		}
+	}
+
+	perms, err := s.roles.GetPermissions(ctx, user.Role)
+	if err != nil {
+		return nil, fmt.Errorf("resolve permissions: %w", err)
+	}
+	return perms, nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
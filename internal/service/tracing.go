@@ -0,0 +1,21 @@
+package service
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer starts the spans of service methods, named "<Service>.<Method>".
+// Repository queries called with the span's context become its children.
+var tracer = otel.Tracer("avenger/internal/service")
+
+// endSpan ends span, first marking it failed with err when the method
+// returned one.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,297 @@
+package service
+
+import (
+	"avenger/internal/apperr"
+	"avenger/internal/domain"
+	"avenger/internal/repository"
+	"avenger/pkg/debug"
+	"avenger/pkg/utils"
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// TwoFactorChallengeTTL is how long a login may sit between the password
+	// and the second factor.
+	TwoFactorChallengeTTL = 5 * time.Minute
+	RecoveryCodeCount     = 10
+	DefaultTOTPIssuer     = "Avenger"
+	totpQRCodeSize        = 256
+)
+
+// TwoFactorService manages TOTP enrollment and recovery codes and checks the
+// second factor of logins.
+type TwoFactorService interface {
+	Enroll(ctx context.Context, userID int) (*domain.TOTPEnrollment, error)
+	Confirm(ctx context.Context, userID int, sessionID, code string) ([]string, error)
+	Disable(ctx context.Context, userID int, code string) error
+	RegenerateRecoveryCodes(ctx context.Context, userID int, code string) ([]string, error)
+	Enabled(ctx context.Context, userID uint) (bool, error)
+	Challenge(ctx context.Context, userID uint) (string, error)
+	ChallengeUser(ctx context.Context, token string) (*domain.User, error)
+	Verify(ctx context.Context, userID uint, code string) error
+}
+
+type twoFactorService struct {
+	repo     repository.TwoFactorRepository
+	users    repository.UserRepository
+	roles    repository.RoleRepository
+	sessions repository.SessionRepository
+	issuer   string
+}
+
+// NewTwoFactorService labels enrolled authenticators with issuer.
+func NewTwoFactorService(r repository.TwoFactorRepository, users repository.UserRepository, roles repository.RoleRepository, sessions repository.SessionRepository, issuer string) TwoFactorService {
+	if issuer == "" {
+		issuer = DefaultTOTPIssuer
+	}
+	return &twoFactorService{repo: r, users: users, roles: roles, sessions: sessions, issuer: issuer}
+}
+
+// Enroll starts enrollment with a fresh secret. Logins are unaffected until
+// the first code is confirmed.
+func (s *twoFactorService) Enroll(ctx context.Context, userID int) (*domain.TOTPEnrollment, error) {
+	debug.LogDebugContext(ctx, "Starting TOTP enrollment for user %d", userID)
+
+	user, err := s.users.GetByID(ctx, uint(userID))
+	if err != nil {
+		return nil, fmt.Errorf("enroll two-factor: %w", err)
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return nil, fmt.Errorf("enroll two-factor: %w", err)
+	}
+	if err := s.repo.SavePending(ctx, &domain.TOTPCredential{UserID: user.ID, Secret: secret}); err != nil {
+		debug.ErrorDebugContext(ctx, "Error while storing TOTP secret of user %d: %v", userID, err)
+		return nil, fmt.Errorf("enroll two-factor: %w", err)
+	}
+
+	uri := utils.TOTPURI(s.issuer, user.Email, secret)
+	png, err := utils.QRCodePNG(uri, totpQRCodeSize)
+	if err != nil {
+		return nil, fmt.Errorf("render QR code: %w", err)
+	}
+
+	return &domain.TOTPEnrollment{
+		Secret:     secret,
+		OTPAuthURI: uri,
+		QRCode:     "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	}, nil
+}
+
+// Confirm enables 2FA with the first code from the authenticator and returns
+// the recovery codes, which are never shown again. The calling session
+// counts as having passed the second factor.
+func (s *twoFactorService) Confirm(ctx context.Context, userID int, sessionID, code string) ([]string, error) {
+	debug.LogDebugContext(ctx, "Confirming TOTP enrollment for user %d", userID)
+
+	cred, err := s.repo.GetTOTP(ctx, uint(userID))
+	if err != nil {
+		if apperr.IsNotFound(err) {
+			return nil, notEnrolled()
+		}
+		return nil, fmt.Errorf("confirm two-factor: %w", err)
+	}
+	if cred.Enabled() {
+		return nil, apperr.Conflict("Two-factor authentication is already enabled").WithCode(apperr.CodeTwoFactorEnabled)
+	}
+
+	step, ok := utils.ValidateTOTP(cred.Secret, code, time.Now(), cred.LastUsedStep)
+	if !ok {
+		return nil, invalidCode()
+	}
+
+	codes, hashed, err := newRecoveryCodes(cred.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("confirm two-factor: %w", err)
+	}
+	confirmed, err := s.repo.Confirm(ctx, cred.UserID, step, hashed)
+	if err != nil {
+		debug.ErrorDebugContext(ctx, "Error while confirming TOTP of user %d: %v", userID, err)
+		return nil, fmt.Errorf("confirm two-factor: %w", err)
+	}
+	if !confirmed {
+		return nil, invalidCode()
+	}
+
+	if err := s.sessions.MarkTwoFactor(ctx, sessionID); err != nil {
+		return nil, fmt.Errorf("confirm two-factor: %w", err)
+	}
+
+	debug.LogDebugContext(ctx, "Enabled TOTP for user %d", userID)
+	return codes, nil
+}
+
+// Disable turns 2FA off after checking a current code. Members of roles
+// that require 2FA cannot turn it off.
+func (s *twoFactorService) Disable(ctx context.Context, userID int, code string) error {
+	debug.LogDebugContext(ctx, "Disabling TOTP for user %d", userID)
+
+	user, err := s.users.GetByID(ctx, uint(userID))
+	if err != nil {
+		return fmt.Errorf("disable two-factor: %w", err)
+	}
+	required, err := s.roles.RequiresTwoFactor(ctx, user.Role)
+	if err != nil {
+		return fmt.Errorf("disable two-factor: %w", err)
+	}
+	if required {
+		return apperr.Forbidden("Your role requires two-factor authentication").WithCode(apperr.CodeTwoFactorRequired)
+	}
+
+	if err := s.check(ctx, user.ID, code, invalidCode()); err != nil {
+		return err
+	}
+	if err := s.repo.Delete(ctx, user.ID); err != nil {
+		debug.ErrorDebugContext(ctx, "Error while disabling TOTP of user %d: %v", userID, err)
+		return fmt.Errorf("disable two-factor: %w", err)
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after checking a
+// current code.
+func (s *twoFactorService) RegenerateRecoveryCodes(ctx context.Context, userID int, code string) ([]string, error) {
+	debug.LogDebugContext(ctx, "Regenerating recovery codes for user %d", userID)
+
+	if err := s.check(ctx, uint(userID), code, invalidCode()); err != nil {
+		return nil, err
+	}
+
+	codes, hashed, err := newRecoveryCodes(uint(userID))
+	if err != nil {
+		return nil, fmt.Errorf("regenerate recovery codes: %w", err)
+	}
+	if err := s.repo.ReplaceRecoveryCodes(ctx, uint(userID), hashed); err != nil {
+		debug.ErrorDebugContext(ctx, "Error while storing recovery codes of user %d: %v", userID, err)
+		return nil, fmt.Errorf("regenerate recovery codes: %w", err)
+	}
+	return codes, nil
+}
+
+func (s *twoFactorService) Enabled(ctx context.Context, userID uint) (bool, error) {
+	cred, err := s.repo.GetTOTP(ctx, userID)
+	if err != nil {
+		if apperr.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("get two-factor credential: %w", err)
+	}
+	return cred.Enabled(), nil
+}
+
+// Challenge issues the token a login that passed the password step trades
+// for a session at POST /login/2fa.
+func (s *twoFactorService) Challenge(ctx context.Context, userID uint) (string, error) {
+	token, err := utils.GenerateChallengeToken(int(userID), TwoFactorChallengeTTL)
+	if err != nil {
+		return "", fmt.Errorf("issue two-factor challenge: %w", err)
+	}
+	return token, nil
+}
+
+// ChallengeUser returns the active user a challenge token was issued to.
+func (s *twoFactorService) ChallengeUser(ctx context.Context, token string) (*domain.User, error) {
+	invalid := apperr.Unauthorized("Invalid or expired challenge token").WithCode(apperr.CodeInvalidChallenge)
+
+	userID, err := utils.ValidateChallengeToken(token)
+	if err != nil {
+		debug.LogDebugContext(ctx, "Rejected challenge token: %v", err)
+		return nil, invalid
+	}
+
+	user, err := s.users.GetByID(ctx, uint(userID))
+	if err != nil {
+		if apperr.IsNotFound(err) {
+			return nil, invalid
+		}
+		return nil, fmt.Errorf("get challenged user: %w", err)
+	}
+	if user.Disabled {
+		return nil, invalid
+	}
+	return user, nil
+}
+
+// Verify checks the second factor of a login: a TOTP code or an unused
+// recovery code.
+func (s *twoFactorService) Verify(ctx context.Context, userID uint, code string) error {
+	return s.check(ctx, userID, code, apperr.Unauthorized("Invalid two-factor code").WithCode(apperr.CodeInvalidTwoFactorCode))
+}
+
+// check accepts a TOTP code or spends a recovery code of an enabled
+// credential, returning invalid otherwise.
+func (s *twoFactorService) check(ctx context.Context, userID uint, code string, invalid error) error {
+	cred, err := s.repo.GetTOTP(ctx, userID)
+	if err != nil {
+		if apperr.IsNotFound(err) {
+			return notEnrolled()
+		}
+		return fmt.Errorf("check two-factor code: %w", err)
+	}
+	if !cred.Enabled() {
+		return notEnrolled()
+	}
+
+	if step, ok := utils.ValidateTOTP(cred.Secret, code, time.Now(), cred.LastUsedStep); ok {
+		used, err := s.repo.UseStep(ctx, userID, step)
+		if err != nil {
+			return fmt.Errorf("check two-factor code: %w", err)
+		}
+		if used {
+			return nil
+		}
+		debug.LogDebugContext(ctx, "TOTP code of user %d was already used", userID)
+		return invalid
+	}
+
+	used, err := s.repo.UseRecoveryCode(ctx, userID, utils.HashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return fmt.Errorf("check recovery code: %w", err)
+	}
+	if !used {
+		return invalid
+	}
+	debug.LogDebugContext(ctx, "User %d used a recovery code", userID)
+	return nil
+}
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newRecoveryCodes returns RecoveryCodeCount codes formatted xxxxx-xxxxx
+// and their hashed records.
+func newRecoveryCodes(userID uint) ([]string, []domain.RecoveryCode, error) {
+	codes := make([]string, RecoveryCodeCount)
+	hashed := make([]domain.RecoveryCode, RecoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(recoveryEncoding.EncodeToString(b))[:10]
+		codes[i] = raw[:5] + "-" + raw[5:]
+		hashed[i] = domain.RecoveryCode{UserID: userID, CodeHash: utils.HashToken(raw)}
+	}
+	return codes, hashed, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+func invalidCode() error {
+	return apperr.Validation("Invalid two-factor code", map[string]string{
+		"code": "code is invalid or was already used",
+	}).WithCode(apperr.CodeInvalidTwoFactorCode)
+}
+
+func notEnrolled() error {
+	return apperr.Conflict("Two-factor authentication is not enabled").WithCode(apperr.CodeTwoFactorNotEnrolled)
+}
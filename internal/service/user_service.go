@@ -1,87 +1,522 @@
 package service
 
 import (
+	"avenger/internal/apperr"
 	"avenger/internal/domain"
 	"avenger/internal/repository"
 	"avenger/pkg/debug"
+	"avenger/pkg/utils"
+	"context"
 	"errors"
+	"fmt"
 	"net/mail"
+	"slices"
 	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	DefaultUserPerPage = 20
+	MaxUserPerPage     = 100
 )
 
 type UserService interface {
-	Register(user *domain.User) error
-	GetByEmail(email string) (*domain.User, error)
-	ValidateUser(user domain.User) error
+	Register(ctx context.Context, user *domain.User, inviteCode string) error
+	CreateSuperadmin(ctx context.Context, user *domain.User) error
+	GetByEmail(ctx context.Context, email string) (*domain.User, error)
+	ValidateUser(ctx context.Context, user domain.User) error
+	GetAll(ctx context.Context, q domain.UserQuery) ([]domain.User, int, error)
+	GetByID(ctx context.Context, id int) (*domain.User, error)
+	Patch(ctx context.Context, actorID, id int, user *domain.User, fields []string) error
+	Delete(ctx context.Context, actorID, id int) error
+	UpdateProfile(ctx context.Context, id int, user *domain.User, fields []string) error
+	ChangePassword(ctx context.Context, id int, sessionID string, change domain.PasswordChange) error
 }
 
 type userService struct {
-	repo repository.UserRepository
+	repo        repository.UserRepository
+	sessions    repository.SessionRepository
+	apiKeys     repository.APIKeyRepository
+	roles       repository.RoleRepository
+	invitations repository.InvitationRepository
+	bcryptCost  int
+}
+
+// NewUserService hashes passwords with bcryptCost; costs below
+// bcrypt.MinCost mean bcrypt.DefaultCost.
+func NewUserService(r repository.UserRepository, sessions repository.SessionRepository, apiKeys repository.APIKeyRepository, roles repository.RoleRepository, invitations repository.InvitationRepository, bcryptCost int) UserService {
+	return &userService{repo: r, sessions: sessions, apiKeys: apiKeys, roles: roles, invitations: invitations, bcryptCost: bcryptCost}
+}
+
+// Register creates a public account with DefaultRole or, given an invite
+// code, an account with the invited role. An invitation bound to the email
+// being registered also counts as proof of that address.
+func (s *userService) Register(ctx context.Context, user *domain.User, inviteCode string) (err error) {
+	ctx, span := tracer.Start(ctx, "UserService.Register")
+	defer func() { endSpan(span, err) }()
+
+	// Role and account state are never taken from the request.
+	user.Role = domain.DefaultRole
+	user.Disabled = false
+	user.EmailVerifiedAt = nil
+
+	if inviteCode == "" {
+		debug.LogDebugContext(ctx, "Registering new user: Email=%s, Role=%s", user.Email, user.Role)
+		err = s.repo.Register(ctx, user)
+	} else {
+		err = s.registerInvited(ctx, user, inviteCode)
+	}
+	if err != nil {
+		debug.ErrorDebugContext(ctx, "Error while registering user %s: %v", user.Email, err)
+		if apperr.KindOf(err) == apperr.KindConflict {
+			return fmt.Errorf("register user: %w", apperr.Conflict("Email already registered").WithCode(apperr.CodeEmailTaken))
+		}
+		return fmt.Errorf("register user: %w", err)
+	}
+
+	debug.LogDebugContext(ctx, "Successfully registered user with ID: %d", user.ID)
+	return nil
 }
 
-func NewUserService(r repository.UserRepository) UserService {
-	return &userService{repo: r}
+func (s *userService) registerInvited(ctx context.Context, user *domain.User, code string) error {
+	invalid := apperr.Validation("Invalid invitation", map[string]string{
+		"invite_code": "invite code is invalid, expired, revoked or already used",
+	}).WithCode(apperr.CodeInvalidInvitation)
+
+	claims, err := utils.ValidateInviteCode(code)
+	if err != nil {
+		debug.LogDebugContext(ctx, "Rejected invite code: %v", err)
+		return invalid
+	}
+
+	inv, err := s.invitations.GetByID(ctx, claims.ID)
+	if err != nil {
+		if apperr.IsNotFound(err) {
+			return invalid
+		}
+		return err
+	}
+	if !inv.Pending(time.Now()) {
+		return invalid
+	}
+	if inv.Email != "" {
+		if !strings.EqualFold(inv.Email, user.Email) {
+			return apperr.Validation("Invalid invitation", map[string]string{
+				"email": "invitation was issued for a different email address",
+			}).WithCode(apperr.CodeInvalidInvitation)
+		}
+		verifiedAt := time.Now().UTC()
+		user.EmailVerifiedAt = &verifiedAt
+	}
+
+	user.Role = inv.Role
+	debug.LogDebugContext(ctx, "Registering invited user: Email=%s, Role=%s, Invitation=%s", user.Email, user.Role, inv.ID)
+	if err := s.invitations.Redeem(ctx, inv.ID, user); err != nil {
+		if apperr.KindOf(err) == apperr.KindConflict && errorCode(err) == apperr.CodeInvalidInvitation {
+			return invalid
+		}
+		return err
+	}
+	return nil
 }
 
-func (s *userService) Register(user *domain.User) error {
-	debug.LogDebug("Registering new user: Email=%s, Role=%s", user.Email, user.Role)
-	err := s.repo.Register(user)
+// CreateSuperadmin bootstraps the first superadmin. It fails once any
+// superadmin exists; further ones must be invited. user.Password is the
+// plain text password.
+func (s *userService) CreateSuperadmin(ctx context.Context, user *domain.User) (err error) {
+	ctx, span := tracer.Start(ctx, "UserService.CreateSuperadmin")
+	defer func() { endSpan(span, err) }()
+
+	user.Role = domain.RoleSuperadmin
+	if err := s.ValidateUser(ctx, *user); err != nil {
+		return err
+	}
+
+	count, err := s.repo.CountByRole(ctx, domain.RoleSuperadmin)
+	if err != nil {
+		return fmt.Errorf("count superadmins: %w", err)
+	}
+	if count > 0 {
+		return apperr.Conflict("A superadmin already exists").WithCode(apperr.CodeSuperadminExists)
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(user.Password), s.bcryptCost)
 	if err != nil {
-		if strings.Contains(err.Error(), "duplicate") || strings.Contains(err.Error(), "unique constraint") {
-			debug.ErrorDebug("Duplicate email on registration: %s", user.Email)
-			return errors.New("email already exists")
+		return fmt.Errorf("hash password: %w", err)
+	}
+	user.Password = string(hashed)
+	user.Disabled = false
+	verifiedAt := time.Now().UTC()
+	user.EmailVerifiedAt = &verifiedAt
+
+	if err := s.repo.Register(ctx, user); err != nil {
+		debug.ErrorDebugContext(ctx, "Error while creating superadmin %s: %v", user.Email, err)
+		if apperr.KindOf(err) == apperr.KindConflict {
+			return fmt.Errorf("create superadmin: %w", apperr.Conflict("Email already registered").WithCode(apperr.CodeEmailTaken))
 		}
-		debug.LogDebug("Database error while registering user: %v", err)
-		return errors.New("failed to register user")
+		return fmt.Errorf("create superadmin: %w", err)
 	}
 
-	debug.LogDebug("Successfully registered user with ID: %d", user.ID)
+	debug.LogDebugContext(ctx, "Created superadmin with ID: %d", user.ID)
 	return nil
 }
 
-func (s *userService) GetByEmail(email string) (*domain.User, error) {
-	debug.LogDebug("Fetching user by email: %s", email)
+func (s *userService) GetByEmail(ctx context.Context, email string) (_ *domain.User, err error) {
+	ctx, span := tracer.Start(ctx, "UserService.GetByEmail")
+	defer func() { endSpan(span, err) }()
 
-	user, err := s.repo.GetByEmail(email)
+	debug.LogDebugContext(ctx, "Fetching user by email: %s", email)
+
+	user, err := s.repo.GetByEmail(ctx, email)
 	if err != nil {
-		debug.ErrorDebug("Database error while fetching user by email: %v", err)
-		return nil, errors.New("failed to retrieve user")
+		debug.ErrorDebugContext(ctx, "Database error while fetching user by email: %v", err)
+		return nil, fmt.Errorf("get user by email: %w", err)
 	}
 
 	if user == nil {
-		debug.LogDebug("User not found for email: %s", email)
+		debug.LogDebugContext(ctx, "User not found for email: %s", email)
 		return nil, nil
 	}
 
-	debug.LogDebug("Successfully fetched user")
+	debug.LogDebugContext(ctx, "Successfully fetched user")
 	return user, nil
 }
 
-func (s *userService) ValidateUser(user domain.User) error {
-	debug.LogDebug("Validating user data for: %s", user.Email)
+func (s *userService) ValidateUser(ctx context.Context, user domain.User) (err error) {
+	ctx, span := tracer.Start(ctx, "UserService.ValidateUser")
+	defer func() { endSpan(span, err) }()
+
+	debug.LogDebugContext(ctx, "Validating user data for: %s", user.Email)
+
+	invalid := func(field, message string) error {
+		return apperr.Validation("Invalid request body", map[string]string{field: message})
+	}
 
 	if user.Email == "" {
-		return errors.New("email tidak boleh kosong")
+		return invalid("email", "email tidak boleh kosong")
 	}
 	if _, err := mail.ParseAddress(user.Email); err != nil {
-		return errors.New("format email tidak valid")
+		return invalid("email", "format email tidak valid")
 	}
 	if len(user.Password) < 8 {
-		return errors.New("password minimal 8 karakter")
+		return invalid("password", "password minimal 8 karakter")
+	}
+	if err := validateProfile(user); err != nil {
+		return err
+	}
+	if user.Role == "" {
+		user.Role = domain.DefaultRole
+	}
+
+	debug.LogDebugContext(ctx, "User validation passed")
+	return nil
+}
+
+// checkPatchFields rejects every member of fields that allowed does not list.
+func checkPatchFields(ctx context.Context, fields []string, allowed map[string]string) error {
+	errs := map[string]string{}
+	for _, f := range fields {
+		if _, ok := allowed[f]; !ok {
+			errs[f] = f + " cannot be changed"
+		}
+	}
+	if len(errs) > 0 {
+		debug.ErrorDebugContext(ctx, "Fields cannot be patched: %v", errs)
+		return apperr.Validation("Validation failed", errs)
+	}
+	return nil
+}
+
+// validateProfile checks the fields a user may edit about themselves.
+func validateProfile(user domain.User) error {
+	invalid := func(field, message string) error {
+		return apperr.Validation("Invalid request body", map[string]string{field: message})
 	}
+
 	if len(user.FullName) < 6 || len(user.FullName) > 15 {
-		return errors.New("full name minimal 6 dan maksimal 15 karakter")
+		return invalid("full_name", "full name minimal 6 dan maksimal 15 karakter")
 	}
 	if user.Age < 17 {
-		return errors.New("umur minimal 17 tahun")
+		return invalid("age", "umur minimal 17 tahun")
 	}
 	if user.Occupation == "" {
-		return errors.New("occupation tidak boleh kosong")
+		return invalid("occupation", "occupation tidak boleh kosong")
 	}
-	if user.Role == "" {
-		user.Role = "admin"
+	return nil
+}
+
+// GetAll returns one page of users without their password hashes.
+func (s *userService) GetAll(ctx context.Context, q domain.UserQuery) (_ []domain.User, _ int, err error) {
+	ctx, span := tracer.Start(ctx, "UserService.GetAll")
+	defer func() { endSpan(span, err) }()
+
+	debug.LogDebugContext(ctx, "Fetching users: page=%d per_page=%d", q.Page, q.PerPage)
+
+	if q.Page <= 0 {
+		q.Page = 1
+	}
+	if q.PerPage <= 0 {
+		q.PerPage = DefaultUserPerPage
+	}
+	if q.PerPage > MaxUserPerPage {
+		q.PerPage = MaxUserPerPage
+	}
+	q.Search = strings.TrimSpace(q.Search)
+
+	users, total, err := s.repo.GetAll(ctx, q)
+	if err != nil {
+		debug.ErrorDebugContext(ctx, "Failed to fetch users: %v", err)
+		return nil, 0, fmt.Errorf("list users: %w", err)
+	}
+
+	for i := range users {
+		users[i].Password = ""
 	}
 
-	debug.LogDebug("User validation passed")
+	debug.LogDebugContext(ctx, "Successfully fetched %d of %d users", len(users), total)
+	return users, total, nil
+}
+
+// GetByID returns the user without the password hash.
+func (s *userService) GetByID(ctx context.Context, id int) (_ *domain.User, err error) {
+	ctx, span := tracer.Start(ctx, "UserService.GetByID")
+	defer func() { endSpan(span, err) }()
+
+	debug.LogDebugContext(ctx, "Fetching user by ID: %d", id)
+	if id <= 0 {
+		return nil, invalidUserID()
+	}
+
+	user, err := s.repo.GetByID(ctx, uint(id))
+	if err != nil {
+		debug.ErrorDebugContext(ctx, "Error while fetching user ID %d: %v", id, err)
+		return nil, fmt.Errorf("get user %d: %w", id, err)
+	}
+
+	user.Password = ""
+	return user, nil
+}
+
+// Patch writes the members of a partially modified user listed in fields on
+// behalf of actorID, and leaves user holding the stored result. Disabling a
+// user or changing their role revokes their sessions and the API keys they
+// created, so tokens and keys issued before the change stop working
+// immediately.
+func (s *userService) Patch(ctx context.Context, actorID, id int, user *domain.User, fields []string) (err error) {
+	ctx, span := tracer.Start(ctx, "UserService.Patch")
+	defer func() { endSpan(span, err) }()
+
+	debug.LogDebugContext(ctx, "Patching user ID %d fields %v", id, fields)
+	if id <= 0 {
+		return invalidUserID()
+	}
+
+	current, err := s.repo.GetByID(ctx, uint(id))
+	if err != nil {
+		debug.ErrorDebugContext(ctx, "Error while fetching user ID %d for patch: %v", id, err)
+		return fmt.Errorf("update user %d: %w", id, err)
+	}
+
+	if err := checkPatchFields(ctx, fields, domain.UserPatchFields); err != nil {
+		return err
+	}
+
+	// Only the patched fields are written, and compared with a fresh read:
+	// the rest of user may be stale.
+	roleChanged := slices.Contains(fields, "role") && user.Role != current.Role
+	disabled := slices.Contains(fields, "disabled") && user.Disabled && !current.Disabled
+
+	if actorID == id && (roleChanged || disabled) {
+		debug.ErrorDebugContext(ctx, "User %d tried to change own role or disable themselves", id)
+		return apperr.Forbidden("You cannot change the role of or disable your own account").WithCode(apperr.CodeSelfLockout)
+	}
+
+	if roleChanged {
+		exists, err := s.roles.Exists(ctx, user.Role)
+		if err != nil {
+			return fmt.Errorf("update user %d: %w", id, err)
+		}
+		if !exists {
+			debug.ErrorDebugContext(ctx, "Role %s does not exist", user.Role)
+			return apperr.Validation("Validation failed", map[string]string{"role": "role does not exist"})
+		}
+	}
+
+	user.ID = uint(id)
+	user.FullName = strings.TrimSpace(user.FullName)
+	user.Occupation = strings.TrimSpace(user.Occupation)
+
+	if err := validateProfile(*user); err != nil {
+		debug.ErrorDebugContext(ctx, "Validation failed for patch of user ID %d: %v", id, err)
+		return err
+	}
+
+	if err := s.repo.Update(ctx, user, fields); err != nil {
+		debug.ErrorDebugContext(ctx, "Error while patching user ID %d: %v", id, err)
+		return fmt.Errorf("update user %d: %w", id, err)
+	}
+
+	reason := ""
+	switch {
+	case disabled:
+		reason = domain.RevokedUserDisabled
+	case roleChanged:
+		reason = domain.RevokedRoleChanged
+	}
+	if reason != "" {
+		// Already saved, so revoke even if the client has hung up.
+		if err := s.revokeAccess(context.WithoutCancel(ctx), user.ID, reason); err != nil {
+			return err
+		}
+	}
+
+	// Answer with what is stored, including changes other administrators
+	// made to the fields this patch left alone.
+	if saved, err := s.repo.GetByID(ctx, user.ID); err == nil {
+		*user = *saved
+	}
+	user.Password = ""
+	debug.LogDebugContext(ctx, "Successfully patched user ID: %d", id)
+	return nil
+}
+
+// Delete soft-deletes the user and revokes their sessions and API keys.
+func (s *userService) Delete(ctx context.Context, actorID, id int) (err error) {
+	ctx, span := tracer.Start(ctx, "UserService.Delete")
+	defer func() { endSpan(span, err) }()
+
+	debug.LogDebugContext(ctx, "Deleting user ID: %d", id)
+	if id <= 0 {
+		return invalidUserID()
+	}
+	if actorID == id {
+		debug.ErrorDebugContext(ctx, "User %d tried to delete themselves", id)
+		return apperr.Forbidden("You cannot delete your own account").WithCode(apperr.CodeSelfLockout)
+	}
+
+	if err := s.repo.Delete(ctx, uint(id)); err != nil {
+		debug.ErrorDebugContext(ctx, "Error while deleting user ID %d: %v", id, err)
+		return fmt.Errorf("delete user %d: %w", id, err)
+	}
+
+	// Already deleted; revocation must not depend on the client staying.
+	if err := s.revokeAccess(context.WithoutCancel(ctx), uint(id), domain.RevokedUserDeleted); err != nil {
+		return err
+	}
+
+	debug.LogDebugContext(ctx, "Successfully deleted user ID: %d", id)
 	return nil
 }
+
+// revokeAccess ends the sessions of the user and revokes the API keys they
+// created, whose scopes were granted from their permissions.
+func (s *userService) revokeAccess(ctx context.Context, id uint, reason string) error {
+	if err := s.sessions.RevokeAllForUser(ctx, id, "", reason); err != nil {
+		debug.ErrorDebugContext(ctx, "Error while revoking sessions of user ID %d: %v", id, err)
+		return fmt.Errorf("revoke sessions of user %d: %w", id, err)
+	}
+	if err := s.apiKeys.RevokeAllForCreator(ctx, id); err != nil {
+		debug.ErrorDebugContext(ctx, "Error while revoking API keys of user ID %d: %v", id, err)
+		return fmt.Errorf("revoke API keys of user %d: %w", id, err)
+	}
+	return nil
+}
+
+// UpdateProfile writes the profile fields listed in fields for the user
+// themselves. Role and account state cannot be changed this way.
+func (s *userService) UpdateProfile(ctx context.Context, id int, user *domain.User, fields []string) (err error) {
+	ctx, span := tracer.Start(ctx, "UserService.UpdateProfile")
+	defer func() { endSpan(span, err) }()
+
+	debug.LogDebugContext(ctx, "Updating profile of user ID %d fields %v", id, fields)
+	if id <= 0 {
+		return invalidUserID()
+	}
+
+	if err := checkPatchFields(ctx, fields, domain.ProfilePatchFields); err != nil {
+		return err
+	}
+
+	user.ID = uint(id)
+	user.FullName = strings.TrimSpace(user.FullName)
+	user.Occupation = strings.TrimSpace(user.Occupation)
+
+	if err := validateProfile(*user); err != nil {
+		debug.ErrorDebugContext(ctx, "Profile validation failed for user ID %d: %v", id, err)
+		return err
+	}
+
+	if err := s.repo.UpdateProfile(ctx, user); err != nil {
+		debug.ErrorDebugContext(ctx, "Error while updating profile of user ID %d: %v", id, err)
+		return fmt.Errorf("update profile of user %d: %w", id, err)
+	}
+
+	user.Password = ""
+	debug.LogDebugContext(ctx, "Successfully updated profile of user ID: %d", id)
+	return nil
+}
+
+// ChangePassword replaces the password after checking the current one and
+// revokes every session of the user except sessionID, the one making the
+// request.
+func (s *userService) ChangePassword(ctx context.Context, id int, sessionID string, change domain.PasswordChange) (err error) {
+	ctx, span := tracer.Start(ctx, "UserService.ChangePassword")
+	defer func() { endSpan(span, err) }()
+
+	debug.LogDebugContext(ctx, "Changing password of user ID: %d", id)
+
+	if len(change.NewPassword) < 8 {
+		return apperr.Validation("Validation failed", map[string]string{
+			"new_password": "password minimal 8 karakter",
+		})
+	}
+
+	user, err := s.repo.GetByID(ctx, uint(id))
+	if err != nil {
+		debug.ErrorDebugContext(ctx, "Error while fetching user ID %d: %v", id, err)
+		return fmt.Errorf("change password of user %d: %w", id, err)
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(change.CurrentPassword)); err != nil {
+		debug.ErrorDebugContext(ctx, "Current password mismatch for user ID %d", id)
+		return apperr.Validation("Validation failed", map[string]string{
+			"current_password": "current password is incorrect",
+		}).WithCode(apperr.CodeInvalidCredentials)
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(change.NewPassword), s.bcryptCost)
+	if err != nil {
+		return fmt.Errorf("hash password: %w", err)
+	}
+
+	if err := s.repo.UpdatePassword(ctx, user.ID, string(hashed)); err != nil {
+		debug.ErrorDebugContext(ctx, "Error while updating password of user ID %d: %v", id, err)
+		return fmt.Errorf("change password of user %d: %w", id, err)
+	}
+
+	// Old sessions must not outlive the password because the client hung up.
+	if err := s.sessions.RevokeAllForUser(context.WithoutCancel(ctx), user.ID, sessionID, domain.RevokedPasswordChanged); err != nil {
+		debug.ErrorDebugContext(ctx, "Error while revoking sessions of user ID %d: %v", id, err)
+		return fmt.Errorf("revoke sessions of user %d: %w", id, err)
+	}
+
+	debug.LogDebugContext(ctx, "Successfully changed password of user ID: %d", id)
+	return nil
+}
+
+func invalidUserID() error {
+	return apperr.Validation("Invalid ID parameter", map[string]string{
+		"id": "ID must be a positive integer",
+	}).WithCode(apperr.CodeInvalidID)
+}
+
+// errorCode returns the code of the first *apperr.Error in err's chain.
+func errorCode(err error) string {
+	var e *apperr.Error
+	if errors.As(err, &e) {
+		return e.ErrorCode()
+	}
+	return ""
+}
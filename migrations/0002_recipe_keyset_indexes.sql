@@ -0,0 +1,5 @@
+-- Composite indexes backing keyset pagination on GET /recipes.
+-- Each sort column is paired with id, which is used as the tie-breaker.
+CREATE INDEX IF NOT EXISTS idx_recipes_rating_id ON recipes(rating, id) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_recipes_cook_time_id ON recipes(cook_time, id) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_recipes_created_at_id ON recipes(created_at, id) WHERE deleted_at IS NULL;
@@ -0,0 +1,21 @@
+-- Stock movement ledger. Every change of inventories.stock is recorded here so
+-- the current stock can be recomputed as SUM(delta).
+CREATE TABLE IF NOT EXISTS stock_movements (
+    id SERIAL PRIMARY KEY,
+    inventory_id INTEGER NOT NULL REFERENCES inventories(id) ON DELETE CASCADE,
+    delta INTEGER NOT NULL CHECK (delta <> 0),
+    reason VARCHAR(10) NOT NULL CHECK (reason IN ('receive', 'issue', 'adjust', 'scrap')),
+    reference VARCHAR(100) NOT NULL DEFAULT '',
+    actor_id INTEGER NULL,
+    stock_after INTEGER NOT NULL CHECK (stock_after >= 0),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_stock_movements_inventory_id ON stock_movements(inventory_id, id);
+
+-- Opening balance for inventories that existed before the ledger.
+INSERT INTO stock_movements (inventory_id, delta, reason, reference, stock_after)
+SELECT i.id, i.stock, 'adjust', 'opening balance', i.stock
+FROM inventories i
+WHERE i.stock <> 0
+  AND NOT EXISTS (SELECT 1 FROM stock_movements m WHERE m.inventory_id = i.id);
@@ -0,0 +1,3 @@
+-- Row versions used for optimistic concurrency control (ETag / If-Match).
+ALTER TABLE inventories ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE recipes ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
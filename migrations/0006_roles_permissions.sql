@@ -0,0 +1,36 @@
+-- Roles are named permission sets stored in the database instead of a CHECK
+-- constraint on users.role (GORM also auto-migrates these tables).
+CREATE TABLE IF NOT EXISTS roles (
+    name VARCHAR(50) PRIMARY KEY,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_name VARCHAR(50) NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+    permission VARCHAR(100) NOT NULL,
+    PRIMARY KEY (role_name, permission)
+);
+
+INSERT INTO roles (name, description) VALUES
+    ('admin', 'Manages inventories'),
+    ('superadmin', 'Full access')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permissions (role_name, permission) VALUES
+    ('admin', 'inventory:read'),
+    ('admin', 'inventory:write'),
+    ('admin', 'inventory:delete'),
+    ('admin', 'inventory:adjust'),
+    ('superadmin', 'inventory:read'),
+    ('superadmin', 'inventory:write'),
+    ('superadmin', 'inventory:delete'),
+    ('superadmin', 'inventory:adjust'),
+    ('superadmin', 'recipe:write'),
+    ('superadmin', 'recipe:delete'),
+    ('superadmin', 'user:manage')
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_role_check;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS fk_users_role;
+ALTER TABLE users ADD CONSTRAINT fk_users_role FOREIGN KEY (role) REFERENCES roles(name);
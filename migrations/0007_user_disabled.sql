@@ -0,0 +1,3 @@
+-- Disabled accounts cannot log in and their sessions are revoked.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled BOOLEAN NOT NULL DEFAULT FALSE;
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users(deleted_at);
@@ -0,0 +1,4 @@
+-- Accounts registered from now on start unverified. Existing accounts are
+-- treated as verified so nobody is locked out by the upgrade.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMPTZ NULL;
+UPDATE users SET email_verified_at = created_at WHERE email_verified_at IS NULL;
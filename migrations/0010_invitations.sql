@@ -0,0 +1,13 @@
+-- Invitations issued by superadmins; the invite code's jti is the id
+-- (GORM also auto-migrates this table).
+CREATE TABLE IF NOT EXISTS invitations (
+    id VARCHAR(64) PRIMARY KEY,
+    role VARCHAR(50) NOT NULL REFERENCES roles(name),
+    email VARCHAR(100),
+    created_by BIGINT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ NULL,
+    used_by BIGINT NULL,
+    revoked_at TIMESTAMPTZ NULL
+);
@@ -0,0 +1,22 @@
+-- Failed login counters (LOGIN_COUNTER_STORE=postgres) and the audit trail
+-- of failed logins (GORM also auto-migrates these tables).
+CREATE TABLE IF NOT EXISTS login_counters (
+    key VARCHAR(150) PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure_at TIMESTAMPTZ NOT NULL,
+    locked_until TIMESTAMPTZ NULL
+);
+
+CREATE TABLE IF NOT EXISTS login_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    email VARCHAR(100) NOT NULL,
+    user_id BIGINT NULL,
+    ip VARCHAR(45),
+    user_agent VARCHAR(255),
+    reason VARCHAR(30) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_attempts_email ON login_attempts (email);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_user_id ON login_attempts (user_id);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_created_at ON login_attempts (created_at);
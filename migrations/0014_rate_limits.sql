@@ -0,0 +1,8 @@
+-- GCRA buckets for RATE_LIMIT_STORE=postgres; tat is the theoretical arrival
+-- time in Unix nanoseconds (GORM also auto-migrates this table).
+CREATE TABLE IF NOT EXISTS rate_limits (
+    key VARCHAR(200) PRIMARY KEY,
+    tat BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_limits_tat ON rate_limits (tat);
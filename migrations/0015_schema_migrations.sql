@@ -0,0 +1,12 @@
+-- Applied migrations. /readyz compares the highest version with the newest
+-- migration the server was built with, so every migration from here on ends
+-- by recording its own version.
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INTEGER PRIMARY KEY,
+    applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- 0001 to 0014 predate the table and are recorded along with this one.
+INSERT INTO schema_migrations (version)
+SELECT generate_series(1, 15)
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,27 @@
+// Package migrations embeds the SQL migrations so the server knows the
+// schema version it was built for.
+package migrations
+
+import (
+	"embed"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// Latest returns the version of the newest migration, the number its file
+// name starts with.
+func Latest() int {
+	names, _ := fs.Glob(files, "*.sql")
+	latest := 0
+	for _, name := range names {
+		prefix, _, _ := strings.Cut(name, "_")
+		if v, err := strconv.Atoi(prefix); err == nil {
+			latest = max(latest, v)
+		}
+	}
+	return latest
+}
@@ -7,69 +7,102 @@ import (
 	"log"
 	"log/slog"
 	"os"
+	"strings"
 	"time"
 
-	_ "github.com/joho/godotenv"
+	"github.com/XSAM/otelsql"
 	_ "github.com/lib/pq"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
+	"gorm.io/plugin/opentelemetry/tracing"
 )
 
-func InitPostgres() *sql.DB {
-
-	host := os.Getenv("PG_HOST")
-	port := os.Getenv("PG_PORT")
-	user := os.Getenv("PG_USER")
-	pass := os.Getenv("PG_PASSWORD")
-	name := os.Getenv("PG_DBNAME")
+// Config holds the connection settings and pool limits of one connection
+// pool.
+type Config struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	Name     string
+	SSLMode  string
+	// LogLevel is the GORM log level: "silent", "error", "warn" or "info".
+	LogLevel string
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
 
-	if host == "" || port == "" || user == "" || name == "" {
-		log.Fatal("Missing environment variables")
-	}
+// dsn renders c as a keyword/value connection string, quoting every value so
+// passwords may contain spaces and quotes.
+func (c Config) dsn() string {
+	quote := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace
+	return fmt.Sprintf("host='%s' port=%d user='%s' password='%s' dbname='%s' sslmode='%s'",
+		quote(c.Host), c.Port, quote(c.User), quote(c.Password), quote(c.Name), quote(c.SSLMode))
+}
 
-	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable", host, port, user, pass, name)
+func (c Config) configurePool(db *sql.DB) {
+	db.SetMaxOpenConns(c.MaxOpenConns)
+	db.SetMaxIdleConns(c.MaxIdleConns)
+	db.SetConnMaxLifetime(c.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(c.ConnMaxIdleTime)
+}
 
-	db, err := sql.Open("postgres", dsn)
+func InitPostgres(cfg Config) *sql.DB {
+	// Every query becomes a span of the trace in its context.
+	db, err := otelsql.Open("postgres", cfg.dsn(),
+		otelsql.WithAttributes(semconv.DBSystemNamePostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{OmitConnResetSession: true, OmitRows: true}),
+	)
 	if err != nil {
 		log.Fatal("Failed to open database connection:", err)
 	}
 
-	// Configure connection pool
-	db.SetMaxOpenConns(25)
-	db.SetMaxIdleConns(5)
-	db.SetConnMaxLifetime(5 * time.Minute)
-	db.SetConnMaxIdleTime(10 * time.Minute)
+	cfg.configurePool(db)
 
 	if err := db.Ping(); err != nil {
 		log.Fatal("Failed to ping database", err)
 	}
 
 	slog.Info("PostgreSQL (database/sql) connected successfully",
-		slog.String("host", host),
-		slog.String("port", port),
-		slog.String("database", name),
+		slog.String("host", cfg.Host),
+		slog.Int("port", cfg.Port),
+		slog.String("database", cfg.Name),
 	)
 
 	return db
 }
 
-func InitPostgresGORM() *gorm.DB {
-
-	host := os.Getenv("PG_HOST")
-	port := os.Getenv("PG_PORT")
-	user := os.Getenv("PG_USER")
-	pass := os.Getenv("PG_PASSWORD")
-	name := os.Getenv("PG_DBNAME")
+// gormLogLevels maps Config.LogLevel to GORM log levels.
+var gormLogLevels = map[string]logger.LogLevel{
+	"silent": logger.Silent,
+	"error":  logger.Error,
+	"warn":   logger.Warn,
+	"info":   logger.Info,
+}
 
-	if host == "" || port == "" || user == "" || name == "" {
-		log.Fatal("Missing environment variables")
+// gormLogger logs statements at level with placeholders rather than their
+// values, which include password hashes, token hashes and TOTP secrets.
+func gormLogger(level string) logger.Interface {
+	l, ok := gormLogLevels[level]
+	if !ok {
+		l = logger.Warn
 	}
+	return logger.New(log.New(os.Stdout, "\r\n", log.LstdFlags), logger.Config{
+		SlowThreshold:        200 * time.Millisecond,
+		LogLevel:             l,
+		Colorful:             true,
+		ParameterizedQueries: true,
+	})
+}
 
-	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable", host, port, user, pass, name)
-
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
+func InitPostgresGORM(cfg Config) *gorm.DB {
+	db, err := gorm.Open(postgres.Open(cfg.dsn()), &gorm.Config{
+		Logger: gormLogger(cfg.LogLevel),
 		NowFunc: func() time.Time {
 			return time.Now().UTC()
 		},
@@ -79,27 +112,39 @@ func InitPostgresGORM() *gorm.DB {
 		log.Fatal("Failed to connect database:", err)
 	}
 
+	// GORM callbacks turn every statement into a span of the trace in its
+	// context.
+	if err := db.Use(tracing.NewPlugin(tracing.WithoutMetrics(), tracing.WithoutQueryVariables())); err != nil {
+		log.Fatal("Failed to install GORM tracing:", err)
+	}
+
 	sqlDB, err := db.DB()
 	if err != nil {
 		log.Fatal("Failed to get underlying SQL DB", err)
 	}
 
-	sqlDB.SetMaxOpenConns(25)
-	sqlDB.SetMaxIdleConns(5)
-	sqlDB.SetConnMaxLifetime(5 * time.Minute)
-	sqlDB.SetConnMaxIdleTime(10 * time.Minute)
+	cfg.configurePool(sqlDB)
 
 	slog.Info("PostgreSQL (GORM) connected successfully",
-		slog.String("host", host),
-		slog.String("port", port),
-		slog.String("database", name),
+		slog.String("host", cfg.Host),
+		slog.Int("port", cfg.Port),
+		slog.String("database", cfg.Name),
 	)
 
-	if err := db.AutoMigrate(&domain.User{}, &domain.Recipe{}); err != nil {
+	models := []any{&domain.Role{}, &domain.RolePermission{}, &domain.User{}, &domain.Recipe{}, &domain.Session{}, &domain.RefreshToken{}, &domain.UserToken{}, &domain.Invitation{}, &domain.LoginAttempt{}, &domain.LoginCounter{}, &domain.TOTPCredential{}, &domain.RecoveryCode{}, &domain.APIKey{}, &domain.RateLimitBucket{}}
+	if err := db.AutoMigrate(models...); err != nil {
 		log.Fatal("Migration failed")
 	}
 
-	slog.Info("Database tables migrated successfully (users, recipes)")
+	// Named from the models so the message cannot fall behind the list.
+	tables := make([]string, 0, len(models))
+	for _, m := range models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(m); err == nil {
+			tables = append(tables, stmt.Schema.Table)
+		}
+	}
+	slog.Info("Database tables migrated successfully", slog.Any("tables", tables))
 
 	return db
 }
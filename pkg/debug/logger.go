@@ -1,6 +1,11 @@
 package debug
 
-import "log"
+import (
+	"avenger/pkg/reqctx"
+	"context"
+	"fmt"
+	"log"
+)
 
 func LogDebug(msg string, args ...interface{}) {
 	log.Printf("[INFO] "+msg, args...)
@@ -9,3 +14,14 @@ func LogDebug(msg string, args ...interface{}) {
 func ErrorDebug(msg string, args ...interface{}) {
 	log.Printf("[ERROR] "+msg, args...)
 }
+
+// LogDebugContext is LogDebug through the logger of ctx, so the line
+// carries the request ID of the request being served.
+func LogDebugContext(ctx context.Context, msg string, args ...interface{}) {
+	reqctx.Logger(ctx).InfoContext(ctx, fmt.Sprintf(msg, args...))
+}
+
+// ErrorDebugContext is ErrorDebug through the logger of ctx.
+func ErrorDebugContext(ctx context.Context, msg string, args ...interface{}) {
+	reqctx.Logger(ctx).ErrorContext(ctx, fmt.Sprintf(msg, args...))
+}
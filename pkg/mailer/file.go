@@ -0,0 +1,55 @@
+package mailer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type fileMailer struct {
+	dir  string
+	from string
+}
+
+// NewFileMailer writes each message to dir as a .eml file instead of
+// sending it.
+func NewFileMailer(dir, from string) Mailer {
+	return &fileMailer{dir: dir, from: from}
+}
+
+func (m *fileMailer) Send(msg Message) error {
+	if err := os.MkdirAll(m.dir, 0o750); err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), hex.EncodeToString(suffix))
+	path := filepath.Join(m.dir, name)
+
+	if err := os.WriteFile(path, format(m.from, msg), 0o640); err != nil {
+		return err
+	}
+
+	slog.Info("Email written to file", slog.String("to", msg.To), slog.String("subject", msg.Subject), slog.String("path", path))
+	return nil
+}
+
+type logMailer struct{}
+
+// NewLogMailer logs messages, body included, instead of sending them. Only
+// use it in development: bodies may contain secrets such as reset links.
+func NewLogMailer() Mailer {
+	return logMailer{}
+}
+
+func (logMailer) Send(msg Message) error {
+	slog.Info("Email (not sent)", slog.String("to", msg.To), slog.String("subject", msg.Subject), slog.String("body", msg.Body))
+	return nil
+}
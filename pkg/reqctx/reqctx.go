@@ -0,0 +1,52 @@
+// Package reqctx carries per-request values (request ID, client IP and the
+// request scoped logger) through a context.Context, so every layer can log
+// lines that are correlated with the request that caused them.
+package reqctx
+
+import (
+	"context"
+	"log/slog"
+)
+
+type key int
+
+const (
+	requestIDKey key = iota
+	clientIPKey
+	loggerKey
+)
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the ID of the request ctx belongs to, empty outside a
+// request.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey, ip)
+}
+
+// ClientIP returns the client address resolved by the real IP middleware,
+// empty when it did not run.
+func ClientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey).(string)
+	return ip
+}
+
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// Logger returns the request scoped logger, slog.Default() when there is
+// none.
+func Logger(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
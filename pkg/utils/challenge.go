@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ChallengeAudience marks the short-lived tokens handed out between the
+// password and the second factor of a login.
+const ChallengeAudience = "2fa"
+
+// GenerateChallengeToken signs a token proving userID passed the password
+// step. It is only accepted by ValidateChallengeToken.
+func GenerateChallengeToken(userID int, ttl time.Duration) (string, error) {
+	if keySet == nil {
+		return "", errNoKeySet
+	}
+
+	jti, err := RandomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	return keySet.Sign(&jwt.RegisteredClaims{
+		ID:        jti,
+		Subject:   strconv.Itoa(userID),
+		Audience:  jwt.ClaimStrings{ChallengeAudience},
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+	})
+}
+
+// ValidateChallengeToken verifies a challenge token and returns its user ID.
+func ValidateChallengeToken(token string) (int, error) {
+	if keySet == nil {
+		return 0, errNoKeySet
+	}
+
+	parsed, err := keySet.Parse(token, &jwt.RegisteredClaims{}, jwt.WithAudience(ChallengeAudience), jwt.WithExpirationRequired())
+	if err != nil {
+		return 0, err
+	}
+
+	claims, ok := parsed.Claims.(*jwt.RegisteredClaims)
+	if !ok || !parsed.Valid {
+		return 0, errors.New("invalid challenge token")
+	}
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil || userID <= 0 {
+		return 0, errors.New("invalid challenge token")
+	}
+	return userID, nil
+}
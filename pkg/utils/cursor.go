@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+// EncodeCursor serializes v into an opaque, URL-safe pagination cursor.
+func EncodeCursor(v any) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// DecodeCursor reverses EncodeCursor into v.
+func DecodeCursor(cursor string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return errors.New("malformed cursor")
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return errors.New("malformed cursor")
+	}
+	return nil
+}
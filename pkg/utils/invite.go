@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// InviteAudience marks invite codes so they are never accepted as access
+// tokens and vice versa.
+const InviteAudience = "invite"
+
+// InviteClaim is the payload of an invite code. ID ("jti") names the
+// invitation record that makes the code single use.
+type InviteClaim struct {
+	Role  string `json:"role"`
+	Email string `json:"email,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// GenerateInviteCode signs claims with the access token keys. id becomes the
+// jti; the code expires at expiresAt.
+func GenerateInviteCode(id string, claims *InviteClaim, expiresAt time.Time) (string, error) {
+	if keySet == nil {
+		return "", errNoKeySet
+	}
+
+	now := time.Now()
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        id,
+		Audience:  jwt.ClaimStrings{InviteAudience},
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+	}
+	return keySet.Sign(claims)
+}
+
+// ValidateInviteCode verifies the signature, expiry and audience of code.
+// Whether the invitation was already used is up to the caller.
+func ValidateInviteCode(code string) (*InviteClaim, error) {
+	if keySet == nil {
+		return nil, errNoKeySet
+	}
+
+	token, err := keySet.Parse(code, &InviteClaim{}, jwt.WithAudience(InviteAudience), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*InviteClaim)
+	if !ok || !token.Valid || claims.ID == "" {
+		return nil, errors.New("invalid invite code")
+	}
+	return claims, nil
+}
@@ -2,55 +2,79 @@ package utils
 
 import (
 	"errors"
-	"log"
-	"os"
+	"slices"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func getSecretKey() []byte {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		log.Println("WARNING: JWT_SECRET not set")
-		secret = "default-secret"
-	}
-	return []byte(secret)
+var keySet *KeySet
+
+// SetKeySet installs the keys used by GenerateJWT and ValidateToken. It must
+// be called once at startup; there is no default key.
+func SetKeySet(ks *KeySet) {
+	keySet = ks
+}
+
+// CurrentKeySet returns the keys installed by SetKeySet, or nil.
+func CurrentKeySet() *KeySet {
+	return keySet
 }
 
+var errNoKeySet = errors.New("JWT signing keys are not configured")
+
+// JWTClaim is the payload of an access token. SessionID ("sid") names the
+// login session the token belongs to; ID ("jti") is unique per token.
+// Permissions ("perms") are resolved from the user's role when the token is
+// issued. Requests authenticated with an API key get claims with APIKeyID set
+// and no user or session; they are never signed.
 type JWTClaim struct {
-	UserID int    `json:"user_id"`
-	Role   string `json:"role"`
+	UserID      int      `json:"user_id"`
+	Role        string   `json:"role"`
+	SessionID   string   `json:"sid"`
+	Permissions []string `json:"perms,omitempty"`
+	APIKeyID    uint     `json:"-"`
 	jwt.RegisteredClaims
 }
 
-func GenerateJWT(userID int, role string) (string, error) {
-	claims := &JWTClaim{
-		UserID: userID,
-		Role:   role,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(2 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
-		},
-	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedToken, err := token.SignedString(getSecretKey())
+// IsAPIKey reports whether the claims describe an API key rather than a
+// logged in user.
+func (c *JWTClaim) IsAPIKey() bool {
+	return c.APIKeyID != 0
+}
+
+// HasPermission reports whether the token grants perm.
+func (c *JWTClaim) HasPermission(perm string) bool {
+	return slices.Contains(c.Permissions, perm)
+}
+
+// GenerateJWT signs claims as an access token valid for ttl. The registered
+// claims (jti, iat, nbf, exp) are filled in.
+func GenerateJWT(claims *JWTClaim, ttl time.Duration) (string, error) {
+	jti, err := RandomToken(16)
 	if err != nil {
 		return "", err
 	}
 
-	return signedToken, nil
+	now := time.Now()
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        jti,
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+	}
+	if keySet == nil {
+		return "", errNoKeySet
+	}
+	return keySet.Sign(claims)
 }
 
 func ValidateToken(tokenString string) (*JWTClaim, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &JWTClaim{}, func(t *jwt.Token) (any, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
-		}
-		return getSecretKey(), nil
-	})
+	if keySet == nil {
+		return nil, errNoKeySet
+	}
 
+	token, err := keySet.Parse(tokenString, &JWTClaim{})
 	if err != nil {
 		return nil, err
 	}
@@ -59,6 +83,11 @@ func ValidateToken(tokenString string) (*JWTClaim, error) {
 		if claims.ExpiresAt != nil && claims.ExpiresAt.Before(time.Now()) {
 			return nil, errors.New("token has expired")
 		}
+		// Invite codes and 2FA challenges carry an audience; access
+		// tokens never do.
+		if len(claims.Audience) > 0 {
+			return nil, errors.New("token is not an access token")
+		}
 		return claims, nil
 	}
 
@@ -0,0 +1,229 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minHMACSecretLen is the shortest JWT_SECRET accepted for HS256.
+const minHMACSecretLen = 32
+
+// SigningKey is one key of a KeySet. Keys loaded from a public key PEM can
+// only verify; they are kept so tokens signed before a rotation stay valid
+// until they expire.
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	private any
+	public  any
+}
+
+func (k *SigningKey) CanSign() bool {
+	return k.private != nil
+}
+
+// KeySet holds the keys used to sign and verify access tokens. Tokens are
+// signed with the active key and carry its ID in the "kid" header; any key
+// in the set verifies tokens bearing its ID.
+type KeySet struct {
+	active *SigningKey
+	keys   map[string]*SigningKey
+}
+
+// LoadKeySet reads every *.pem file in dir. The key ID is the file name
+// without the ".pem" (and optional ".pub") suffix. RSA keys sign with RS256
+// and Ed25519 keys with EdDSA. activeKID selects the signing key and may be
+// empty when dir holds exactly one private key.
+//
+// To rotate, add the new key, point activeKID at it and restart; keep the
+// old file (its public half is enough) until the access token TTL has
+// passed, then remove it.
+func LoadKeySet(dir, activeKID string) (*KeySet, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no *.pem keys found in %s", dir)
+	}
+	sort.Strings(paths)
+
+	ks := &KeySet{keys: map[string]*SigningKey{}}
+	var signers []*SigningKey
+	for _, path := range paths {
+		kid := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(path), ".pem"), ".pub")
+		if _, dup := ks.keys[kid]; dup {
+			return nil, fmt.Errorf("duplicate key id %q in %s", kid, dir)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key, err := parseSigningKey(kid, data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		ks.keys[kid] = key
+		if key.CanSign() {
+			signers = append(signers, key)
+		}
+	}
+
+	switch {
+	case activeKID != "":
+		key, ok := ks.keys[activeKID]
+		if !ok {
+			return nil, fmt.Errorf("active key %q not found in %s", activeKID, dir)
+		}
+		if !key.CanSign() {
+			return nil, fmt.Errorf("active key %q has no private key", activeKID)
+		}
+		ks.active = key
+	case len(signers) == 1:
+		ks.active = signers[0]
+	default:
+		return nil, fmt.Errorf("%d private keys found in %s, set the active key id", len(signers), dir)
+	}
+
+	return ks, nil
+}
+
+// NewHMACKeySet returns a single HS256 key set. HMAC keys are secret, so
+// the set publishes no JWKS and other services cannot verify its tokens.
+func NewHMACKeySet(secret []byte) (*KeySet, error) {
+	if len(secret) < minHMACSecretLen {
+		return nil, fmt.Errorf("HMAC secret must be at least %d bytes", minHMACSecretLen)
+	}
+	key := &SigningKey{ID: "hs256", Method: jwt.SigningMethodHS256, private: secret, public: secret}
+	return &KeySet{active: key, keys: map[string]*SigningKey{key.ID: key}}, nil
+}
+
+func parseSigningKey(kid string, data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var parsed any
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &SigningKey{ID: kid}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Method, key.private, key.public = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.Method, key.public = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.Method, key.private, key.public = jwt.SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.Method, key.public = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, use RSA or Ed25519", parsed)
+	}
+
+	if pub, ok := key.public.(*rsa.PublicKey); ok && pub.N.BitLen() < 2048 {
+		return nil, fmt.Errorf("RSA key is %d bits, at least 2048 required", pub.N.BitLen())
+	}
+	return key, nil
+}
+
+// Sign signs claims with the active key.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.active.Method, claims)
+	token.Header["kid"] = ks.active.ID
+	return token.SignedString(ks.active.private)
+}
+
+// Parse verifies tokenString against the key named by its "kid" header and
+// decodes it into claims. The algorithm must be the one of that key.
+func (ks *KeySet) Parse(tokenString string, claims jwt.Claims, opts ...jwt.ParserOption) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, ok := ks.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+		if t.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %s for key %q", t.Method.Alg(), kid)
+		}
+		return key.public, nil
+	}, opts...)
+}
+
+// JWK is a public key in RFC 7517 JSON Web Key format.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the set, active key first. Symmetric keys
+// are never published.
+func (ks *KeySet) JWKS() JWKS {
+	ids := make([]string, 0, len(ks.keys))
+	for id := range ks.keys {
+		if id != ks.active.ID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	ids = append([]string{ks.active.ID}, ids...)
+
+	set := JWKS{Keys: []JWK{}}
+	enc := base64.RawURLEncoding
+	for _, id := range ids {
+		key := ks.keys[id]
+		jwk := JWK{Kid: id, Use: "sig", Alg: key.Method.Alg()}
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = enc.EncodeToString(pub.N.Bytes())
+			jwk.E = enc.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = enc.EncodeToString(pub)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
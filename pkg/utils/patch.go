@@ -0,0 +1,336 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	MergePatchContentType = "application/merge-patch+json"
+	JSONPatchContentType  = "application/json-patch+json"
+)
+
+// PatchOperation is a single RFC 6902 JSON Patch operation.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// MergePatch applies an RFC 7396 JSON Merge Patch to doc. It also returns the
+// top-level members touched by the patch.
+func MergePatch(doc, patch []byte) ([]byte, []string, error) {
+	var p any
+	if err := decodeJSON(patch, &p); err != nil {
+		return nil, nil, errors.New("patch must be valid JSON")
+	}
+
+	obj, ok := p.(map[string]any)
+	if !ok {
+		return nil, nil, errors.New("merge patch must be a JSON object")
+	}
+
+	var target any
+	if err := decodeJSON(doc, &target); err != nil {
+		return nil, nil, err
+	}
+
+	fields := make([]string, 0, len(obj))
+	for k := range obj {
+		fields = append(fields, k)
+	}
+
+	out, err := json.Marshal(mergeValue(target, p))
+	return out, fields, err
+}
+
+func mergeValue(target, patch any) any {
+	patchObj, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObj, ok := target.(map[string]any)
+	if !ok {
+		targetObj = map[string]any{}
+	}
+
+	for k, v := range patchObj {
+		if v == nil {
+			delete(targetObj, k)
+			continue
+		}
+		targetObj[k] = mergeValue(targetObj[k], v)
+	}
+
+	return targetObj
+}
+
+// errPatchRoot rejects operations on the whole document, which would
+// replace every member without reporting any as touched.
+var errPatchRoot = errors.New("cannot patch the document root")
+
+// ApplyJSONPatch applies an RFC 6902 JSON Patch document to doc. It also
+// returns the top-level members touched by the operations. Operations other
+// than test may not target the document root, so every change is reported.
+func ApplyJSONPatch(doc, patch []byte) ([]byte, []string, error) {
+	var ops []PatchOperation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, nil, errors.New("JSON patch must be an array of operations")
+	}
+	if len(ops) == 0 {
+		return nil, nil, errors.New("JSON patch must contain at least one operation")
+	}
+
+	var target any
+	if err := decodeJSON(doc, &target); err != nil {
+		return nil, nil, err
+	}
+
+	var fields []string
+	touch := func(path string) {
+		if tokens, err := parsePointer(path); err == nil && len(tokens) > 0 && !slices.Contains(fields, tokens[0]) {
+			fields = append(fields, tokens[0])
+		}
+	}
+
+	for i, op := range ops {
+		if (op.Path == "" && op.Op != "test") || (op.From == "" && (op.Op == "move" || op.Op == "copy")) {
+			return nil, nil, fmt.Errorf("operation %d: %w", i, errPatchRoot)
+		}
+
+		var err error
+		switch op.Op {
+		case "add", "replace", "test":
+			var value any
+			if len(op.Value) == 0 {
+				return nil, nil, fmt.Errorf("operation %d: %s requires a value", i, op.Op)
+			}
+			if err := decodeJSON(op.Value, &value); err != nil {
+				return nil, nil, fmt.Errorf("operation %d: value must be valid JSON", i)
+			}
+			switch op.Op {
+			case "add":
+				target, err = pointerAdd(target, op.Path, value)
+			case "replace":
+				if _, err = pointerGet(target, op.Path); err == nil {
+					target, err = pointerReplace(target, op.Path, value)
+				}
+			case "test":
+				var current any
+				if current, err = pointerGet(target, op.Path); err == nil && !reflect.DeepEqual(current, value) {
+					err = fmt.Errorf("test failed at %q", op.Path)
+				}
+			}
+		case "remove":
+			target, _, err = pointerRemove(target, op.Path)
+		case "move":
+			var value any
+			if target, value, err = pointerRemove(target, op.From); err == nil {
+				target, err = pointerAdd(target, op.Path, value)
+			}
+			touch(op.From)
+		case "copy":
+			var value any
+			if value, err = pointerGet(target, op.From); err == nil {
+				target, err = pointerAdd(target, op.Path, value)
+			}
+		default:
+			err = fmt.Errorf("unsupported op %q", op.Op)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+		if op.Op != "test" {
+			touch(op.Path)
+		}
+	}
+
+	out, err := json.Marshal(target)
+	return out, fields, err
+}
+
+func decodeJSON(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into unescaped tokens.
+func parsePointer(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("invalid path %q", path)
+	}
+
+	tokens := strings.Split(path[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if allowEnd && token == "-" {
+		return length, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > length || (!allowEnd && i == length) {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	return i, nil
+}
+
+func pointerGet(doc any, path string) (any, error) {
+	tokens, err := parsePointer(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cur := doc
+	for _, t := range tokens {
+		switch node := cur.(type) {
+		case map[string]any:
+			v, ok := node[t]
+			if !ok {
+				return nil, fmt.Errorf("path %q does not exist", path)
+			}
+			cur = v
+		case []any:
+			i, err := arrayIndex(t, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			cur = node[i]
+		default:
+			return nil, fmt.Errorf("path %q does not exist", path)
+		}
+	}
+	return cur, nil
+}
+
+// pointerUpdate walks to the parent of path and lets fn rewrite the
+// container holding the last token. It returns the possibly new root.
+func pointerUpdate(doc any, path string, fn func(parent any, token string) (any, error)) (any, error) {
+	tokens, err := parsePointer(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return fn(nil, "")
+	}
+
+	var walk func(node any, tokens []string) (any, error)
+	walk = func(node any, tokens []string) (any, error) {
+		if len(tokens) == 1 {
+			return fn(node, tokens[0])
+		}
+		switch n := node.(type) {
+		case map[string]any:
+			child, ok := n[tokens[0]]
+			if !ok {
+				return nil, fmt.Errorf("path %q does not exist", path)
+			}
+			updated, err := walk(child, tokens[1:])
+			if err != nil {
+				return nil, err
+			}
+			n[tokens[0]] = updated
+			return n, nil
+		case []any:
+			i, err := arrayIndex(tokens[0], len(n), false)
+			if err != nil {
+				return nil, err
+			}
+			updated, err := walk(n[i], tokens[1:])
+			if err != nil {
+				return nil, err
+			}
+			n[i] = updated
+			return n, nil
+		default:
+			return nil, fmt.Errorf("path %q does not exist", path)
+		}
+	}
+
+	return walk(doc, tokens)
+}
+
+func pointerAdd(doc any, path string, value any) (any, error) {
+	return pointerUpdate(doc, path, func(parent any, token string) (any, error) {
+		switch n := parent.(type) {
+		case nil:
+			return value, nil
+		case map[string]any:
+			n[token] = value
+			return n, nil
+		case []any:
+			i, err := arrayIndex(token, len(n), true)
+			if err != nil {
+				return nil, err
+			}
+			n = append(n, nil)
+			copy(n[i+1:], n[i:])
+			n[i] = value
+			return n, nil
+		default:
+			return nil, fmt.Errorf("cannot add at %q", path)
+		}
+	})
+}
+
+func pointerReplace(doc any, path string, value any) (any, error) {
+	return pointerUpdate(doc, path, func(parent any, token string) (any, error) {
+		switch n := parent.(type) {
+		case nil:
+			return value, nil
+		case map[string]any:
+			n[token] = value
+			return n, nil
+		case []any:
+			i, err := arrayIndex(token, len(n), false)
+			if err != nil {
+				return nil, err
+			}
+			n[i] = value
+			return n, nil
+		default:
+			return nil, fmt.Errorf("cannot replace at %q", path)
+		}
+	})
+}
+
+func pointerRemove(doc any, path string) (any, any, error) {
+	var removed any
+	out, err := pointerUpdate(doc, path, func(parent any, token string) (any, error) {
+		switch n := parent.(type) {
+		case map[string]any:
+			v, ok := n[token]
+			if !ok {
+				return nil, fmt.Errorf("path %q does not exist", path)
+			}
+			removed = v
+			delete(n, token)
+			return n, nil
+		case []any:
+			i, err := arrayIndex(token, len(n), false)
+			if err != nil {
+				return nil, err
+			}
+			removed = n[i]
+			return append(n[:i], n[i+1:]...), nil
+		default:
+			return nil, fmt.Errorf("cannot remove %q", path)
+		}
+	})
+	return out, removed, err
+}
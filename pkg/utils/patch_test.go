@@ -0,0 +1,238 @@
+package utils
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// jsonEqual reports whether a and b hold the same JSON value.
+func jsonEqual(t *testing.T, a, b string) bool {
+	t.Helper()
+	var va, vb any
+	if err := decodeJSON([]byte(a), &va); err != nil {
+		t.Fatalf("decode %s: %v", a, err)
+	}
+	if err := decodeJSON([]byte(b), &vb); err != nil {
+		t.Fatalf("decode %s: %v", b, err)
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// TestApplyJSONPatchRFC6902 runs the examples of RFC 6902 Appendix A.
+func TestApplyJSONPatchRFC6902(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string // empty when the patch must fail
+	}{
+		{
+			name:  "A.1 adding an object member",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/baz","value":"qux"}]`,
+			want:  `{"baz":"qux","foo":"bar"}`,
+		},
+		{
+			name:  "A.2 adding an array element",
+			doc:   `{"foo":["bar","baz"]}`,
+			patch: `[{"op":"add","path":"/foo/1","value":"qux"}]`,
+			want:  `{"foo":["bar","qux","baz"]}`,
+		},
+		{
+			name:  "A.3 removing an object member",
+			doc:   `{"baz":"qux","foo":"bar"}`,
+			patch: `[{"op":"remove","path":"/baz"}]`,
+			want:  `{"foo":"bar"}`,
+		},
+		{
+			name:  "A.4 removing an array element",
+			doc:   `{"foo":["bar","qux","baz"]}`,
+			patch: `[{"op":"remove","path":"/foo/1"}]`,
+			want:  `{"foo":["bar","baz"]}`,
+		},
+		{
+			name:  "A.5 replacing a value",
+			doc:   `{"baz":"qux","foo":"bar"}`,
+			patch: `[{"op":"replace","path":"/baz","value":"boo"}]`,
+			want:  `{"baz":"boo","foo":"bar"}`,
+		},
+		{
+			name:  "A.6 moving a value",
+			doc:   `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			patch: `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			want:  `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`,
+		},
+		{
+			name:  "A.7 moving an array element",
+			doc:   `{"foo":["all","grass","cows","eat"]}`,
+			patch: `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`,
+			want:  `{"foo":["all","cows","eat","grass"]}`,
+		},
+		{
+			name: "A.8 testing a value: success",
+			doc:  `{"baz":"qux","foo":["a",2,"c"]}`,
+			patch: `[{"op":"test","path":"/baz","value":"qux"},
+				{"op":"test","path":"/foo/1","value":2}]`,
+			want: `{"baz":"qux","foo":["a",2,"c"]}`,
+		},
+		{
+			name:  "A.9 testing a value: error",
+			doc:   `{"baz":"qux"}`,
+			patch: `[{"op":"test","path":"/baz","value":"bar"}]`,
+		},
+		{
+			name:  "A.10 adding a nested member object",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`,
+			want:  `{"foo":"bar","child":{"grandchild":{}}}`,
+		},
+		{
+			name:  "A.11 ignoring unrecognized elements",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`,
+			want:  `{"foo":"bar","baz":"qux"}`,
+		},
+		{
+			name:  "A.12 adding to a nonexistent target",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/baz/bat","value":"qux"}]`,
+		},
+		// A.13 (a duplicate "op" member) is not detectable with
+		// encoding/json, which keeps the last one.
+		{
+			name:  "A.14 ~ escape ordering",
+			doc:   `{"/":9,"~1":10}`,
+			patch: `[{"op":"test","path":"/~01","value":10}]`,
+			want:  `{"/":9,"~1":10}`,
+		},
+		{
+			name:  "A.15 comparing strings and numbers",
+			doc:   `{"/":9,"~1":10}`,
+			patch: `[{"op":"test","path":"/~01","value":"10"}]`,
+		},
+		{
+			name:  "A.16 adding an array value",
+			doc:   `{"foo":["bar"]}`,
+			patch: `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`,
+			want:  `{"foo":["bar",["abc","def"]]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := ApplyJSONPatch([]byte(tt.doc), []byte(tt.patch))
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("ApplyJSONPatch() = %s, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ApplyJSONPatch() error = %v", err)
+			}
+			if !jsonEqual(t, string(got), tt.want) {
+				t.Errorf("ApplyJSONPatch() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyJSONPatchFields(t *testing.T) {
+	doc := `{"name":"flour","code":"FL-1","stock":{"qty":3}}`
+	tests := []struct {
+		name  string
+		patch string
+		want  []string
+	}{
+		{"replace", `[{"op":"replace","path":"/name","value":"rye"}]`, []string{"name"}},
+		{"nested", `[{"op":"replace","path":"/stock/qty","value":4}]`, []string{"stock"}},
+		{"move records both", `[{"op":"move","from":"/name","path":"/code"}]`, []string{"name", "code"}},
+		{"test touches nothing", `[{"op":"test","path":"/name","value":"flour"}]`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, fields, err := ApplyJSONPatch([]byte(doc), []byte(tt.patch))
+			if err != nil {
+				t.Fatalf("ApplyJSONPatch() error = %v", err)
+			}
+			slices.Sort(fields)
+			want := slices.Sorted(slices.Values(tt.want))
+			if !slices.Equal(fields, want) {
+				t.Errorf("fields = %v, want %v", fields, want)
+			}
+		})
+	}
+}
+
+func TestApplyJSONPatchRejects(t *testing.T) {
+	doc := `{"name":"flour","code":"FL-1","age":30}`
+	tests := []struct {
+		name  string
+		patch string
+	}{
+		{"empty array", `[]`},
+		{"not an array", `{"op":"replace","path":"/name","value":""}`},
+		{"replace root", `[{"op":"replace","path":"","value":{"name":"","code":"","age":0}}]`},
+		{"add root", `[{"op":"add","path":"","value":{}}]`},
+		{"remove root", `[{"op":"remove","path":""}]`},
+		{"copy from root", `[{"op":"copy","from":"","path":"/name"}]`},
+		{"move from root", `[{"op":"move","from":"","path":"/name"}]`},
+		{"root after a valid op", `[{"op":"replace","path":"/name","value":"rye"},{"op":"replace","path":"","value":{}}]`},
+		{"missing value", `[{"op":"add","path":"/name"}]`},
+		{"unknown op", `[{"op":"frobnicate","path":"/name"}]`},
+		{"relative path", `[{"op":"replace","path":"name","value":"rye"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, fields, err := ApplyJSONPatch([]byte(doc), []byte(tt.patch))
+			if err == nil {
+				t.Fatalf("ApplyJSONPatch() = %s, %v, want an error", got, fields)
+			}
+		})
+	}
+}
+
+func TestApplyJSONPatchTestsRoot(t *testing.T) {
+	doc := `{"name":"flour"}`
+	if _, _, err := ApplyJSONPatch([]byte(doc), []byte(`[{"op":"test","path":"","value":{"name":"flour"}}]`)); err != nil {
+		t.Fatalf("test on the root: error = %v", err)
+	}
+	if _, _, err := ApplyJSONPatch([]byte(doc), []byte(`[{"op":"test","path":"","value":{"name":"rye"}}]`)); err == nil {
+		t.Fatal("failing test on the root: want an error")
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		doc, patch string
+		want       string
+		fields     []string
+	}{
+		{"replace", `{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`, []string{"a"}},
+		{"add", `{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`, []string{"b"}},
+		{"delete", `{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`, []string{"a"}},
+		{"nested", `{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`, []string{"a"}},
+		{"array replaced", `{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`, []string{"a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, fields, err := MergePatch([]byte(tt.doc), []byte(tt.patch))
+			if err != nil {
+				t.Fatalf("MergePatch() error = %v", err)
+			}
+			if !jsonEqual(t, string(got), tt.want) {
+				t.Errorf("MergePatch() = %s, want %s", got, tt.want)
+			}
+			slices.Sort(fields)
+			if !slices.Equal(fields, tt.fields) {
+				t.Errorf("fields = %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+
+	if _, _, err := MergePatch([]byte(`{"a":1}`), []byte(`["a"]`)); err == nil {
+		t.Error("MergePatch() with a non-object patch: want an error")
+	}
+}
@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of RFC 6238 Appendix B, "12345678901234567890"
+// in ASCII, in the unpadded base32 secrets are stored as.
+var rfc6238Secret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+// TestTOTPCodeRFC6238 checks the SHA-1 vectors of RFC 6238 Appendix B. The
+// RFC lists 8 digit codes; 6 digit codes are their last six digits.
+func TestTOTPCodeRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string // 8 digit code from the RFC
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tt := range tests {
+		now := time.Unix(tt.unix, 0).UTC()
+		want := tt.want[len(tt.want)-TOTPDigits:]
+
+		got, err := TOTPCode(rfc6238Secret, TOTPStep(now))
+		if err != nil {
+			t.Fatalf("TOTPCode(T=%d) error = %v", tt.unix, err)
+		}
+		if got != want {
+			t.Errorf("TOTPCode(T=%d) = %s, want %s", tt.unix, got, want)
+		}
+
+		step, ok := ValidateTOTP(rfc6238Secret, want, now, 0)
+		if !ok || step != TOTPStep(now) {
+			t.Errorf("ValidateTOTP(T=%d, %s) = %d, %v, want %d, true", tt.unix, want, step, ok, TOTPStep(now))
+		}
+	}
+}
+
+func TestTOTPCodeLowercaseSecret(t *testing.T) {
+	lower := []byte(rfc6238Secret)
+	for i, c := range lower {
+		if c >= 'A' && c <= 'Z' {
+			lower[i] = c + 'a' - 'A'
+		}
+	}
+	got, err := TOTPCode(string(lower), 1)
+	if err != nil || got != "287082" {
+		t.Errorf("TOTPCode(lowercase secret) = %s, %v, want 287082", got, err)
+	}
+}
+
+func TestTOTPCodeInvalidSecret(t *testing.T) {
+	if _, err := TOTPCode("not base32!", 1); err == nil {
+		t.Error("TOTPCode(invalid secret): want an error")
+	}
+	if _, ok := ValidateTOTP("not base32!", "287082", time.Unix(59, 0), 0); ok {
+		t.Error("ValidateTOTP(invalid secret) = true, want false")
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0) // step 37037037
+	current := TOTPStep(now)
+	code := func(step int64) string {
+		c, err := TOTPCode(rfc6238Secret, step)
+		if err != nil {
+			t.Fatalf("TOTPCode(%d) error = %v", step, err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name   string
+		step   int64
+		wantOK bool
+	}{
+		{"current step", current, true},
+		{"one step behind", current - 1, true},
+		{"one step ahead", current + 1, true},
+		{"two steps behind", current - 2, false},
+		{"two steps ahead", current + 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := ValidateTOTP(rfc6238Secret, code(tt.step), now, 0)
+			if ok != tt.wantOK {
+				t.Fatalf("ValidateTOTP() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && step != tt.step {
+				t.Errorf("ValidateTOTP() step = %d, want %d", step, tt.step)
+			}
+		})
+	}
+}
+
+func TestValidateTOTPReplay(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, err := TOTPCode(rfc6238Secret, TOTPStep(now))
+	if err != nil {
+		t.Fatalf("TOTPCode() error = %v", err)
+	}
+
+	step, ok := ValidateTOTP(rfc6238Secret, code, now, 0)
+	if !ok {
+		t.Fatal("first use: ValidateTOTP() = false, want true")
+	}
+	if _, ok := ValidateTOTP(rfc6238Secret, code, now, step); ok {
+		t.Error("replay in the same step: ValidateTOTP() = true, want false")
+	}
+	if _, ok := ValidateTOTP(rfc6238Secret, code, now.Add(TOTPPeriod*time.Second), step); ok {
+		t.Error("replay in the next step: ValidateTOTP() = true, want false")
+	}
+
+	// A code from the previous step is refused once a later one was used.
+	previous, err := TOTPCode(rfc6238Secret, step-1)
+	if err != nil {
+		t.Fatalf("TOTPCode() error = %v", err)
+	}
+	if _, ok := ValidateTOTP(rfc6238Secret, previous, now, step); ok {
+		t.Error("earlier step after a later one: ValidateTOTP() = true, want false")
+	}
+}
+
+func TestValidateTOTPFormat(t *testing.T) {
+	now := time.Unix(59, 0)
+	tests := []struct {
+		name   string
+		code   string
+		wantOK bool
+	}{
+		{"exact", "287082", true},
+		{"surrounding space", " 287082 ", true},
+		{"grouped", "287 082", true},
+		{"too short", "28708", false},
+		{"too long", "94287082", false},
+		{"wrong code", "287083", false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := ValidateTOTP(rfc6238Secret, tt.code, now, 0); ok != tt.wantOK {
+				t.Errorf("ValidateTOTP(%q) = %v, want %v", tt.code, ok, tt.wantOK)
+			}
+		})
+	}
+}